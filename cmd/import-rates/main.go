@@ -0,0 +1,68 @@
+// Command import-rates loads exchange rates from a file into the database,
+// so that currency conversion never depends on a live service.
+//
+// Usage:
+//
+//	import-rates -format csv -file rates.csv
+//	import-rates -format ecb -file eurofxref-hist.xml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/beanpay/api/database"
+	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/exchange"
+	"github.com/joho/godotenv"
+	"os"
+)
+
+func main() {
+	godotenv.Load()
+
+	format := flag.String("format", "csv", "The format of the rates file (csv or ecb)")
+	file := flag.String("file", "", "The path of the rates file")
+	flag.Parse()
+	if *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Parse the rates
+	f, err := os.Open(*file)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	var rates []*models.ExchangeRate
+	switch *format {
+	case "csv":
+		rates, err = exchange.ParseCSV(f)
+	case "ecb":
+		rates, err = exchange.ParseECB(f)
+	default:
+		err = fmt.Errorf("Unsupported format: %v", *format)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	// Store them
+	db, err := database.NewConnection(
+		os.Getenv("POSTGRES_URL"),
+		database.Config{
+			MigrationsDir: "./database/migrations",
+		},
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	// All at once, so that a failed import leaves the stored rates as they were
+	rateRepo := models.ExchangeRateRepository{DB: db}
+	err = rateRepo.UpsertAll(rates)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Imported %v exchange rates.\n", len(rates))
+}
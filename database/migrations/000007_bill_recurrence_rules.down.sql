@@ -0,0 +1,13 @@
+ALTER TABLE bills
+  DROP CONSTRAINT bills_end_date_check,
+  DROP COLUMN occurrence_limit,
+  DROP COLUMN end_date,
+  DROP COLUMN recurrence_unit,
+  DROP COLUMN recurrence_interval;
+
+/* The original enum cannot represent the newer frequencies, so
+ * those bills fall back to the closest cadence it does support. */
+ALTER TABLE bills DROP CONSTRAINT bills_frequency_check;
+UPDATE bills SET frequency = 'monthly' WHERE frequency IN ('weekly', 'biweekly', 'custom');
+CREATE TYPE billfrequency AS ENUM ('monthly', 'quarterly', 'biannually', 'annually');
+ALTER TABLE bills ALTER COLUMN frequency TYPE billfrequency USING frequency::billfrequency;
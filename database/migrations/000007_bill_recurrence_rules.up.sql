@@ -0,0 +1,29 @@
+/* Frequencies are now a shorthand for a recurrence interval & unit, so
+ * the closed billfrequency enum is replaced by a checked text column. */
+ALTER TABLE bills ALTER COLUMN frequency TYPE text USING frequency::text;
+DROP TYPE billfrequency;
+ALTER TABLE bills ADD CONSTRAINT bills_frequency_check
+  CHECK (frequency IN ('weekly', 'biweekly', 'monthly', 'quarterly', 'biannually', 'annually', 'custom'));
+
+ALTER TABLE bills
+  ADD COLUMN recurrence_interval   integer   NOT NULL DEFAULT 1 CHECK (recurrence_interval > 0),
+  ADD COLUMN recurrence_unit       text      NOT NULL DEFAULT 'month' CHECK (recurrence_unit IN ('day', 'week', 'month', 'year')),
+  ADD COLUMN end_date              date,
+  ADD COLUMN occurrence_limit      integer   CHECK (occurrence_limit > 0),
+  ADD CONSTRAINT bills_end_date_check CHECK (end_date >= first_due_date);
+
+/* Backfill the interval of every existing bill from its frequency */
+UPDATE bills SET
+  recurrence_interval = CASE frequency
+    WHEN 'quarterly' THEN 3
+    WHEN 'biannually' THEN 6
+    ELSE 1
+  END,
+  recurrence_unit = CASE frequency
+    WHEN 'annually' THEN 'year'
+    ELSE 'month'
+  END;
+
+ALTER TABLE bills
+  ALTER COLUMN recurrence_interval DROP DEFAULT,
+  ALTER COLUMN recurrence_unit DROP DEFAULT;
@@ -0,0 +1,7 @@
+ALTER TABLE payments
+  DROP COLUMN currency,
+  ALTER COLUMN total_paid TYPE NUMERIC(8, 2) USING (total_paid / 100.0);
+
+ALTER TABLE bills
+  DROP COLUMN currency,
+  ALTER COLUMN estimated_total_due TYPE NUMERIC(8, 2) USING (estimated_total_due / 100.0);
@@ -0,0 +1,10 @@
+/* Amounts are stored as integer minor units (cents) of an ISO-4217
+ * currency, which removes the 999,999.99 ceiling of NUMERIC(8, 2). */
+ALTER TABLE bills
+  ALTER COLUMN estimated_total_due TYPE bigint USING round(estimated_total_due * 100)::bigint,
+  ADD COLUMN currency text NOT NULL DEFAULT 'USD' CHECK (currency ~ '^[A-Z]{3}$');
+
+/* Payments carry the currency of their bill at the time they were made */
+ALTER TABLE payments
+  ALTER COLUMN total_paid TYPE bigint USING round(total_paid * 100)::bigint,
+  ADD COLUMN currency text NOT NULL DEFAULT 'USD' CHECK (currency ~ '^[A-Z]{3}$');
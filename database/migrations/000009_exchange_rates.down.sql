@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN home_currency;
+DROP TABLE exchange_rates;
@@ -0,0 +1,13 @@
+/* Exchange rates are imported locally (see cmd/import-rates), one rate
+ * per currency pair per day. 1 unit of base = rate units of quote. */
+CREATE TABLE exchange_rates(
+  base              text              NOT NULL CHECK (base ~ '^[A-Z]{3}$'),
+  quote             text              NOT NULL CHECK (quote ~ '^[A-Z]{3}$'),
+  effective_date    date              NOT NULL,
+  rate              NUMERIC(24, 12)   NOT NULL CHECK (rate > 0),
+  created_at        timestamptz       NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY(base, quote, effective_date)
+);
+
+/* The currency a user wants to see their totals in */
+ALTER TABLE users ADD COLUMN home_currency text NOT NULL DEFAULT 'USD' CHECK (home_currency ~ '^[A-Z]{3}$');
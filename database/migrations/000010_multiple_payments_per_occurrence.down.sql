@@ -0,0 +1,21 @@
+DROP INDEX payments_bill_id_due_date_idx;
+
+/* Fold the installments of each occurrence into its earliest payment
+ * so that the unique constraint can be restored. */
+UPDATE payments
+SET total_paid = totals.total_paid
+FROM (
+  SELECT bill_id, due_date, SUM(total_paid) AS total_paid
+  FROM payments
+  GROUP BY bill_id, due_date
+  HAVING COUNT(*) > 1
+) AS totals
+WHERE payments.bill_id = totals.bill_id AND payments.due_date = totals.due_date;
+
+DELETE FROM payments AS later
+USING payments AS earlier
+WHERE later.bill_id = earlier.bill_id
+  AND later.due_date = earlier.due_date
+  AND (later.created_at, later.id) > (earlier.created_at, earlier.id);
+
+ALTER TABLE payments ADD CONSTRAINT payments_bill_id_due_date_key UNIQUE (bill_id, due_date);
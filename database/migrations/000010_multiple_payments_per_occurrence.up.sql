@@ -0,0 +1,7 @@
+/* A bill occurrence can now be paid in several installments, so
+ * there can be any number of payments per (bill_id, due_date). */
+ALTER TABLE payments DROP CONSTRAINT payments_bill_id_due_date_key;
+
+/* The unique constraint doubled as the index used to look up the
+ * payments of an occurrence. */
+CREATE INDEX payments_bill_id_due_date_idx ON payments (bill_id, due_date);
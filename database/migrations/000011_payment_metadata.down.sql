@@ -0,0 +1,9 @@
+DROP INDEX payments_paid_at_idx;
+DROP INDEX payments_bill_id_confirmation_number_key;
+
+ALTER TABLE payments
+  DROP COLUMN notes,
+  DROP COLUMN confirmation_number,
+  DROP COLUMN payment_method_detail,
+  DROP COLUMN payment_method,
+  DROP COLUMN paid_at;
@@ -0,0 +1,24 @@
+/* Record when & how a payment was actually made, so that payments can
+ * be reconciled against bank statements. Existing payments are assumed
+ * to have been made on the day they were recorded. */
+ALTER TABLE payments
+  ADD COLUMN paid_at date,
+  ADD COLUMN payment_method text NOT NULL DEFAULT 'other'
+    CONSTRAINT payments_payment_method_check
+    CHECK (payment_method IN ('bank_transfer', 'card', 'cash', 'check', 'direct_debit', 'other')),
+  ADD COLUMN payment_method_detail text NOT NULL DEFAULT '',
+  ADD COLUMN confirmation_number text NOT NULL DEFAULT '',
+  ADD COLUMN notes text NOT NULL DEFAULT '';
+
+UPDATE payments SET paid_at = created_at::date;
+
+ALTER TABLE payments
+  ALTER COLUMN paid_at SET NOT NULL,
+  ALTER COLUMN paid_at SET DEFAULT CURRENT_DATE;
+
+/* A confirmation number identifies a single payment of a bill. */
+CREATE UNIQUE INDEX payments_bill_id_confirmation_number_key
+ON payments (bill_id, confirmation_number)
+WHERE confirmation_number <> '';
+
+CREATE INDEX payments_paid_at_idx ON payments (paid_at);
@@ -0,0 +1,3 @@
+ALTER TABLE bills
+  DROP COLUMN late_fee,
+  DROP COLUMN grace_period_days;
@@ -0,0 +1,5 @@
+/* A bill isn't late until its grace period has passed, at which point
+ * the late fee (in minor units of the bill's currency) is charged. */
+ALTER TABLE bills
+  ADD COLUMN grace_period_days   integer   NOT NULL DEFAULT 0 CHECK (grace_period_days >= 0),
+  ADD COLUMN late_fee            bigint    NOT NULL DEFAULT 0 CHECK (late_fee >= 0);
@@ -0,0 +1,3 @@
+/* The IANA time zone a user lives in, which decides what "today" is
+ * when working out whether their bills are due or late. */
+ALTER TABLE users ADD COLUMN time_zone text NOT NULL DEFAULT 'UTC';
@@ -0,0 +1,5 @@
+ALTER TABLE users
+  DROP COLUMN reminder_days_before,
+  DROP COLUMN reminders_enabled,
+  DROP COLUMN week_start,
+  DROP COLUMN display_name;
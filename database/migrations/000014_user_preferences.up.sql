@@ -0,0 +1,9 @@
+/* Profile & preferences of a user, each in its own typed column. The
+ * reminder settings are the defaults applied to the user's bills. */
+ALTER TABLE users
+  ADD COLUMN display_name           text      NOT NULL DEFAULT '',
+  ADD COLUMN week_start             text      NOT NULL DEFAULT 'monday'
+    CHECK (week_start IN ('monday', 'tuesday', 'wednesday', 'thursday', 'friday', 'saturday', 'sunday')),
+  ADD COLUMN reminders_enabled      boolean   NOT NULL DEFAULT true,
+  ADD COLUMN reminder_days_before   integer   NOT NULL DEFAULT 3
+    CHECK (reminder_days_before BETWEEN 0 AND 30);
@@ -0,0 +1,13 @@
+/* Single use tokens that are mailed to a user to prove they own an
+ * email address. Only a SHA-256 hash of each token is stored. */
+CREATE TABLE user_tokens(
+  id            uuid            PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       uuid            NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  purpose       text            NOT NULL CONSTRAINT user_tokens_purpose_check CHECK (purpose IN ('change_email')),
+  email         text            NOT NULL,
+  token_hash    text            NOT NULL UNIQUE,
+  expires_at    timestamptz     NOT NULL,
+  created_at    timestamptz     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX user_tokens_user_id_idx ON user_tokens (user_id);
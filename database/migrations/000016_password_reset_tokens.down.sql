@@ -0,0 +1,5 @@
+DELETE FROM user_tokens WHERE purpose = 'reset_password';
+
+ALTER TABLE user_tokens
+  DROP CONSTRAINT user_tokens_purpose_check,
+  ADD CONSTRAINT user_tokens_purpose_check CHECK (purpose IN ('change_email'));
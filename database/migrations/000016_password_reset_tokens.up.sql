@@ -0,0 +1,5 @@
+/* Password reset tokens are mailed to the address of the account, & share
+ * the user_tokens table with the tokens that confirm a new address. */
+ALTER TABLE user_tokens
+  DROP CONSTRAINT user_tokens_purpose_check,
+  ADD CONSTRAINT user_tokens_purpose_check CHECK (purpose IN ('change_email', 'reset_password'));
@@ -0,0 +1,8 @@
+DELETE FROM user_tokens WHERE purpose = 'verify_email';
+
+ALTER TABLE user_tokens
+  DROP CONSTRAINT user_tokens_purpose_check,
+  ADD CONSTRAINT user_tokens_purpose_check CHECK (purpose IN ('change_email', 'reset_password'));
+
+ALTER TABLE users
+  DROP COLUMN verified_at;
@@ -0,0 +1,10 @@
+/* When a user proved they own their email address. Users that signed up
+ * before verification existed are treated as verified. */
+ALTER TABLE users
+  ADD COLUMN verified_at timestamptz;
+
+UPDATE users SET verified_at = created_at;
+
+ALTER TABLE user_tokens
+  DROP CONSTRAINT user_tokens_purpose_check,
+  ADD CONSTRAINT user_tokens_purpose_check CHECK (purpose IN ('change_email', 'reset_password', 'verify_email'));
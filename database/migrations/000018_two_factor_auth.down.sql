@@ -0,0 +1,6 @@
+DROP TABLE recovery_codes;
+
+ALTER TABLE users
+  DROP COLUMN totp_last_used_step,
+  DROP COLUMN totp_enabled_at,
+  DROP COLUMN totp_secret;
@@ -0,0 +1,17 @@
+/* TOTP two-factor authentication. The secret is set when a user starts to
+ * enroll, & only takes effect once totp_enabled_at is set. The last used
+ * step stops a code from being used twice. */
+ALTER TABLE users
+  ADD COLUMN totp_secret            text          NOT NULL DEFAULT '',
+  ADD COLUMN totp_enabled_at        timestamptz,
+  ADD COLUMN totp_last_used_step    bigint        NOT NULL DEFAULT 0;
+
+/* Single use codes to login with when the authenticator is lost. Only a
+ * SHA-256 hash of each code is stored. */
+CREATE TABLE recovery_codes(
+  id            uuid            PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       uuid            NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash     text            NOT NULL,
+  created_at    timestamptz     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,5 @@
+DROP INDEX refresh_tokens_user_id_idx;
+
+ALTER TABLE refresh_tokens
+  DROP COLUMN ip_address,
+  DROP COLUMN user_agent;
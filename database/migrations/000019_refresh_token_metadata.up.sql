@@ -0,0 +1,7 @@
+/* The device each refresh token was issued to, so users can tell their
+ * sessions (refresh token chains) apart. */
+ALTER TABLE refresh_tokens
+  ADD COLUMN user_agent   text    NOT NULL DEFAULT '',
+  ADD COLUMN ip_address   text    NOT NULL DEFAULT '';
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
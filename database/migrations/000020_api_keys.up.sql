@@ -0,0 +1,15 @@
+/* Long lived keys that users create for scripts & integrations. Only a
+ * SHA-256 hash of each key is stored, along with its first few characters
+ * so that users can tell their keys apart. */
+CREATE TABLE api_keys(
+  id            uuid            PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       uuid            NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name          text            NOT NULL,
+  prefix        text            NOT NULL,
+  key_hash      text            NOT NULL UNIQUE,
+  scopes        text[]          NOT NULL,
+  last_used_at  timestamptz,
+  created_at    timestamptz     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);
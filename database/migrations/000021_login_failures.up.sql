@@ -0,0 +1,9 @@
+/* Failed login attempts, by account & by client, which logins are throttled
+ * on. A key's failures are forgotten once it goes long enough without one. */
+CREATE TABLE login_failures(
+  key             text            PRIMARY KEY,
+  failures        integer         NOT NULL,
+  last_failed_at  timestamptz     NOT NULL
+);
+
+CREATE INDEX login_failures_last_failed_at_idx ON login_failures (last_failed_at);
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"time"
+)
+
+// ApiKey is a long lived key a user created to authenticate scripts &
+// integrations with, in place of an access token. Only the hash of the key
+// is stored, & its Prefix is shown so that users can tell keys apart.
+type ApiKey struct {
+	Id         string     `json:"id"`
+	UserId     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (a *ApiKey) consumeRow(row *sql.Row) error {
+	return row.Scan(
+		&a.Id,
+		&a.UserId,
+		&a.Name,
+		&a.Prefix,
+		&a.KeyHash,
+		pq.Array(&a.Scopes),
+		&a.LastUsedAt,
+		&a.CreatedAt,
+	)
+}
+
+type ApiKeyRepository struct {
+	DB *sql.DB
+}
+
+func (r *ApiKeyRepository) FetchByKeyHash(keyHash string) (*ApiKey, error) {
+	row := r.DB.QueryRow(
+		"SELECT * FROM api_keys WHERE key_hash = $1;",
+		keyHash,
+	)
+	apiKey := &ApiKey{}
+	err := apiKey.consumeRow(row)
+	if err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+// FetchAllUserKeys returns the keys of the user, oldest first.
+func (r *ApiKeyRepository) FetchAllUserKeys(userId string) ([]*ApiKey, error) {
+	rows, err := r.DB.Query(
+		"SELECT * FROM api_keys WHERE user_id = $1 ORDER BY created_at ASC;",
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	apiKeys := make([]*ApiKey, 0)
+	for rows.Next() {
+		a := &ApiKey{}
+		err := rows.Scan(
+			&a.Id,
+			&a.UserId,
+			&a.Name,
+			&a.Prefix,
+			&a.KeyHash,
+			pq.Array(&a.Scopes),
+			&a.LastUsedAt,
+			&a.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		apiKeys = append(apiKeys, a)
+	}
+	return apiKeys, nil
+}
+
+func (r *ApiKeyRepository) Insert(apiKey *ApiKey) error {
+	return apiKey.consumeRow(
+		r.DB.QueryRow(
+			`INSERT INTO api_keys(user_id, name, prefix, key_hash, scopes)
+			VALUES($1, $2, $3, $4, $5)
+			RETURNING *;`,
+			apiKey.UserId,
+			apiKey.Name,
+			apiKey.Prefix,
+			apiKey.KeyHash,
+			pq.Array(apiKey.Scopes),
+		),
+	)
+}
+
+// Touch records that the key was used. It's only written once a minute, as
+// a script can use its key for many requests in a row.
+func (r *ApiKeyRepository) Touch(apiKey *ApiKey) error {
+	_, err := r.DB.Exec(
+		`UPDATE api_keys SET last_used_at=CURRENT_TIMESTAMP
+		WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - interval '1 minute');`,
+		apiKey.Id,
+	)
+	return err
+}
+
+// DeleteUserKey revokes a key, only if it belongs to the user.
+func (r *ApiKeyRepository) DeleteUserKey(userId string, id string) error {
+	res, err := r.DB.Exec(
+		"DELETE FROM api_keys WHERE user_id=$1 AND id=$2;",
+		userId,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	numRows, _ := res.RowsAffected()
+	if numRows != 1 {
+		return errors.New("Nothing was deleted.")
+	}
+	return nil
+}
+
+// DeleteAllUserKeys revokes every key of the user, so that a key made by
+// whoever had their old credentials stops working once they're changed.
+func (r *ApiKeyRepository) DeleteAllUserKeys(userId string) error {
+	_, err := r.DB.Exec(
+		"DELETE FROM api_keys WHERE user_id=$1;",
+		userId,
+	)
+	return err
+}
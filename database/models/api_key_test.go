@@ -0,0 +1,103 @@
+package models
+
+import (
+	"github.com/beanpay/api/database"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestApiKeyRepo(t *testing.T) {
+	// Create a Database for testing
+	ephemeralDatabase, err := database.NewTestEphemeralDatabase(
+		database.Config{
+			MigrationsDir: "../migrations",
+		},
+	)
+	assert.Nil(t, err)
+	defer ephemeralDatabase.Terminate()
+	userRepo := UserRepository{
+		DB: ephemeralDatabase.Connection(),
+	}
+	apiKeyRepo := ApiKeyRepository{
+		DB: ephemeralDatabase.Connection(),
+	}
+
+	// Create a user to issue keys to
+	user := &User{
+		Email:    "some-email@example.com",
+		Password: "some-password",
+	}
+	err = userRepo.Insert(user)
+	assert.Nil(t, err)
+
+	// Insert a key
+	apiKey := &ApiKey{
+		UserId:  user.Id,
+		Name:    "Bill importer",
+		Prefix:  "bp_abcdefgh",
+		KeyHash: "some-hash",
+		Scopes:  []string{"read"},
+	}
+	err = apiKeyRepo.Insert(apiKey)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", apiKey.Id)
+	assert.Nil(t, apiKey.LastUsedAt)
+
+	// Fetch it by its hash, & record that it was used
+	fetchedKey, err := apiKeyRepo.FetchByKeyHash("some-hash")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"read"}, fetchedKey.Scopes)
+	err = apiKeyRepo.Touch(fetchedKey)
+	assert.Nil(t, err)
+	fetchedKey, _ = apiKeyRepo.FetchByKeyHash("some-hash")
+	assert.NotNil(t, fetchedKey.LastUsedAt)
+	_, err = apiKeyRepo.FetchByKeyHash("unknown-hash")
+	assert.NotNil(t, err)
+
+	// List the user's keys
+	apiKeys, err := apiKeyRepo.FetchAllUserKeys(user.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(apiKeys))
+	assert.Equal(t, "Bill importer", apiKeys[0].Name)
+
+	// Keys can only be revoked by their user
+	otherUser := &User{
+		Email:    "other-email@example.com",
+		Password: "some-password",
+	}
+	err = userRepo.Insert(otherUser)
+	assert.Nil(t, err)
+	err = apiKeyRepo.DeleteUserKey(otherUser.Id, apiKey.Id)
+	assert.NotNil(t, err)
+	err = apiKeyRepo.DeleteUserKey(user.Id, apiKey.Id)
+	assert.Nil(t, err)
+	_, err = apiKeyRepo.FetchByKeyHash("some-hash")
+	assert.NotNil(t, err)
+
+	// Revoke every key of a user, leaving other users' keys alone
+	for _, keyHash := range []string{"first-hash", "second-hash"} {
+		err = apiKeyRepo.Insert(&ApiKey{
+			UserId:  user.Id,
+			Name:    "Key",
+			Prefix:  "bp_abcdefgh",
+			KeyHash: keyHash,
+			Scopes:  []string{"read"},
+		})
+		assert.Nil(t, err)
+	}
+	err = apiKeyRepo.Insert(&ApiKey{
+		UserId:  otherUser.Id,
+		Name:    "Key",
+		Prefix:  "bp_abcdefgh",
+		KeyHash: "other-hash",
+		Scopes:  []string{"read"},
+	})
+	assert.Nil(t, err)
+	err = apiKeyRepo.DeleteAllUserKeys(user.Id)
+	assert.Nil(t, err)
+	apiKeys, err = apiKeyRepo.FetchAllUserKeys(user.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(apiKeys))
+	_, err = apiKeyRepo.FetchByKeyHash("other-hash")
+	assert.Nil(t, err)
+}
@@ -12,24 +12,47 @@ type Bill struct {
 	Name              string    `json:"name"`
 	PaymentURL        string    `json:"payment_url"`
 	Frequency         string    `json:"frequency"`
-	EstimatedTotalDue float64   `json:"estimated_total_due"`
+	EstimatedTotalDue Money     `json:"estimated_total_due"`
 	FirstDueDate      time.Time `json:"first_due_date"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
+
+	// The bill is due every RecurrenceInterval RecurrenceUnits, starting on
+	// the FirstDueDate. For every Frequency other than "custom" these are
+	// fixed by the Frequency.
+	RecurrenceInterval int        `json:"recurrence_interval"`
+	RecurrenceUnit     string     `json:"recurrence_unit"`
+	EndDate            *time.Time `json:"end_date"`
+	OccurrenceLimit    *int       `json:"occurrence_limit"`
+
+	// An occurrence is only late once GracePeriodDays have passed since its
+	// due date, at which point the LateFee is charged. The LateFee is always
+	// in the currency of the EstimatedTotalDue.
+	GracePeriodDays int   `json:"grace_period_days"`
+	LateFee         Money `json:"late_fee"`
 }
 
 func (b *Bill) consumeRow(row *sql.Row) error {
-	return row.Scan(
+	err := row.Scan(
 		&b.Id,
 		&b.UserId,
 		&b.Name,
 		&b.PaymentURL,
 		&b.Frequency,
-		&b.EstimatedTotalDue,
+		&b.EstimatedTotalDue.Amount,
 		&b.FirstDueDate,
 		&b.CreatedAt,
 		&b.UpdatedAt,
+		&b.RecurrenceInterval,
+		&b.RecurrenceUnit,
+		&b.EndDate,
+		&b.OccurrenceLimit,
+		&b.EstimatedTotalDue.Currency,
+		&b.GracePeriodDays,
+		&b.LateFee.Amount,
 	)
+	b.LateFee.Currency = b.EstimatedTotalDue.Currency
+	return err
 }
 
 type BillRepository struct {
@@ -50,14 +73,22 @@ func (r *BillRepository) fetch(query string, args ...interface{}) ([]*Bill, erro
 			&b.Name,
 			&b.PaymentURL,
 			&b.Frequency,
-			&b.EstimatedTotalDue,
+			&b.EstimatedTotalDue.Amount,
 			&b.FirstDueDate,
 			&b.CreatedAt,
 			&b.UpdatedAt,
+			&b.RecurrenceInterval,
+			&b.RecurrenceUnit,
+			&b.EndDate,
+			&b.OccurrenceLimit,
+			&b.EstimatedTotalDue.Currency,
+			&b.GracePeriodDays,
+			&b.LateFee.Amount,
 		)
 		if err != nil {
 			return nil, err
 		}
+		b.LateFee.Currency = b.EstimatedTotalDue.Currency
 		bills = append(bills, b)
 	}
 	return bills, nil
@@ -83,15 +114,24 @@ func (r *BillRepository) FetchByID(id string) (*Bill, error) {
 func (r *BillRepository) Insert(bill *Bill) error {
 	return bill.consumeRow(
 		r.DB.QueryRow(
-			`INSERT INTO bills(user_id, name, payment_url, frequency, estimated_total_due, first_due_date)
-			VALUES($1, $2, $3, $4, $5, $6)
+			`INSERT INTO bills(user_id, name, payment_url, frequency, estimated_total_due, first_due_date,
+				recurrence_interval, recurrence_unit, end_date, occurrence_limit, currency,
+				grace_period_days, late_fee)
+			VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 			RETURNING *;`,
 			bill.UserId,
 			bill.Name,
 			bill.PaymentURL,
 			bill.Frequency,
-			bill.EstimatedTotalDue,
+			bill.EstimatedTotalDue.Amount,
 			bill.FirstDueDate,
+			bill.RecurrenceInterval,
+			bill.RecurrenceUnit,
+			bill.EndDate,
+			bill.OccurrenceLimit,
+			bill.EstimatedTotalDue.Currency,
+			bill.GracePeriodDays,
+			bill.LateFee.Amount,
 		),
 	)
 }
@@ -105,14 +145,28 @@ func (r *BillRepository) Update(bill *Bill) error {
 				payment_url=$2,
 				frequency=$3,
 				estimated_total_due=$4,
-				first_due_date=$5
-			WHERE id = $6
+				first_due_date=$5,
+				recurrence_interval=$6,
+				recurrence_unit=$7,
+				end_date=$8,
+				occurrence_limit=$9,
+				currency=$10,
+				grace_period_days=$11,
+				late_fee=$12
+			WHERE id = $13
 			RETURNING *;`,
 			bill.Name,
 			bill.PaymentURL,
 			bill.Frequency,
-			bill.EstimatedTotalDue,
+			bill.EstimatedTotalDue.Amount,
 			bill.FirstDueDate,
+			bill.RecurrenceInterval,
+			bill.RecurrenceUnit,
+			bill.EndDate,
+			bill.OccurrenceLimit,
+			bill.EstimatedTotalDue.Currency,
+			bill.GracePeriodDays,
+			bill.LateFee.Amount,
 			bill.Id,
 		),
 	)
@@ -34,24 +34,28 @@ func TestBillRepo(t *testing.T) {
 
 	// Create our first bill
 	firstBill := &Bill{
-		UserId:            newUser.Id,
-		Name:              "First Bill",
-		PaymentURL:        "https://example.com",
-		Frequency:         "monthly",
-		EstimatedTotalDue: 100.25,
-		FirstDueDate:      time.Now(),
+		UserId:             newUser.Id,
+		Name:               "First Bill",
+		PaymentURL:         "https://example.com",
+		Frequency:          "monthly",
+		EstimatedTotalDue:  Money{Amount: 10025, Currency: "USD"},
+		FirstDueDate:       time.Now(),
+		RecurrenceInterval: 1,
+		RecurrenceUnit:     "month",
 	}
 	err = billRepo.Insert(firstBill)
 	assert.Nil(t, err)
 
 	// Create our second bill
 	secondBill := &Bill{
-		UserId:            newUser.Id,
-		Name:              "Second Bill",
-		PaymentURL:        "https://example.com",
-		Frequency:         "monthly",
-		EstimatedTotalDue: 200.25,
-		FirstDueDate:      time.Now(),
+		UserId:             newUser.Id,
+		Name:               "Second Bill",
+		PaymentURL:         "https://example.com",
+		Frequency:          "monthly",
+		EstimatedTotalDue:  Money{Amount: 20025, Currency: "USD"},
+		FirstDueDate:       time.Now(),
+		RecurrenceInterval: 1,
+		RecurrenceUnit:     "month",
 	}
 	err = billRepo.Insert(secondBill)
 	assert.Nil(t, err)
@@ -71,6 +75,47 @@ func TestBillRepo(t *testing.T) {
 	fetchedBill, err := billRepo.FetchByID(firstBill.Id)
 	assert.Nil(t, err)
 	assert.Equal(t, "First Bill (Updated)", fetchedBill.Name)
+	assert.Nil(t, fetchedBill.EndDate)
+	assert.Nil(t, fetchedBill.OccurrenceLimit)
+
+	// Switch the First Bill to a bounded custom recurrence
+	endDate := time.Now().Add(time.Hour * 24 * 365)
+	occurrenceLimit := 12
+	firstBill.Frequency = "custom"
+	firstBill.RecurrenceInterval = 2
+	firstBill.RecurrenceUnit = "week"
+	firstBill.EndDate = &endDate
+	firstBill.OccurrenceLimit = &occurrenceLimit
+	err = billRepo.Update(firstBill)
+	assert.Nil(t, err)
+	fetchedBill, err = billRepo.FetchByID(firstBill.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "custom", fetchedBill.Frequency)
+	assert.Equal(t, 2, fetchedBill.RecurrenceInterval)
+	assert.Equal(t, "week", fetchedBill.RecurrenceUnit)
+	assert.Equal(t, endDate.Format("2006-01-02"), fetchedBill.EndDate.Format("2006-01-02"))
+	assert.Equal(t, 12, *fetchedBill.OccurrenceLimit)
+
+	// Give the First Bill a grace period & a late fee
+	firstBill.GracePeriodDays = 5
+	firstBill.LateFee = Money{Amount: 2500, Currency: firstBill.EstimatedTotalDue.Currency}
+	err = billRepo.Update(firstBill)
+	assert.Nil(t, err)
+	fetchedBill, err = billRepo.FetchByID(firstBill.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, fetchedBill.GracePeriodDays)
+	assert.Equal(t, firstBill.LateFee, fetchedBill.LateFee)
+
+	// Ensure negative grace periods are rejected by the database
+	firstBill.GracePeriodDays = -1
+	err = billRepo.Update(firstBill)
+	assert.NotNil(t, err)
+	firstBill.GracePeriodDays = 5
+
+	// Ensure unsupported frequencies are rejected by the database
+	firstBill.Frequency = "fortnightly"
+	err = billRepo.Update(firstBill)
+	assert.NotNil(t, err)
 
 	// Delete the fetched bill, ensure it can't be fetched
 	err = billRepo.Delete(fetchedBill)
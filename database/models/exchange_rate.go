@@ -0,0 +1,111 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// ExchangeRate states that on the EffectiveDate, 1 unit of the Base
+// currency was worth Rate units of the Quote currency.
+type ExchangeRate struct {
+	Base          string    `json:"base"`
+	Quote         string    `json:"quote"`
+	EffectiveDate time.Time `json:"effective_date"`
+	Rate          *big.Rat  `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (e *ExchangeRate) consumeRow(row *sql.Row) error {
+	var rate string
+	err := row.Scan(
+		&e.Base,
+		&e.Quote,
+		&e.EffectiveDate,
+		&rate,
+		&e.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	return e.parseRate(rate)
+}
+
+func (e *ExchangeRate) parseRate(rate string) error {
+	parsed, ok := new(big.Rat).SetString(rate)
+	if !ok {
+		return fmt.Errorf("Invalid exchange rate: %v", rate)
+	}
+	e.Rate = parsed
+	return nil
+}
+
+type ExchangeRateRepository struct {
+	DB *sql.DB
+}
+
+// FetchRate returns the most recent rate from base to quote that was
+// in effect on the specified date.
+func (r *ExchangeRateRepository) FetchRate(base string, quote string, on time.Time) (*ExchangeRate, error) {
+	row := r.DB.QueryRow(
+		`SELECT *
+		FROM exchange_rates
+		WHERE base = $1 AND quote = $2 AND effective_date <= $3
+		ORDER BY effective_date DESC
+		LIMIT 1;`,
+		base,
+		quote,
+		on,
+	)
+	exchangeRate := &ExchangeRate{}
+	err := exchangeRate.consumeRow(row)
+	if err != nil {
+		return nil, err
+	}
+	return exchangeRate, nil
+}
+
+const upsertExchangeRateQuery = `INSERT INTO exchange_rates(base, quote, effective_date, rate)
+	VALUES($1, $2, $3, $4)
+	ON CONFLICT (base, quote, effective_date) DO UPDATE SET rate = EXCLUDED.rate
+	RETURNING *;`
+
+// Upsert inserts the rate, replacing any rate already stored for the same
+// currency pair & date, so that importing the same file twice is harmless.
+func (r *ExchangeRateRepository) Upsert(exchangeRate *ExchangeRate) error {
+	return exchangeRate.consumeRow(
+		r.DB.QueryRow(
+			upsertExchangeRateQuery,
+			exchangeRate.Base,
+			exchangeRate.Quote,
+			exchangeRate.EffectiveDate,
+			exchangeRate.Rate.FloatString(12),
+		),
+	)
+}
+
+// UpsertAll upserts every rate in a single transaction, so that if any of
+// them can't be stored, none of them are.
+func (r *ExchangeRateRepository) UpsertAll(exchangeRates []*ExchangeRate) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, exchangeRate := range exchangeRates {
+		err = exchangeRate.consumeRow(
+			tx.QueryRow(
+				upsertExchangeRateQuery,
+				exchangeRate.Base,
+				exchangeRate.Quote,
+				exchangeRate.EffectiveDate,
+				exchangeRate.Rate.FloatString(12),
+			),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
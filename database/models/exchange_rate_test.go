@@ -0,0 +1,101 @@
+package models
+
+import (
+	"github.com/beanpay/api/database"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestExchangeRateRepo(t *testing.T) {
+	// Create a Database for testing
+	ephemeralDatabase, err := database.NewTestEphemeralDatabase(
+		database.Config{
+			MigrationsDir: "../migrations",
+		},
+	)
+	assert.Nil(t, err)
+	defer ephemeralDatabase.Terminate()
+	exchangeRateRepo := ExchangeRateRepository{
+		DB: ephemeralDatabase.Connection(),
+	}
+
+	// Insert rates for two different days
+	may11, _ := time.Parse("2006-01-02", "2020-05-11")
+	may12, _ := time.Parse("2006-01-02", "2020-05-12")
+	err = exchangeRateRepo.Upsert(&ExchangeRate{
+		Base:          "EUR",
+		Quote:         "USD",
+		EffectiveDate: may11,
+		Rate:          big.NewRat(108, 100),
+	})
+	assert.Nil(t, err)
+	secondRate := &ExchangeRate{
+		Base:          "EUR",
+		Quote:         "USD",
+		EffectiveDate: may12,
+		Rate:          big.NewRat(10827, 10000),
+	}
+	err = exchangeRateRepo.Upsert(secondRate)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.0827", secondRate.Rate.FloatString(4))
+
+	// Upserting the same day again replaces the rate
+	err = exchangeRateRepo.Upsert(&ExchangeRate{
+		Base:          "EUR",
+		Quote:         "USD",
+		EffectiveDate: may12,
+		Rate:          big.NewRat(109, 100),
+	})
+	assert.Nil(t, err)
+
+	// Fetch the rate in effect on each day
+	rate, err := exchangeRateRepo.FetchRate("EUR", "USD", may11)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.08", rate.Rate.FloatString(2))
+	rate, err = exchangeRateRepo.FetchRate("EUR", "USD", may12.Add(time.Hour*24*30))
+	assert.Nil(t, err)
+	assert.Equal(t, "1.09", rate.Rate.FloatString(2))
+
+	// There are no rates before the first import, or for unknown pairs
+	_, err = exchangeRateRepo.FetchRate("EUR", "USD", may11.Add(-time.Hour*24))
+	assert.NotNil(t, err)
+	_, err = exchangeRateRepo.FetchRate("USD", "EUR", may12)
+	assert.NotNil(t, err)
+
+	// Rates must be positive
+	err = exchangeRateRepo.Upsert(&ExchangeRate{
+		Base:          "EUR",
+		Quote:         "GBP",
+		EffectiveDate: may12,
+		Rate:          big.NewRat(0, 1),
+	})
+	assert.NotNil(t, err)
+
+	// Rates are upserted all at once
+	err = exchangeRateRepo.UpsertAll([]*ExchangeRate{
+		{Base: "EUR", Quote: "GBP", EffectiveDate: may11, Rate: big.NewRat(87, 100)},
+		{Base: "EUR", Quote: "USD", EffectiveDate: may12, Rate: big.NewRat(110, 100)},
+	})
+	assert.Nil(t, err)
+	rate, err = exchangeRateRepo.FetchRate("EUR", "GBP", may11)
+	assert.Nil(t, err)
+	assert.Equal(t, "0.87", rate.Rate.FloatString(2))
+	rate, err = exchangeRateRepo.FetchRate("EUR", "USD", may12)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.10", rate.Rate.FloatString(2))
+
+	// Or not at all, when any of them can't be stored
+	err = exchangeRateRepo.UpsertAll([]*ExchangeRate{
+		{Base: "EUR", Quote: "JPY", EffectiveDate: may12, Rate: big.NewRat(116, 1)},
+		{Base: "EUR", Quote: "USD", EffectiveDate: may12, Rate: big.NewRat(111, 100)},
+		{Base: "EUR", Quote: "CHF", EffectiveDate: may12, Rate: big.NewRat(0, 1)},
+	})
+	assert.NotNil(t, err)
+	_, err = exchangeRateRepo.FetchRate("EUR", "JPY", may12)
+	assert.NotNil(t, err)
+	rate, err = exchangeRateRepo.FetchRate("EUR", "USD", may12)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.10", rate.Rate.FloatString(2))
+}
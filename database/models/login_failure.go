@@ -0,0 +1,121 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LoginFailure counts the consecutive failed login attempts of a Key, such
+// as an account or a client's IP address.
+type LoginFailure struct {
+	Key          string    `json:"key"`
+	Failures     int       `json:"failures"`
+	LastFailedAt time.Time `json:"last_failed_at"`
+}
+
+func (l *LoginFailure) consumeRow(row *sql.Row) error {
+	return row.Scan(
+		&l.Key,
+		&l.Failures,
+		&l.LastFailedAt,
+	)
+}
+
+type LoginFailureRepository struct {
+	DB *sql.DB
+}
+
+func (r *LoginFailureRepository) FetchByKey(key string) (*LoginFailure, error) {
+	row := r.DB.QueryRow(
+		"SELECT * FROM login_failures WHERE key = $1;",
+		key,
+	)
+	loginFailure := &LoginFailure{}
+	err := loginFailure.consumeRow(row)
+	if err != nil {
+		return nil, err
+	}
+	return loginFailure, nil
+}
+
+// ReserveAttempt counts an attempt of the key at attemptedAt as a failure,
+// starting the count over if the last one was before resetBefore, but only
+// when allow accepts the key's current failures. The key's row is locked
+// while allow decides, so concurrent attempts of the same key take turns
+// rather than all being allowed at once. This returns whether the attempt
+// was counted.
+func (r *LoginFailureRepository) ReserveAttempt(key string, attemptedAt time.Time, resetBefore time.Time, allow func(*LoginFailure) bool) (bool, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Lock the key's row, creating it first if the key hasn't failed yet
+	_, err = tx.Exec(
+		`INSERT INTO login_failures(key, failures, last_failed_at)
+		VALUES($1, 0, $2)
+		ON CONFLICT (key) DO NOTHING;`,
+		key,
+		attemptedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	loginFailure := &LoginFailure{}
+	err = loginFailure.consumeRow(
+		tx.QueryRow("SELECT * FROM login_failures WHERE key = $1 FOR UPDATE;", key),
+	)
+	if err != nil {
+		return false, err
+	}
+	if !allow(loginFailure) {
+		return false, nil
+	}
+
+	// Count the attempt
+	_, err = tx.Exec(
+		`UPDATE login_failures SET
+			failures = CASE WHEN last_failed_at < $3 THEN 1 ELSE failures + 1 END,
+			last_failed_at = $2
+		WHERE key = $1;`,
+		key,
+		attemptedAt,
+		resetBefore,
+	)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ReleaseAttempt takes back an attempt of the key that ReserveAttempt
+// counted as a failure.
+func (r *LoginFailureRepository) ReleaseAttempt(key string) error {
+	_, err := r.DB.Exec(
+		"UPDATE login_failures SET failures = failures - 1 WHERE key = $1 AND failures > 0;",
+		key,
+	)
+	return err
+}
+
+func (r *LoginFailureRepository) DeleteByKey(key string) error {
+	_, err := r.DB.Exec(
+		"DELETE FROM login_failures WHERE key=$1;",
+		key,
+	)
+	return err
+}
+
+// DeleteAllStale deletes the failures of every key that hasn't failed
+// since before, returning how many were deleted.
+func (r *LoginFailureRepository) DeleteAllStale(before time.Time) (int64, error) {
+	res, err := r.DB.Exec(
+		"DELETE FROM login_failures WHERE last_failed_at < $1;",
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
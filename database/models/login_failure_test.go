@@ -0,0 +1,105 @@
+package models
+
+import (
+	"github.com/beanpay/api/database"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoginFailureRepo(t *testing.T) {
+	// Create a Database for testing
+	ephemeralDatabase, err := database.NewTestEphemeralDatabase(
+		database.Config{
+			MigrationsDir: "../migrations",
+		},
+	)
+	assert.Nil(t, err)
+	defer ephemeralDatabase.Terminate()
+	loginFailureRepo := LoginFailureRepository{
+		DB: ephemeralDatabase.Connection(),
+	}
+	now := time.Now().Truncate(time.Second)
+
+	// Keys without failures aren't found
+	_, err = loginFailureRepo.FetchByKey("account:some-email@example.com")
+	assert.NotNil(t, err)
+
+	allow := func(*LoginFailure) bool { return true }
+
+	// Count a few failures
+	for i := 1; i <= 3; i++ {
+		reserved, err := loginFailureRepo.ReserveAttempt("account:some-email@example.com", now, now.Add(-time.Hour), allow)
+		assert.Nil(t, err)
+		assert.True(t, reserved)
+	}
+	loginFailure, err := loginFailureRepo.FetchByKey("account:some-email@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, loginFailure.Failures)
+	assert.True(t, now.Equal(loginFailure.LastFailedAt))
+
+	// Attempts that aren't allowed aren't counted, & allow is given the
+	// current failures to decide with
+	reserved, err := loginFailureRepo.ReserveAttempt("account:some-email@example.com", now, now.Add(-time.Hour), func(loginFailure *LoginFailure) bool {
+		return loginFailure.Failures < 3
+	})
+	assert.Nil(t, err)
+	assert.False(t, reserved)
+	loginFailure, err = loginFailureRepo.FetchByKey("account:some-email@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, loginFailure.Failures)
+
+	// Nor are attempts of keys that haven't failed yet kept around
+	reserved, err = loginFailureRepo.ReserveAttempt("account:other-email@example.com", now, now.Add(-time.Hour), func(*LoginFailure) bool { return false })
+	assert.Nil(t, err)
+	assert.False(t, reserved)
+	_, err = loginFailureRepo.FetchByKey("account:other-email@example.com")
+	assert.NotNil(t, err)
+
+	// Released attempts are taken back
+	err = loginFailureRepo.ReleaseAttempt("account:some-email@example.com")
+	assert.Nil(t, err)
+	loginFailure, err = loginFailureRepo.FetchByKey("account:some-email@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, loginFailure.Failures)
+
+	// Concurrent attempts take turns, so each one sees the ones before it
+	var wg sync.WaitGroup
+	var allowed int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reserved, err := loginFailureRepo.ReserveAttempt("client:198.51.100.7", now, now.Add(-time.Hour), func(loginFailure *LoginFailure) bool {
+				return loginFailure.Failures < 3
+			})
+			assert.Nil(t, err)
+			if reserved {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), allowed)
+
+	// The count starts over once the last failure is old enough
+	later := now.Add(2 * time.Hour)
+	_, err = loginFailureRepo.ReserveAttempt("account:some-email@example.com", later, later.Add(-time.Hour), allow)
+	assert.Nil(t, err)
+	loginFailure, err = loginFailureRepo.FetchByKey("account:some-email@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, loginFailure.Failures)
+
+	// Delete stale keys, then the rest
+	deleted, err := loginFailureRepo.DeleteAllStale(later.Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), deleted)
+	_, err = loginFailureRepo.FetchByKey("client:198.51.100.7")
+	assert.NotNil(t, err)
+	err = loginFailureRepo.DeleteByKey("account:some-email@example.com")
+	assert.Nil(t, err)
+	_, err = loginFailureRepo.FetchByKey("account:some-email@example.com")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// DefaultCurrency is the currency used when none is specified.
+const DefaultCurrency = "USD"
+
+// moneyPattern matches a non-negative decimal amount with at most two
+// decimal places. The integer part is capped at 16 digits so that any
+// matching amount fits in an int64 once converted to minor units.
+var moneyPattern = regexp.MustCompile(`^(\d{1,16})(?:\.(\d{1,2}))?$`)
+
+// Money is an amount of a currency. The Amount is stored in minor units
+// (cents), so that totals can be summed without floating point drift.
+// The Currency is an ISO-4217 currency code.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// ParseMoney converts a decimal amount (e.g. "19.99") of a currency into
+// Money. Amounts with more than two decimal places are rejected, rather
+// than silently rounded.
+func ParseMoney(amount string, currency string) (Money, error) {
+	matches := moneyPattern.FindStringSubmatch(amount)
+	if matches == nil {
+		return Money{}, fmt.Errorf("Invalid amount: %v", amount)
+	}
+	units, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return Money{}, err
+	}
+	cents := int64(0)
+	if matches[2] != "" {
+		cents, _ = strconv.ParseInt(matches[2], 10, 64)
+		if len(matches[2]) == 1 {
+			cents *= 10
+		}
+	}
+	return Money{Amount: units*100 + cents, Currency: currency}, nil
+}
+
+// IsValidAmount reports whether ParseMoney would accept the amount.
+func IsValidAmount(amount string) bool {
+	return moneyPattern.MatchString(amount)
+}
+
+// Add sums two amounts of the same currency.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, errors.New("Cannot add amounts of different currencies.")
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Decimal formats the amount as a decimal string, e.g. "19.99".
+func (m Money) Decimal() string {
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%v%d.%02d", sign, amount/100, amount%100)
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	// Valid amounts are converted into minor units
+	for amount, expected := range map[string]int64{
+		"0":                   0,
+		"19":                  1900,
+		"19.9":                1990,
+		"19.99":               1999,
+		"0.05":                5,
+		"1000000.00":          100000000,
+		"9999999999999999.99": 999999999999999999,
+	} {
+		money, err := ParseMoney(amount, "EUR")
+		assert.Nil(t, err)
+		assert.Equal(t, Money{Amount: expected, Currency: "EUR"}, money)
+		assert.True(t, IsValidAmount(amount))
+	}
+
+	// Invalid amounts are rejected rather than rounded
+	for _, amount := range []string{"", "19.999", "-1", "1e3", ".5", "19.", "abc", "10000000000000000"} {
+		_, err := ParseMoney(amount, "EUR")
+		assert.NotNil(t, err)
+		assert.False(t, IsValidAmount(amount))
+	}
+}
+
+func TestMoney(t *testing.T) {
+	// Amounts of the same currency can be added
+	sum, err := Money{Amount: 1999, Currency: "USD"}.Add(Money{Amount: 1, Currency: "USD"})
+	assert.Nil(t, err)
+	assert.Equal(t, Money{Amount: 2000, Currency: "USD"}, sum)
+
+	// Amounts of different currencies cannot
+	_, err = Money{Amount: 1999, Currency: "USD"}.Add(Money{Amount: 1, Currency: "EUR"})
+	assert.NotNil(t, err)
+
+	// Formatting
+	assert.Equal(t, "19.99", Money{Amount: 1999, Currency: "USD"}.Decimal())
+	assert.Equal(t, "0.05", Money{Amount: 5, Currency: "USD"}.Decimal())
+	assert.Equal(t, "-1.50", Money{Amount: -150, Currency: "USD"}.Decimal())
+	assert.Equal(t, "1200.00 EUR", Money{Amount: 120000, Currency: "EUR"}.String())
+}
@@ -6,13 +6,21 @@ import (
 	"time"
 )
 
+// PaymentMethodOther is the PaymentMethod used when none is specified.
+const PaymentMethodOther = "other"
+
 type Payment struct {
-	Id        string    `json:"id"`
-	BillId    string    `json:"bill_id"`
-	DueDate   time.Time `json:"due_date"`
-	TotalPaid float64   `json:"total_paid"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Id                  string    `json:"id"`
+	BillId              string    `json:"bill_id"`
+	DueDate             time.Time `json:"due_date"`
+	TotalPaid           Money     `json:"total_paid"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+	PaidAt              time.Time `json:"paid_at"`
+	PaymentMethod       string    `json:"payment_method"`
+	PaymentMethodDetail string    `json:"payment_method_detail"`
+	ConfirmationNumber  string    `json:"confirmation_number"`
+	Notes               string    `json:"notes"`
 }
 
 func (p *Payment) consumeRow(row *sql.Row) error {
@@ -20,9 +28,15 @@ func (p *Payment) consumeRow(row *sql.Row) error {
 		&p.Id,
 		&p.BillId,
 		&p.DueDate,
-		&p.TotalPaid,
+		&p.TotalPaid.Amount,
 		&p.CreatedAt,
 		&p.UpdatedAt,
+		&p.TotalPaid.Currency,
+		&p.PaidAt,
+		&p.PaymentMethod,
+		&p.PaymentMethodDetail,
+		&p.ConfirmationNumber,
+		&p.Notes,
 	)
 }
 
@@ -42,9 +56,15 @@ func (r *PaymentRepository) fetch(query string, args ...interface{}) ([]*Payment
 			&p.Id,
 			&p.BillId,
 			&p.DueDate,
-			&p.TotalPaid,
+			&p.TotalPaid.Amount,
 			&p.CreatedAt,
 			&p.UpdatedAt,
+			&p.TotalPaid.Currency,
+			&p.PaidAt,
+			&p.PaymentMethod,
+			&p.PaymentMethodDetail,
+			&p.ConfirmationNumber,
+			&p.Notes,
 		)
 		if err != nil {
 			return nil, err
@@ -71,6 +91,23 @@ func (r *PaymentRepository) FetchAllUserPayments(userId string, from time.Time,
 	)
 }
 
+// Returns all payments made by a specific user that were paid between the dates 'from' (inclusive) and 'to' (exclusive).
+func (r *PaymentRepository) FetchAllUserPaymentsByPaidAt(userId string, from time.Time, to time.Time) ([]*Payment, error) {
+	return r.fetch(
+		`SELECT *
+		FROM payments
+		WHERE bill_id IN (
+			SELECT id
+			FROM bills
+			WHERE user_id = $1
+		)
+		AND paid_at >= $2 AND paid_at < $3;`,
+		userId,
+		from,
+		to,
+	)
+}
+
 func (r *PaymentRepository) FetchByID(id string) (*Payment, error) {
 	row := r.DB.QueryRow(
 		"SELECT * FROM payments WHERE id = $1;",
@@ -85,14 +122,64 @@ func (r *PaymentRepository) FetchByID(id string) (*Payment, error) {
 }
 
 func (r *PaymentRepository) Insert(payment *Payment) error {
+	if payment.PaidAt.IsZero() {
+		payment.PaidAt = time.Now()
+	}
+	if payment.PaymentMethod == "" {
+		payment.PaymentMethod = PaymentMethodOther
+	}
 	return payment.consumeRow(
 		r.DB.QueryRow(
-			`INSERT INTO payments(bill_id, due_date, total_paid)
-			VALUES($1, $2, $3)
+			`INSERT INTO payments(
+				bill_id,
+				due_date,
+				total_paid,
+				currency,
+				paid_at,
+				payment_method,
+				payment_method_detail,
+				confirmation_number,
+				notes
+			)
+			VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
 			RETURNING *;`,
 			payment.BillId,
 			payment.DueDate,
-			payment.TotalPaid,
+			payment.TotalPaid.Amount,
+			payment.TotalPaid.Currency,
+			payment.PaidAt,
+			payment.PaymentMethod,
+			payment.PaymentMethodDetail,
+			payment.ConfirmationNumber,
+			payment.Notes,
+		),
+	)
+}
+
+func (r *PaymentRepository) Update(payment *Payment) error {
+	return payment.consumeRow(
+		r.DB.QueryRow(
+			`UPDATE payments
+			SET
+				due_date=$1,
+				total_paid=$2,
+				currency=$3,
+				paid_at=$4,
+				payment_method=$5,
+				payment_method_detail=$6,
+				confirmation_number=$7,
+				notes=$8
+			WHERE id = $9
+			RETURNING *;`,
+			payment.DueDate,
+			payment.TotalPaid.Amount,
+			payment.TotalPaid.Currency,
+			payment.PaidAt,
+			payment.PaymentMethod,
+			payment.PaymentMethodDetail,
+			payment.ConfirmationNumber,
+			payment.Notes,
+			payment.Id,
 		),
 	)
 }
@@ -37,12 +37,14 @@ func TestPaymentRepo(t *testing.T) {
 
 	// Create our first bill
 	firstBill := &Bill{
-		UserId:            newUser.Id,
-		Name:              "First Bill",
-		PaymentURL:        "https://example.com",
-		Frequency:         "monthly",
-		EstimatedTotalDue: 100.25,
-		FirstDueDate:      time.Now(),
+		UserId:             newUser.Id,
+		Name:               "First Bill",
+		PaymentURL:         "https://example.com",
+		Frequency:          "monthly",
+		EstimatedTotalDue:  Money{Amount: 10025, Currency: "USD"},
+		FirstDueDate:       time.Now(),
+		RecurrenceInterval: 1,
+		RecurrenceUnit:     "month",
 	}
 	err = billRepo.Insert(firstBill)
 	assert.Nil(t, err)
@@ -52,13 +54,56 @@ func TestPaymentRepo(t *testing.T) {
 	firstPayment := &Payment{
 		BillId:    firstBill.Id,
 		DueDate:   dueDate,
-		TotalPaid: 100.50,
+		TotalPaid: Money{Amount: 10050, Currency: "USD"},
 	}
 	err = paymentRepo.Insert(firstPayment)
 	assert.Nil(t, err)
 
-	// Ensure we can't pay the same bill twice
-	err = paymentRepo.Insert(firstPayment)
+	// Ensure the same bill can be paid in installments
+	installment := &Payment{
+		BillId:    firstBill.Id,
+		DueDate:   dueDate,
+		TotalPaid: Money{Amount: 500, Currency: "USD"},
+	}
+	err = paymentRepo.Insert(installment)
+	assert.Nil(t, err)
+	assert.NotEqual(t, firstPayment.Id, installment.Id)
+
+	// Ensure payments default to being paid today, by an unspecified method
+	assert.Equal(t, time.Now().UTC().Format("2006-01-02"), installment.PaidAt.Format("2006-01-02"))
+	assert.Equal(t, PaymentMethodOther, installment.PaymentMethod)
+
+	// Ensure a confirmation number can only be used once per bill
+	paidAt, _ := time.Parse("2006-01-02", "2020-05-25")
+	confirmedPayment := &Payment{
+		BillId:              firstBill.Id,
+		DueDate:             dueDate,
+		TotalPaid:           Money{Amount: 500, Currency: "USD"},
+		PaidAt:              paidAt,
+		PaymentMethod:       "card",
+		PaymentMethodDetail: "Visa ending in 4242",
+		ConfirmationNumber:  "ABC-123",
+		Notes:               "Second installment",
+	}
+	err = paymentRepo.Insert(confirmedPayment)
+	assert.Nil(t, err)
+	assert.Equal(t, paidAt, confirmedPayment.PaidAt.UTC())
+	assert.Equal(t, "Visa ending in 4242", confirmedPayment.PaymentMethodDetail)
+	err = paymentRepo.Insert(&Payment{
+		BillId:             firstBill.Id,
+		DueDate:            dueDate,
+		TotalPaid:          Money{Amount: 500, Currency: "USD"},
+		ConfirmationNumber: "ABC-123",
+	})
+	assert.NotNil(t, err)
+
+	// Ensure unsupported payment methods are rejected by the database
+	err = paymentRepo.Insert(&Payment{
+		BillId:        firstBill.Id,
+		DueDate:       dueDate,
+		TotalPaid:     Money{Amount: 500, Currency: "USD"},
+		PaymentMethod: "barter",
+	})
 	assert.NotNil(t, err)
 
 	// Create a second payment
@@ -66,7 +111,7 @@ func TestPaymentRepo(t *testing.T) {
 	secondPayment := &Payment{
 		BillId:    firstBill.Id,
 		DueDate:   dueDate,
-		TotalPaid: 100.50,
+		TotalPaid: Money{Amount: 10050, Currency: "USD"},
 	}
 	err = paymentRepo.Insert(secondPayment)
 	assert.Nil(t, err)
@@ -80,13 +125,34 @@ func TestPaymentRepo(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, firstPayment, fetchedPayment)
 
+	// Update the second payment
+	createdAt := secondPayment.CreatedAt
+	secondPayment.TotalPaid = Money{Amount: 9999, Currency: "USD"}
+	err = paymentRepo.Update(secondPayment)
+	assert.Nil(t, err)
+	fetchedPayment, err = paymentRepo.FetchByID(secondPayment.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(9999), fetchedPayment.TotalPaid.Amount)
+	assert.Equal(t, createdAt, fetchedPayment.CreatedAt)
+
+	// Ensure we cannot update a payment that doesn't exist
+	err = paymentRepo.Update(&Payment{Id: "invalid-payment-id"})
+	assert.NotNil(t, err)
+
 	// Fetch May 2020 Payments
 	from, _ := time.Parse("2006-01-02", "2020-05-01")
 	to, _ := time.Parse("2006-01-02", "2020-06-01")
 	payments, err := paymentRepo.FetchAllUserPayments(newUser.Id, from, to)
 	assert.Nil(t, err)
+	assert.Equal(t, 3, len(payments))
 	assert.Equal(t, payments[0], firstPayment)
 
+	// Fetch the Payments paid on 2020-05-25
+	paidTo, _ := time.Parse("2006-01-02", "2020-05-26")
+	payments, err = paymentRepo.FetchAllUserPaymentsByPaidAt(newUser.Id, paidAt, paidTo)
+	assert.Nil(t, err)
+	assert.Equal(t, []*Payment{confirmedPayment}, payments)
+
 	// Fetch payments via invalid ID
 	_, err = paymentRepo.FetchAllUserPayments("invalid-user-id", from, to)
 	assert.NotNil(t, err)
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+)
+
+// RecoveryCodeRepository stores the single use codes a user can login with
+// in place of a TOTP code. Only the hash of each code is stored, so codes
+// are only ever handled by their hash.
+type RecoveryCodeRepository struct {
+	DB *sql.DB
+}
+
+// CountUserCodes returns how many unused codes the user has left.
+func (r *RecoveryCodeRepository) CountUserCodes(userId string) (int, error) {
+	count := 0
+	err := r.DB.QueryRow(
+		"SELECT count(*) FROM recovery_codes WHERE user_id=$1;",
+		userId,
+	).Scan(&count)
+	return count, err
+}
+
+// ReplaceAllUserCodes replaces every code of the user with the hashes, in
+// a single statement so the user is never left without codes.
+func (r *RecoveryCodeRepository) ReplaceAllUserCodes(userId string, codeHashes []string) error {
+	_, err := r.DB.Exec(
+		`WITH deleted AS (DELETE FROM recovery_codes WHERE user_id=$1)
+		INSERT INTO recovery_codes(user_id, code_hash)
+		SELECT $1, unnest($2::text[]);`,
+		userId,
+		pq.Array(codeHashes),
+	)
+	return err
+}
+
+// Consume deletes the user's code with the hash, so that it can't be used
+// again. sql.ErrNoRows is returned if the user has no such code, including
+// when a concurrent request consumed it first.
+func (r *RecoveryCodeRepository) Consume(userId string, codeHash string) error {
+	id := ""
+	return r.DB.QueryRow(
+		"DELETE FROM recovery_codes WHERE user_id=$1 AND code_hash=$2 RETURNING id;",
+		userId,
+		codeHash,
+	).Scan(&id)
+}
+
+func (r *RecoveryCodeRepository) DeleteAllUserCodes(userId string) error {
+	_, err := r.DB.Exec(
+		"DELETE FROM recovery_codes WHERE user_id=$1;",
+		userId,
+	)
+	return err
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"database/sql"
+	"github.com/beanpay/api/database"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRecoveryCodeRepo(t *testing.T) {
+	// Create a Database for testing
+	ephemeralDatabase, err := database.NewTestEphemeralDatabase(
+		database.Config{
+			MigrationsDir: "../migrations",
+		},
+	)
+	assert.Nil(t, err)
+	defer ephemeralDatabase.Terminate()
+	userRepo := UserRepository{
+		DB: ephemeralDatabase.Connection(),
+	}
+	recoveryCodeRepo := RecoveryCodeRepository{
+		DB: ephemeralDatabase.Connection(),
+	}
+
+	// Create a user to issue codes to
+	user := &User{
+		Email:    "some-email@example.com",
+		Password: "some-password",
+	}
+	err = userRepo.Insert(user)
+	assert.Nil(t, err)
+
+	// Issue some codes
+	err = recoveryCodeRepo.ReplaceAllUserCodes(user.Id, []string{"hash-1", "hash-2", "hash-3"})
+	assert.Nil(t, err)
+	count, err := recoveryCodeRepo.CountUserCodes(user.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+
+	// Codes can only be used once
+	err = recoveryCodeRepo.Consume(user.Id, "hash-2")
+	assert.Nil(t, err)
+	err = recoveryCodeRepo.Consume(user.Id, "hash-2")
+	assert.Equal(t, sql.ErrNoRows, err)
+	err = recoveryCodeRepo.Consume(user.Id, "unknown-hash")
+	assert.Equal(t, sql.ErrNoRows, err)
+	count, _ = recoveryCodeRepo.CountUserCodes(user.Id)
+	assert.Equal(t, 2, count)
+
+	// Even when they're used concurrently
+	var wg sync.WaitGroup
+	var consumed int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if recoveryCodeRepo.Consume(user.Id, "hash-3") == nil {
+				atomic.AddInt32(&consumed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), consumed)
+	count, _ = recoveryCodeRepo.CountUserCodes(user.Id)
+	assert.Equal(t, 1, count)
+
+	// Replacing the codes invalidates the old ones
+	err = recoveryCodeRepo.ReplaceAllUserCodes(user.Id, []string{"hash-4", "hash-5"})
+	assert.Nil(t, err)
+	err = recoveryCodeRepo.Consume(user.Id, "hash-1")
+	assert.NotNil(t, err)
+	count, _ = recoveryCodeRepo.CountUserCodes(user.Id)
+	assert.Equal(t, 2, count)
+
+	// Delete them all
+	err = recoveryCodeRepo.DeleteAllUserCodes(user.Id)
+	assert.Nil(t, err)
+	count, _ = recoveryCodeRepo.CountUserCodes(user.Id)
+	assert.Equal(t, 0, count)
+}
@@ -11,6 +11,19 @@ type RefreshToken struct {
 	ChainId   string    `json:"chain_id"`
 	UserId    string    `json:"user_id"`
 	CreatedAt time.Time `json:"created_at"`
+	UserAgent string    `json:"user_agent"`
+	IpAddress string    `json:"ip_address"`
+}
+
+// Session is a chain of RefreshTokens, which is a device the user is
+// logged in on. The UserAgent & IpAddress are those the most recent token
+// of the chain was issued to, when the session was LastUsedAt.
+type Session struct {
+	ChainId    string    `json:"chain_id"`
+	UserAgent  string    `json:"user_agent"`
+	IpAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
 }
 
 func (r *RefreshToken) consumeRow(row *sql.Row) error {
@@ -19,6 +32,8 @@ func (r *RefreshToken) consumeRow(row *sql.Row) error {
 		&r.ChainId,
 		&r.UserId,
 		&r.CreatedAt,
+		&r.UserAgent,
+		&r.IpAddress,
 	)
 }
 
@@ -67,12 +82,104 @@ func (r *RefreshTokenRepository) DeleteChain(chainId string) error {
 	return nil
 }
 
+// DeleteUserChain deletes a chain, only if it belongs to the user.
+func (r *RefreshTokenRepository) DeleteUserChain(userId string, chainId string) error {
+	res, err := r.DB.Exec(
+		"DELETE FROM refresh_tokens WHERE user_id=$1 AND chain_id=$2;",
+		userId,
+		chainId,
+	)
+	if err != nil {
+		return err
+	}
+	numRows, _ := res.RowsAffected()
+	if numRows < 1 {
+		return errors.New("Nothing was deleted.")
+	}
+	return nil
+}
+
+// DeleteAllUserChains deletes every refresh token chain of the user, which
+// signs them out everywhere once their access tokens expire.
+func (r *RefreshTokenRepository) DeleteAllUserChains(userId string) error {
+	_, err := r.DB.Exec(
+		"DELETE FROM refresh_tokens WHERE user_id=$1;",
+		userId,
+	)
+	return err
+}
+
+// DeleteAllStaleChains deletes every chain whose most recent refresh token
+// was issued before, which are the sessions that have expired without being
+// refreshed or signed out of. It returns how many tokens were deleted.
+func (r *RefreshTokenRepository) DeleteAllStaleChains(before time.Time) (int64, error) {
+	res, err := r.DB.Exec(
+		`DELETE FROM refresh_tokens
+		WHERE chain_id IN (
+			SELECT chain_id
+			FROM refresh_tokens
+			GROUP BY chain_id
+			HAVING max(created_at) < $1
+		);`,
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 func (r *RefreshTokenRepository) Insert(refreshToken *RefreshToken) error {
 	return refreshToken.consumeRow(
 		r.DB.QueryRow(
-			"INSERT INTO refresh_tokens(chain_id, user_id) VALUES($1, $2) RETURNING *;",
+			"INSERT INTO refresh_tokens(chain_id, user_id, user_agent, ip_address) VALUES($1, $2, $3, $4) RETURNING *;",
 			refreshToken.ChainId,
 			refreshToken.UserId,
+			refreshToken.UserAgent,
+			refreshToken.IpAddress,
 		),
 	)
 }
+
+// FetchAllUserSessions returns the Sessions of the user that were last
+// used after activeSince, most recently used first.
+func (r *RefreshTokenRepository) FetchAllUserSessions(userId string, activeSince time.Time) ([]*Session, error) {
+	rows, err := r.DB.Query(
+		`SELECT latest.chain_id, latest.user_agent, latest.ip_address, chains.created_at, latest.created_at
+		FROM (
+			SELECT DISTINCT ON (chain_id) *
+			FROM refresh_tokens
+			WHERE user_id = $1
+			ORDER BY chain_id, created_at DESC
+		) latest
+		JOIN (
+			SELECT chain_id, min(created_at) AS created_at
+			FROM refresh_tokens
+			WHERE user_id = $1
+			GROUP BY chain_id
+		) chains USING (chain_id)
+		WHERE latest.created_at > $2
+		ORDER BY latest.created_at DESC;`,
+		userId,
+		activeSince,
+	)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*Session, 0)
+	for rows.Next() {
+		s := &Session{}
+		err := rows.Scan(
+			&s.ChainId,
+			&s.UserAgent,
+			&s.IpAddress,
+			&s.CreatedAt,
+			&s.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
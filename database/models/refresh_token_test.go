@@ -4,6 +4,7 @@ import (
 	"github.com/beanpay/api/database"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestRefreshTokenRepo(t *testing.T) {
@@ -88,4 +89,62 @@ func TestRefreshTokenRepo(t *testing.T) {
 	firstFetch, err = refreshTokenRepo.FetchByID(firstRefreshToken.Id)
 	assert.NotNil(t, err)
 	assert.Nil(t, firstFetch)
+
+	// Create tokens in two chains & wipe every chain of the user
+	for _, chainId := range []string{testingChainID, "b3e6c1f8-3a3c-4bd0-9a52-2b8d0c6f1e5a"} {
+		err = refreshTokenRepo.Insert(&RefreshToken{ChainId: chainId, UserId: sampleUser.Id})
+		assert.Nil(t, err)
+	}
+	err = refreshTokenRepo.DeleteAllUserChains(sampleUser.Id)
+	assert.Nil(t, err)
+	_, err = refreshTokenRepo.FetchMostRecentInChain(testingChainID)
+	assert.NotNil(t, err)
+	_, err = refreshTokenRepo.FetchMostRecentInChain("b3e6c1f8-3a3c-4bd0-9a52-2b8d0c6f1e5a")
+	assert.NotNil(t, err)
+
+	// Create two sessions, one of which has been refreshed since
+	const otherChainID = "b3e6c1f8-3a3c-4bd0-9a52-2b8d0c6f1e5a"
+	err = refreshTokenRepo.Insert(&RefreshToken{ChainId: testingChainID, UserId: sampleUser.Id, UserAgent: "Firefox", IpAddress: "192.0.2.1"})
+	assert.Nil(t, err)
+	err = refreshTokenRepo.Insert(&RefreshToken{ChainId: otherChainID, UserId: sampleUser.Id, UserAgent: "Safari", IpAddress: "192.0.2.2"})
+	assert.Nil(t, err)
+	err = refreshTokenRepo.Insert(&RefreshToken{ChainId: testingChainID, UserId: sampleUser.Id, UserAgent: "Firefox", IpAddress: "198.51.100.7"})
+	assert.Nil(t, err)
+	sessions, err := refreshTokenRepo.FetchAllUserSessions(sampleUser.Id, time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(sessions))
+	assert.Equal(t, testingChainID, sessions[0].ChainId)
+	assert.Equal(t, "198.51.100.7", sessions[0].IpAddress)
+	assert.True(t, sessions[0].LastUsedAt.After(sessions[0].CreatedAt))
+	assert.Equal(t, otherChainID, sessions[1].ChainId)
+	assert.Equal(t, "Safari", sessions[1].UserAgent)
+
+	// Sessions that haven't been used since are left out
+	sessions, err = refreshTokenRepo.FetchAllUserSessions(sampleUser.Id, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(sessions))
+
+	// Users can only delete their own chains
+	err = refreshTokenRepo.DeleteUserChain("a9b3c2f1-2c5e-4d8b-8f0a-6e1d2c3b4a59", otherChainID)
+	assert.NotNil(t, err)
+	err = refreshTokenRepo.DeleteUserChain(sampleUser.Id, otherChainID)
+	assert.Nil(t, err)
+	sessions, _ = refreshTokenRepo.FetchAllUserSessions(sampleUser.Id, time.Now().Add(-time.Hour))
+	assert.Equal(t, 1, len(sessions))
+
+	// Purge the chains that haven't been refreshed in a while, which only
+	// deletes a chain once its most recent token is stale
+	_, err = ephemeralDatabase.Connection().Exec(
+		"UPDATE refresh_tokens SET created_at = created_at - interval '1 day' WHERE chain_id = $1;",
+		testingChainID,
+	)
+	assert.Nil(t, err)
+	err = refreshTokenRepo.Insert(&RefreshToken{ChainId: otherChainID, UserId: sampleUser.Id})
+	assert.Nil(t, err)
+	deleted, err := refreshTokenRepo.DeleteAllStaleChains(time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), deleted)
+	sessions, _ = refreshTokenRepo.FetchAllUserSessions(sampleUser.Id, time.Now().Add(-48*time.Hour))
+	assert.Equal(t, 1, len(sessions))
+	assert.Equal(t, otherChainID, sessions[0].ChainId)
 }
@@ -7,11 +7,53 @@ import (
 )
 
 type User struct {
-	Id        string    `json:"id"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Id           string    `json:"id"`
+	Email        string    `json:"email"`
+	Password     string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	HomeCurrency string    `json:"home_currency"`
+	TimeZone     string    `json:"time_zone"`
+
+	// Profile & preferences
+	DisplayName        string `json:"display_name"`
+	WeekStart          string `json:"week_start"`
+	RemindersEnabled   bool   `json:"reminders_enabled"`
+	ReminderDaysBefore int    `json:"reminder_days_before"`
+
+	// VerifiedAt is when the User proved they own their Email, or nil if
+	// they haven't yet.
+	VerifiedAt *time.Time `json:"verified_at"`
+
+	// Two-factor authentication. The TotpSecret is set as soon as the User
+	// starts to enroll, but is only required to login once TotpEnabledAt
+	// is set.
+	TotpSecret       string     `json:"-"`
+	TotpEnabledAt    *time.Time `json:"totp_enabled_at"`
+	TotpLastUsedStep int64      `json:"-"`
+}
+
+// DefaultTimeZone is the time zone used when none is specified.
+const DefaultTimeZone = "UTC"
+
+// Location returns the time.Location of the User's TimeZone, falling back
+// to UTC if it can't be loaded.
+func (u *User) Location() *time.Location {
+	location, err := time.LoadLocation(u.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// IsVerified reports whether the User has proved they own their Email.
+func (u *User) IsVerified() bool {
+	return u.VerifiedAt != nil
+}
+
+// IsTotpEnabled reports whether the User must enter a TOTP code to login.
+func (u *User) IsTotpEnabled() bool {
+	return u.TotpEnabledAt != nil
 }
 
 func (u *User) consumeRow(row *sql.Row) error {
@@ -21,6 +63,16 @@ func (u *User) consumeRow(row *sql.Row) error {
 		&u.Password,
 		&u.CreatedAt,
 		&u.UpdatedAt,
+		&u.HomeCurrency,
+		&u.TimeZone,
+		&u.DisplayName,
+		&u.WeekStart,
+		&u.RemindersEnabled,
+		&u.ReminderDaysBefore,
+		&u.VerifiedAt,
+		&u.TotpSecret,
+		&u.TotpEnabledAt,
+		&u.TotpLastUsedStep,
 	)
 }
 
@@ -55,26 +107,119 @@ func (p *UserRepository) FetchByID(id string) (*User, error) {
 }
 
 func (p *UserRepository) Insert(user *User) error {
+	if user.HomeCurrency == "" {
+		user.HomeCurrency = DefaultCurrency
+	}
+	if user.TimeZone == "" {
+		user.TimeZone = DefaultTimeZone
+	}
 	return user.consumeRow(
 		p.DB.QueryRow(
-			"INSERT INTO users(email, password) VALUES($1, $2) RETURNING *;",
+			"INSERT INTO users(email, password, home_currency, time_zone) VALUES($1, $2, $3, $4) RETURNING *;",
 			user.Email,
 			user.Password,
+			user.HomeCurrency,
+			user.TimeZone,
 		),
 	)
 }
 
+// Update stores the User's profile & preferences. Their credentials, email
+// address & two-factor authentication are each stored by their own method,
+// so that a stale User can't overwrite them.
 func (p *UserRepository) Update(user *User) error {
 	return user.consumeRow(
 		p.DB.QueryRow(
-			"UPDATE users SET email=$1, password=$2 WHERE id=$3 RETURNING *;",
-			user.Email,
+			`UPDATE users
+			SET
+				home_currency=$1,
+				time_zone=$2,
+				display_name=$3,
+				week_start=$4,
+				reminders_enabled=$5,
+				reminder_days_before=$6
+			WHERE id=$7
+			RETURNING *;`,
+			user.HomeCurrency,
+			user.TimeZone,
+			user.DisplayName,
+			user.WeekStart,
+			user.RemindersEnabled,
+			user.ReminderDaysBefore,
+			user.Id,
+		),
+	)
+}
+
+// UpdatePassword stores the User's (hashed) Password.
+func (p *UserRepository) UpdatePassword(user *User) error {
+	return user.consumeRow(
+		p.DB.QueryRow(
+			"UPDATE users SET password=$1 WHERE id=$2 RETURNING *;",
 			user.Password,
 			user.Id,
 		),
 	)
 }
 
+// UpdateEmail stores the User's Email, along with its VerifiedAt, as
+// whether an address is verified goes with the address.
+func (p *UserRepository) UpdateEmail(user *User) error {
+	return user.consumeRow(
+		p.DB.QueryRow(
+			"UPDATE users SET email=$1, verified_at=$2 WHERE id=$3 RETURNING *;",
+			user.Email,
+			user.VerifiedAt,
+			user.Id,
+		),
+	)
+}
+
+// MarkVerified records that the User proved they own their Email at
+// verifiedAt.
+func (p *UserRepository) MarkVerified(user *User, verifiedAt time.Time) error {
+	return user.consumeRow(
+		p.DB.QueryRow(
+			"UPDATE users SET verified_at=$1 WHERE id=$2 RETURNING *;",
+			verifiedAt,
+			user.Id,
+		),
+	)
+}
+
+// UpdateTotp stores the User's two-factor authentication settings.
+func (p *UserRepository) UpdateTotp(user *User) error {
+	return user.consumeRow(
+		p.DB.QueryRow(
+			`UPDATE users
+			SET
+				totp_secret=$1,
+				totp_enabled_at=$2,
+				totp_last_used_step=$3
+			WHERE id=$4
+			RETURNING *;`,
+			user.TotpSecret,
+			user.TotpEnabledAt,
+			user.TotpLastUsedStep,
+			user.Id,
+		),
+	)
+}
+
+// AdvanceTotpStep records that the User's TOTP code for the step was used,
+// so that it can't be used again. The step only moves forward, so
+// sql.ErrNoRows is returned when it was already used, including when a
+// concurrent request used it first.
+func (p *UserRepository) AdvanceTotpStep(user *User, step int64) error {
+	return user.consumeRow(
+		p.DB.QueryRow(
+			"UPDATE users SET totp_last_used_step=$1 WHERE id=$2 AND totp_last_used_step < $1 RETURNING *;",
+			step,
+			user.Id,
+		),
+	)
+}
+
 func (p *UserRepository) Delete(user *User) error {
 	res, err := p.DB.Exec(
 		"DELETE FROM users WHERE id=$1;",
@@ -1,9 +1,13 @@
 package models
 
 import (
+	"database/sql"
 	"github.com/beanpay/api/database"
 	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestUserRepo(t *testing.T) {
@@ -27,6 +31,13 @@ func TestUserRepo(t *testing.T) {
 	err = userRepo.Insert(newUser)
 	assert.Nil(t, err)
 	assert.NotEqual(t, "", newUser.Id)
+	assert.Equal(t, DefaultCurrency, newUser.HomeCurrency)
+	assert.Equal(t, DefaultTimeZone, newUser.TimeZone)
+	assert.Equal(t, time.UTC, newUser.Location())
+	assert.Equal(t, "monday", newUser.WeekStart)
+	assert.True(t, newUser.RemindersEnabled)
+	assert.Equal(t, 3, newUser.ReminderDaysBefore)
+	assert.False(t, newUser.IsVerified())
 
 	// Try to create the same user to ensure it's not created
 	err = userRepo.Insert(newUser)
@@ -52,10 +63,106 @@ func TestUserRepo(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Nil(t, failedFetchEmailUser)
 
-	// Update the users email
+	// Update the users home currency & time zone
+	newUser.HomeCurrency = "EUR"
+	newUser.TimeZone = "America/Los_Angeles"
+	err = userRepo.Update(newUser)
+	assert.Nil(t, err)
+	assert.Equal(t, "EUR", newUser.HomeCurrency)
+	assert.Equal(t, "America/Los_Angeles", newUser.Location().String())
+
+	// Update the users email, which is verified along with it
+	verifiedAt := time.Now()
 	newUser.Email = "new-email@example.com"
+	newUser.VerifiedAt = &verifiedAt
+	err = userRepo.UpdateEmail(newUser)
+	assert.Nil(t, err)
+	assert.Equal(t, "new-email@example.com", newUser.Email)
+	assert.True(t, newUser.IsVerified())
+
+	// Update the users preferences
+	newUser.DisplayName = "Sam"
+	newUser.WeekStart = "sunday"
+	newUser.RemindersEnabled = false
+	newUser.ReminderDaysBefore = 7
 	err = userRepo.Update(newUser)
 	assert.Nil(t, err)
+	fetchedByIdUser, err = userRepo.FetchByID(newUser.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, newUser, fetchedByIdUser)
+
+	// Mark the users email as verified
+	err = userRepo.MarkVerified(newUser, verifiedAt)
+	assert.Nil(t, err)
+	fetchedByIdUser, err = userRepo.FetchByID(newUser.Id)
+	assert.Nil(t, err)
+	assert.True(t, fetchedByIdUser.IsVerified())
+
+	// Change the users password
+	newUser.Password = "new-hashed-password"
+	err = userRepo.UpdatePassword(newUser)
+	assert.Nil(t, err)
+	fetchedByIdUser, err = userRepo.FetchByID(newUser.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "new-hashed-password", fetchedByIdUser.Password)
+
+	// Enable two-factor authentication
+	assert.False(t, newUser.IsTotpEnabled())
+	newUser.TotpSecret = "JBSWY3DPEHPK3PXP"
+	newUser.TotpEnabledAt = &verifiedAt
+	newUser.TotpLastUsedStep = 52975680
+	err = userRepo.UpdateTotp(newUser)
+	assert.Nil(t, err)
+	fetchedByIdUser, err = userRepo.FetchByID(newUser.Id)
+	assert.Nil(t, err)
+	assert.True(t, fetchedByIdUser.IsTotpEnabled())
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", fetchedByIdUser.TotpSecret)
+	assert.Equal(t, int64(52975680), fetchedByIdUser.TotpLastUsedStep)
+
+	// The last used step only moves forward, so each code is used once,
+	// even when it's used concurrently
+	err = userRepo.AdvanceTotpStep(newUser, 52975680)
+	assert.Equal(t, sql.ErrNoRows, err)
+	var wg sync.WaitGroup
+	var advanced int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if userRepo.AdvanceTotpStep(&User{Id: newUser.Id}, 52975681) == nil {
+				atomic.AddInt32(&advanced, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), advanced)
+	fetchedByIdUser, err = userRepo.FetchByID(newUser.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(52975681), fetchedByIdUser.TotpLastUsedStep)
+
+	// Ensure saving the profile of a stale copy of the user leaves
+	// everything else alone
+	staleUser := *fetchedByIdUser
+	staleUser.Password = "stale-hashed-password"
+	staleUser.Email = "stale-email@example.com"
+	staleUser.TotpSecret = ""
+	staleUser.VerifiedAt = nil
+	staleUser.DisplayName = "Alex"
+	err = userRepo.Update(&staleUser)
+	assert.Nil(t, err)
+	fetchedByIdUser, err = userRepo.FetchByID(newUser.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "Alex", fetchedByIdUser.DisplayName)
+	assert.Equal(t, "new-hashed-password", fetchedByIdUser.Password)
+	assert.Equal(t, "new-email@example.com", fetchedByIdUser.Email)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", fetchedByIdUser.TotpSecret)
+	assert.True(t, fetchedByIdUser.IsVerified())
+
+	// Ensure invalid preferences are rejected by the database
+	newUser.WeekStart = "someday"
+	err = userRepo.Update(newUser)
+	assert.NotNil(t, err)
+	newUser.WeekStart = "sunday"
 
 	// Fetch the user by their new address
 	fetchedUpdatedUser, err := userRepo.FetchByEmail("new-email@example.com")
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// The purposes a UserToken can be issued for.
+const (
+	UserTokenChangeEmail   = "change_email"
+	UserTokenResetPassword = "reset_password"
+	UserTokenVerifyEmail   = "verify_email"
+)
+
+// UserToken is a single use secret that was mailed to Email, for a user
+// to prove they own the address. Only the hash of the secret is stored.
+type UserToken struct {
+	Id        string    `json:"id"`
+	UserId    string    `json:"user_id"`
+	Purpose   string    `json:"purpose"`
+	Email     string    `json:"email"`
+	TokenHash string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (u *UserToken) consumeRow(row *sql.Row) error {
+	return row.Scan(
+		&u.Id,
+		&u.UserId,
+		&u.Purpose,
+		&u.Email,
+		&u.TokenHash,
+		&u.ExpiresAt,
+		&u.CreatedAt,
+	)
+}
+
+type UserTokenRepository struct {
+	DB *sql.DB
+}
+
+// Consume deletes the unexpired token issued for the purpose with the hash,
+// returning it, so that it can't be used again. sql.ErrNoRows is returned if
+// there's no such token, including when a concurrent request consumed it
+// first.
+func (r *UserTokenRepository) Consume(purpose string, tokenHash string) (*UserToken, error) {
+	row := r.DB.QueryRow(
+		`DELETE FROM user_tokens
+		WHERE purpose = $1 AND token_hash = $2 AND expires_at > CURRENT_TIMESTAMP
+		RETURNING *;`,
+		purpose,
+		tokenHash,
+	)
+	userToken := &UserToken{}
+	err := userToken.consumeRow(row)
+	if err != nil {
+		return nil, err
+	}
+	return userToken, nil
+}
+
+func (r *UserTokenRepository) Insert(userToken *UserToken) error {
+	return userToken.consumeRow(
+		r.DB.QueryRow(
+			`INSERT INTO user_tokens(user_id, purpose, email, token_hash, expires_at)
+			VALUES($1, $2, $3, $4, $5)
+			RETURNING *;`,
+			userToken.UserId,
+			userToken.Purpose,
+			userToken.Email,
+			userToken.TokenHash,
+			userToken.ExpiresAt,
+		),
+	)
+}
+
+func (r *UserTokenRepository) Delete(userToken *UserToken) error {
+	res, err := r.DB.Exec(
+		"DELETE FROM user_tokens WHERE id=$1;",
+		userToken.Id,
+	)
+	if err != nil {
+		return err
+	}
+	numRows, _ := res.RowsAffected()
+	if numRows != 1 {
+		return errors.New("Nothing was deleted.")
+	}
+	return nil
+}
+
+// DeleteAllUserTokens deletes every token issued to the user for the
+// purpose, so that only the most recently mailed one can be used.
+func (r *UserTokenRepository) DeleteAllUserTokens(userId string, purpose string) error {
+	_, err := r.DB.Exec(
+		"DELETE FROM user_tokens WHERE user_id=$1 AND purpose=$2;",
+		userId,
+		purpose,
+	)
+	return err
+}
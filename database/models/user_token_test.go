@@ -0,0 +1,119 @@
+package models
+
+import (
+	"database/sql"
+	"github.com/beanpay/api/database"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUserTokenRepo(t *testing.T) {
+	// Create a Database for testing
+	ephemeralDatabase, err := database.NewTestEphemeralDatabase(
+		database.Config{
+			MigrationsDir: "../migrations",
+		},
+	)
+	assert.Nil(t, err)
+	defer ephemeralDatabase.Terminate()
+	userRepo := UserRepository{
+		DB: ephemeralDatabase.Connection(),
+	}
+	userTokenRepo := UserTokenRepository{
+		DB: ephemeralDatabase.Connection(),
+	}
+
+	// Create a user to issue tokens to
+	user := &User{
+		Email:    "some-email@example.com",
+		Password: "some-password",
+	}
+	err = userRepo.Insert(user)
+	assert.Nil(t, err)
+
+	// Issue a token
+	token := &UserToken{
+		UserId:    user.Id,
+		Purpose:   UserTokenChangeEmail,
+		Email:     "new-email@example.com",
+		TokenHash: "some-hash",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	err = userTokenRepo.Insert(token)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", token.Id)
+
+	// Ensure hashes are unique
+	err = userTokenRepo.Insert(token)
+	assert.NotNil(t, err)
+
+	// Ensure unsupported purposes are rejected by the database
+	err = userTokenRepo.Insert(&UserToken{
+		UserId:    user.Id,
+		Purpose:   "world_domination",
+		Email:     user.Email,
+		TokenHash: "another-hash",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	assert.NotNil(t, err)
+
+	// Ensure expired tokens can't be consumed
+	expiredToken := &UserToken{
+		UserId:    user.Id,
+		Purpose:   UserTokenChangeEmail,
+		Email:     "new-email@example.com",
+		TokenHash: "expired-hash",
+		ExpiresAt: time.Now().Add(-time.Hour),
+	}
+	err = userTokenRepo.Insert(expiredToken)
+	assert.Nil(t, err)
+	_, err = userTokenRepo.Consume(UserTokenChangeEmail, "expired-hash")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// Consume a token, but only for its purpose, & only once, even when
+	// it's consumed concurrently
+	consumableToken := &UserToken{
+		UserId:    user.Id,
+		Purpose:   UserTokenResetPassword,
+		Email:     user.Email,
+		TokenHash: "consumable-hash",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	err = userTokenRepo.Insert(consumableToken)
+	assert.Nil(t, err)
+	_, err = userTokenRepo.Consume(UserTokenVerifyEmail, "consumable-hash")
+	assert.Equal(t, sql.ErrNoRows, err)
+	var wg sync.WaitGroup
+	var consumed int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consumedToken, err := userTokenRepo.Consume(UserTokenResetPassword, "consumable-hash")
+			if err == nil {
+				assert.Equal(t, consumableToken.Id, consumedToken.Id)
+				assert.Equal(t, user.Email, consumedToken.Email)
+				atomic.AddInt32(&consumed, 1)
+			} else {
+				assert.Equal(t, sql.ErrNoRows, err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), consumed)
+
+	// Delete a token, ensure it can't be deleted twice
+	err = userTokenRepo.Delete(token)
+	assert.Nil(t, err)
+	err = userTokenRepo.Delete(token)
+	assert.NotNil(t, err)
+
+	// Delete all of the users tokens
+	err = userTokenRepo.DeleteAllUserTokens(user.Id, UserTokenChangeEmail)
+	assert.Nil(t, err)
+	err = userTokenRepo.Delete(expiredToken)
+	assert.NotNil(t, err)
+}
@@ -1,5 +1,7 @@
 module github.com/beanpay/api
 
+go 1.20
+
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/generalledger/response v0.0.0-20200512021233-0c47e5c791f4
@@ -15,3 +17,13 @@ require (
 	github.com/stretchr/testify v1.4.0
 	golang.org/x/crypto v0.0.0-20190426145343-a29dc8fdc734
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/google/uuid v1.1.1 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.0.0 // indirect
+	github.com/leodido/go-urn v1.2.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
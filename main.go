@@ -1,13 +1,20 @@
 package main
 
 import (
+	"database/sql"
 	"github.com/beanpay/api/database"
+	"github.com/beanpay/api/database/models"
 	"github.com/beanpay/api/server"
 	"github.com/beanpay/api/server/jwt"
+	"github.com/beanpay/api/server/mail"
+	"github.com/beanpay/api/server/middleware"
+	"github.com/beanpay/api/server/throttle"
 	"github.com/beanpay/api/server/validator"
 	"github.com/joho/godotenv"
 	"github.com/julienschmidt/httprouter"
+	"net"
 	"os"
+	"time"
 )
 
 func main() {
@@ -23,15 +30,176 @@ func main() {
 		panic(err)
 	}
 
+	accountLoginThrottle, clientLoginThrottle := newLoginThrottles(db)
 	server := &server.Server{
-		Version:   "0.1.1",
-		Port:      os.Getenv("PORT"),
-		Router:    httprouter.New(),
-		Validator: validator.New(),
-		JwtSignatory: &jwt.JwtSignatory{
-			SigningKey: []byte(os.Getenv("JWT_SIGNING_KEY")),
-		},
-		DB: db,
+		Version:              "0.1.1",
+		Port:                 os.Getenv("PORT"),
+		Router:               httprouter.New(),
+		Validator:            validator.New(),
+		JwtSignatory:         newJwtSignatory(),
+		DB:                   db,
+		Mailer:               newMailer(),
+		AppURL:               os.Getenv("APP_URL"),
+		EmailVerification:    emailVerification(),
+		TrustedProxies:       trustedProxies(),
+		AccountLoginThrottle: accountLoginThrottle,
+		ClientLoginThrottle:  clientLoginThrottle,
+		MaintenanceInterval:  maintenanceInterval(),
+		MaxExchangeRateAge:   maxExchangeRateAge(),
 	}
 	server.Start()
 }
+
+// newLoginThrottles keeps count of failed logins in Postgres, so that every
+// server shares them, unless LOGIN_THROTTLE_STORE is memory, or off to turn
+// throttling off altogether. An account backs off after 5 failures in a
+// row, & a client after 20, up to a 15 minute lockout.
+func newLoginThrottles(db *sql.DB) (*throttle.Throttle, *throttle.Throttle) {
+	var store throttle.Store
+	switch os.Getenv("LOGIN_THROTTLE_STORE") {
+	case "off":
+		return nil, nil
+	case "memory":
+		store = throttle.NewMemoryStore()
+	default:
+		store = &models.LoginFailureRepository{DB: db}
+	}
+	accountLoginThrottle := &throttle.Throttle{
+		Store:     store,
+		Allowed:   5,
+		BaseDelay: time.Second,
+		MaxDelay:  15 * time.Minute,
+		Window:    time.Hour,
+	}
+	clientLoginThrottle := &throttle.Throttle{
+		Store:     store,
+		Allowed:   20,
+		BaseDelay: time.Second,
+		MaxDelay:  15 * time.Minute,
+		Window:    time.Hour,
+	}
+	return accountLoginThrottle, clientLoginThrottle
+}
+
+// newJwtSignatory signs tokens with the keyring in JWT_KEYS, or the file at
+// JWT_KEYS_FILE, when either is set. JWT_SIGNING_KEY is the legacy key,
+// which still verifies tokens without a kid for as long as it's set.
+// JWT_ISSUER, JWT_AUDIENCE & JWT_LEEWAY tighten which tokens are accepted.
+func newJwtSignatory() *jwt.JwtSignatory {
+	signatory := &jwt.JwtSignatory{
+		SigningKey: []byte(os.Getenv("JWT_SIGNING_KEY")),
+		Issuer:     os.Getenv("JWT_ISSUER"),
+		Audience:   os.Getenv("JWT_AUDIENCE"),
+	}
+	if os.Getenv("JWT_LEEWAY") != "" {
+		leeway, err := time.ParseDuration(os.Getenv("JWT_LEEWAY"))
+		if err != nil {
+			panic(err)
+		}
+		signatory.Leeway = leeway
+	}
+	keys := os.Getenv("JWT_KEYS")
+	if os.Getenv("JWT_KEYS_FILE") != "" {
+		b, err := os.ReadFile(os.Getenv("JWT_KEYS_FILE"))
+		if err != nil {
+			panic(err)
+		}
+		keys = string(b)
+	}
+	if keys != "" {
+		keyring, err := jwt.ParseKeyring(keys, os.Getenv("JWT_ACTIVE_KEY_ID"))
+		if err != nil {
+			panic(err)
+		}
+		signatory.Keyring = keyring
+	}
+	return signatory
+}
+
+// newMailer delivers mail through SMTP_ADDR, unless MAIL_DRIVER is log, in
+// which case mail is appended to MAIL_FILE, or written to stdout, which is
+// handy in development. Mail is never logged without asking for it, so a
+// server that's missing its SMTP settings fails to start.
+func newMailer() mail.Mailer {
+	switch os.Getenv("MAIL_DRIVER") {
+	case "", "smtp":
+		if os.Getenv("SMTP_ADDR") == "" {
+			panic("SMTP_ADDR is required, unless MAIL_DRIVER is log")
+		}
+		mailer, err := mail.NewSMTPMailer(
+			os.Getenv("SMTP_ADDR"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+			os.Getenv("MAIL_FROM"),
+		)
+		if err != nil {
+			panic(err)
+		}
+		return mailer
+	case "log":
+		if os.Getenv("MAIL_FILE") != "" {
+			file, err := os.OpenFile(os.Getenv("MAIL_FILE"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				panic(err)
+			}
+			return &mail.LogMailer{Writer: file}
+		}
+		return &mail.LogMailer{Writer: os.Stdout}
+	default:
+		panic("MAIL_DRIVER must be smtp or log")
+	}
+}
+
+// emailVerification is the policy for users that haven't verified their
+// address, from EMAIL_VERIFICATION, which is optional unless it's set. Any
+// other value stops the server from starting, rather than falling back to
+// the most lenient policy.
+func emailVerification() string {
+	switch os.Getenv("EMAIL_VERIFICATION") {
+	case "":
+		return server.EmailVerificationOptional
+	case server.EmailVerificationOptional, server.EmailVerificationRestrict, server.EmailVerificationRequired:
+		return os.Getenv("EMAIL_VERIFICATION")
+	default:
+		panic("EMAIL_VERIFICATION must be optional, restrict or required")
+	}
+}
+
+// trustedProxies are the reverse proxies in TRUSTED_PROXIES, whose
+// X-Forwarded-For is trusted for the client's IP address. It has to be set
+// behind a proxy, otherwise every client shares the proxy's address, & so
+// its login throttle & rate limits.
+func trustedProxies() []*net.IPNet {
+	trustedProxies, err := middleware.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		panic(err)
+	}
+	return trustedProxies
+}
+
+// maintenanceInterval is how often housekeeping is run, which is hourly
+// unless MAINTENANCE_INTERVAL is set, e.g. to 15m, or 0 to turn it off.
+func maintenanceInterval() time.Duration {
+	if os.Getenv("MAINTENANCE_INTERVAL") == "" {
+		return time.Hour
+	}
+	interval, err := time.ParseDuration(os.Getenv("MAINTENANCE_INTERVAL"))
+	if err != nil {
+		panic(err)
+	}
+	return interval
+}
+
+// maxExchangeRateAge is how old an exchange rate may be when it's used,
+// which is a week unless EXCHANGE_RATE_MAX_AGE is set, e.g. to 72h, or 0
+// to use rates of any age.
+func maxExchangeRateAge() time.Duration {
+	if os.Getenv("EXCHANGE_RATE_MAX_AGE") == "" {
+		return 7 * 24 * time.Hour
+	}
+	maxAge, err := time.ParseDuration(os.Getenv("EXCHANGE_RATE_MAX_AGE"))
+	if err != nil {
+		panic(err)
+	}
+	return maxAge
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"errors"
+	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/exchange"
+	"github.com/beanpay/api/server/jwt"
+	"github.com/generalledger/response"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// The statuses of an agendaItem.
+const (
+	statusPaid          = "paid"
+	statusPartiallyPaid = "partially_paid"
+	statusUnpaid        = "unpaid"
+	statusDueSoon       = "due_soon"
+	statusInGrace       = "in_grace"
+	statusOverdue       = "overdue"
+)
+
+// dueSoonDays is how many days ahead of its due date (including the due
+// date itself) an unpaid occurrence is considered due soon.
+const dueSoonDays = 3
+
+// agendaItem is a billOccurrence joined with the Payments made against it.
+// An occurrence may be paid in several installments, so the TotalPaid is
+// the sum of its Payments, and the RemainingBalance is what's left of the
+// EstimatedTotalDue (never less than zero). The LateFee is only set once
+// the occurrence is overdue.
+type agendaItem struct {
+	billOccurrence
+	BillName         string            `json:"bill_name"`
+	TotalPaid        models.Money      `json:"total_paid"`
+	RemainingBalance models.Money      `json:"remaining_balance"`
+	LateFee          models.Money      `json:"late_fee"`
+	Payments         []*models.Payment `json:"payments"`
+}
+
+// agendaTotals sums the agenda in the user's home currency. Occurrences
+// whose currency has no exchange rate on their due date, or only one older
+// than the server's MaxExchangeRateAge, are left out of the totals, and
+// their currencies are listed in MissingRates.
+type agendaTotals struct {
+	Currency     string       `json:"currency"`
+	TotalDue     models.Money `json:"total_due"`
+	TotalPaid    models.Money `json:"total_paid"`
+	MissingRates []string     `json:"missing_rates"`
+}
+
+type agendaResponseBody struct {
+	From        time.Time    `json:"from"`
+	To          time.Time    `json:"to"`
+	Totals      agendaTotals `json:"totals"`
+	Occurrences []agendaItem `json:"occurrences"`
+}
+
+// occurrenceKey uniquely identifies the occurrence of a Bill on a due date.
+func occurrenceKey(billId string, dueDate time.Time) string {
+	return billId + "/" + dueDate.Format("2006-01-02")
+}
+
+// remainingBalance is the part of the EstimatedTotalDue that the
+// TotalPaid doesn't cover yet.
+func remainingBalance(item agendaItem) models.Money {
+	remaining := item.EstimatedTotalDue.Amount - item.TotalPaid.Amount
+	if remaining < 0 {
+		remaining = 0
+	}
+	return models.Money{Amount: remaining, Currency: item.EstimatedTotalDue.Currency}
+}
+
+// occurrenceStatus determines the status of an occurrence, relative to today.
+// An occurrence is only paid once its installments cover the amount due.
+// An unpaid occurrence is in grace from the day after its due date until its
+// grace period has passed, after which it's overdue.
+func occurrenceStatus(item agendaItem, gracePeriodDays int, today time.Time) string {
+	switch {
+	case item.RemainingBalance.Amount == 0 && len(item.Payments) > 0:
+		return statusPaid
+	case item.DueDate.AddDate(0, 0, gracePeriodDays).Before(today):
+		return statusOverdue
+	case item.DueDate.Before(today):
+		return statusInGrace
+	case item.DueDate.Before(today.AddDate(0, 0, dueSoonDays)):
+		return statusDueSoon
+	case item.TotalPaid.Amount > 0:
+		return statusPartiallyPaid
+	default:
+		return statusUnpaid
+	}
+}
+
+// billStatus is the most pressing status among the occurrences of a Bill:
+// overdue, then in grace, then due soon. It's empty when none of them need
+// the user's attention.
+func billStatus(items []agendaItem, billId string) string {
+	status := ""
+	for _, item := range items {
+		if item.BillId != billId {
+			continue
+		}
+		switch {
+		case item.Status == statusOverdue:
+			return statusOverdue
+		case item.Status == statusInGrace:
+			status = statusInGrace
+		case item.Status == statusDueSoon && status == "":
+			status = statusDueSoon
+		}
+	}
+	return status
+}
+
+// buildAgenda projects every Bill between 'from' (inclusive) and 'to'
+// (exclusive) and marks each occurrence with the Payments made for it.
+// The result is ordered by due date, then by bill name, so that the
+// same data always produces the same response.
+func buildAgenda(bills []*models.Bill, payments []*models.Payment, from time.Time, to time.Time, today time.Time) ([]agendaItem, error) {
+	paymentsByOccurrence := map[string][]*models.Payment{}
+	for _, payment := range payments {
+		key := occurrenceKey(payment.BillId, payment.DueDate)
+		paymentsByOccurrence[key] = append(paymentsByOccurrence[key], payment)
+	}
+
+	items := make([]agendaItem, 0)
+	for _, bill := range bills {
+		occurrences, err := projectOccurrences(bill, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, occurrence := range occurrences {
+			item := agendaItem{
+				billOccurrence: occurrence,
+				BillName:       bill.Name,
+				TotalPaid:      models.Money{Currency: bill.EstimatedTotalDue.Currency},
+				LateFee:        models.Money{Currency: bill.EstimatedTotalDue.Currency},
+				Payments:       make([]*models.Payment, 0),
+			}
+			for _, payment := range paymentsByOccurrence[occurrenceKey(bill.Id, occurrence.DueDate)] {
+				item.Payments = append(item.Payments, payment)
+				item.TotalPaid, err = item.TotalPaid.Add(payment.TotalPaid)
+				if err != nil {
+					return nil, err
+				}
+			}
+			item.RemainingBalance = remainingBalance(item)
+			item.Status = occurrenceStatus(item, bill.GracePeriodDays, today)
+			if item.Status == statusOverdue {
+				item.LateFee = bill.LateFee
+			}
+			items = append(items, item)
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].DueDate.Equal(items[j].DueDate) {
+			return items[i].DueDate.Before(items[j].DueDate)
+		}
+		if items[i].BillName != items[j].BillName {
+			return items[i].BillName < items[j].BillName
+		}
+		return items[i].BillId < items[j].BillId
+	})
+	return items, nil
+}
+
+// sumAgenda totals the items in the specified currency, converting each
+// occurrence at the rate in effect on its due date. An error is only
+// returned when the rates couldn't be looked up.
+func sumAgenda(items []agendaItem, currency string, converter *exchange.Converter) (agendaTotals, error) {
+	totals := agendaTotals{
+		Currency:     currency,
+		TotalDue:     models.Money{Currency: currency},
+		TotalPaid:    models.Money{Currency: currency},
+		MissingRates: make([]string, 0),
+	}
+	missing := map[string]bool{}
+	for _, item := range items {
+		due, dueErr := converter.Convert(item.EstimatedTotalDue, currency, item.DueDate)
+		paid, paidErr := converter.Convert(item.TotalPaid, currency, item.DueDate)
+		for _, err := range []error{dueErr, paidErr} {
+			if err != nil && !errors.Is(err, exchange.ErrNoRate) {
+				return agendaTotals{}, err
+			}
+		}
+		if dueErr != nil || paidErr != nil {
+			missing[item.EstimatedTotalDue.Currency] = true
+			continue
+		}
+		totals.TotalDue.Amount += due.Amount
+		totals.TotalPaid.Amount += paid.Amount
+	}
+	for currency := range missing {
+		totals.MissingRates = append(totals.MissingRates, currency)
+	}
+	sort.Strings(totals.MissingRates)
+	return totals, nil
+}
+
+func (s *Server) fetchAgenda() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	billRepo := models.BillRepository{DB: s.DB}
+	paymentRepo := models.PaymentRepository{DB: s.DB}
+	rateRepo := &models.ExchangeRateRepository{DB: s.DB}
+	type RequestParams struct {
+		From string `json:"from" validate:"required,datetime=2006-01-02"`
+		To   string `json:"to" validate:"required,datetime=2006-01-02"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := r.Context().Value("jwtClaims").(jwt.Claims)
+		if !ok {
+			resp.SetResult(http.StatusUnauthorized, nil)
+			return
+		}
+
+		// Validate the request params
+		requestParams := &RequestParams{}
+		queryParams := r.URL.Query()
+		from, ok := queryParams["from"]
+		if ok && (len(from) > 0) {
+			requestParams.From = from[0]
+		}
+		to, ok := queryParams["to"]
+		if ok && (len(to) > 0) {
+			requestParams.To = to[0]
+		}
+		messages, err := s.Validator.Validate(requestParams)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Parse out the dates
+		fromDate, fromErr := time.Parse("2006-01-02", requestParams.From)
+		toDate, toErr := time.Parse("2006-01-02", requestParams.To)
+		if fromErr != nil || toErr != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = validateWindow(fromDate, toDate)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(err.Error())
+			return
+		}
+
+		// Fetch the User, their Bills & the Payments made within the window
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		bills, err := billRepo.FetchAllUserBills(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		payments, err := paymentRepo.FetchAllUserPayments(claims.UserID, fromDate, toDate)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// Join them together
+		today := localDate(time.Now(), user.Location())
+		occurrences, err := buildAgenda(bills, payments, fromDate, toDate, today)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		totals, err := sumAgenda(occurrences, user.HomeCurrency, exchange.NewConverter(rateRepo, s.MaxExchangeRateAge))
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, agendaResponseBody{
+			From:        fromDate,
+			To:          toDate,
+			Totals:      totals,
+			Occurrences: occurrences,
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/exchange"
+	"github.com/generalledger/response"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func mustParseDate(value string) time.Time {
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestBuildAgenda(t *testing.T) {
+	rent := &models.Bill{
+		Id:                 "rent",
+		Name:               "Rent",
+		Frequency:          "monthly",
+		EstimatedTotalDue:  models.Money{Amount: 100000, Currency: "USD"},
+		FirstDueDate:       mustParseDate("2020-01-01"),
+		RecurrenceInterval: 1,
+		RecurrenceUnit:     "month",
+	}
+	internet := &models.Bill{
+		Id:                 "internet",
+		Name:               "Internet",
+		Frequency:          "monthly",
+		EstimatedTotalDue:  models.Money{Amount: 5000, Currency: "USD"},
+		FirstDueDate:       mustParseDate("2020-01-01"),
+		RecurrenceInterval: 1,
+		RecurrenceUnit:     "month",
+	}
+	payments := []*models.Payment{
+		{Id: "p1", BillId: "rent", DueDate: mustParseDate("2020-01-01"), TotalPaid: models.Money{Amount: 100000, Currency: "USD"}},
+		{Id: "p2", BillId: "internet", DueDate: mustParseDate("2020-02-01"), TotalPaid: models.Money{Amount: 2000, Currency: "USD"}},
+		{Id: "p3", BillId: "rent", DueDate: mustParseDate("2020-03-01"), TotalPaid: models.Money{Amount: 40000, Currency: "USD"}},
+		{Id: "p4", BillId: "internet", DueDate: mustParseDate("2020-03-01"), TotalPaid: models.Money{Amount: 2500, Currency: "USD"}},
+		{Id: "p5", BillId: "internet", DueDate: mustParseDate("2020-03-01"), TotalPaid: models.Money{Amount: 2500, Currency: "USD"}},
+	}
+
+	items, err := buildAgenda(
+		[]*models.Bill{rent, internet},
+		payments,
+		mustParseDate("2020-01-01"),
+		mustParseDate("2020-04-01"),
+		mustParseDate("2020-02-15"),
+	)
+	assert.Nil(t, err)
+
+	type summary struct {
+		BillId           string
+		DueDate          string
+		Status           string
+		TotalPaid        int64
+		RemainingBalance int64
+	}
+	summaries := []summary{}
+	for _, item := range items {
+		summaries = append(summaries, summary{
+			BillId:           item.BillId,
+			DueDate:          item.DueDate.Format("2006-01-02"),
+			Status:           item.Status,
+			TotalPaid:        item.TotalPaid.Amount,
+			RemainingBalance: item.RemainingBalance.Amount,
+		})
+	}
+	assert.Equal(t, []summary{
+		{"internet", "2020-01-01", statusOverdue, 0, 5000},
+		{"rent", "2020-01-01", statusPaid, 100000, 0},
+		{"internet", "2020-02-01", statusOverdue, 2000, 3000},
+		{"rent", "2020-02-01", statusOverdue, 0, 100000},
+		{"internet", "2020-03-01", statusPaid, 5000, 0},
+		{"rent", "2020-03-01", statusPartiallyPaid, 40000, 60000},
+	}, summaries)
+	assert.Equal(t, []*models.Payment{payments[3], payments[4]}, items[4].Payments)
+	assert.Equal(t, []*models.Payment{payments[0]}, items[1].Payments)
+	assert.Equal(t, []*models.Payment{}, items[0].Payments)
+
+	// Bills with an unknown recurrence unit cannot be projected
+	_, err = buildAgenda(
+		[]*models.Bill{{Id: "broken", RecurrenceInterval: 1, RecurrenceUnit: "fortnight"}},
+		nil,
+		mustParseDate("2020-01-01"),
+		mustParseDate("2020-04-01"),
+		mustParseDate("2020-02-15"),
+	)
+	assert.NotNil(t, err)
+}
+
+func TestOccurrenceStatus(t *testing.T) {
+	today := mustParseDate("2020-02-15")
+	item := func(dueDate string, totalPaid int64, payments int) agendaItem {
+		item := agendaItem{
+			billOccurrence: billOccurrence{DueDate: mustParseDate(dueDate), EstimatedTotalDue: models.Money{Amount: 5000, Currency: "USD"}},
+			TotalPaid:      models.Money{Amount: totalPaid, Currency: "USD"},
+			Payments:       make([]*models.Payment, payments),
+		}
+		item.RemainingBalance = remainingBalance(item)
+		return item
+	}
+
+	// Paid occurrences are never late
+	assert.Equal(t, statusPaid, occurrenceStatus(item("2020-01-01", 5000, 2), 0, today))
+
+	// Late occurrences are in grace until their grace period has passed
+	assert.Equal(t, statusOverdue, occurrenceStatus(item("2020-02-14", 0, 0), 0, today))
+	assert.Equal(t, statusInGrace, occurrenceStatus(item("2020-02-14", 0, 0), 1, today))
+	assert.Equal(t, statusInGrace, occurrenceStatus(item("2020-02-10", 2500, 1), 5, today))
+	assert.Equal(t, statusOverdue, occurrenceStatus(item("2020-02-09", 2500, 1), 5, today))
+
+	// Occurrences due within the next few days are due soon
+	assert.Equal(t, statusDueSoon, occurrenceStatus(item("2020-02-15", 0, 0), 0, today))
+	assert.Equal(t, statusDueSoon, occurrenceStatus(item("2020-02-17", 2500, 1), 0, today))
+	assert.Equal(t, statusPartiallyPaid, occurrenceStatus(item("2020-02-18", 2500, 1), 0, today))
+	assert.Equal(t, statusUnpaid, occurrenceStatus(item("2020-02-18", 0, 0), 0, today))
+}
+
+func TestBillStatus(t *testing.T) {
+	item := func(billId string, status string) agendaItem {
+		return agendaItem{billOccurrence: billOccurrence{BillId: billId, Status: status}}
+	}
+	items := []agendaItem{
+		item("rent", statusPaid),
+		item("phone", statusDueSoon),
+		item("phone", statusInGrace),
+		item("gym", statusOverdue),
+		item("gym", statusDueSoon),
+		item("water", statusUnpaid),
+	}
+
+	// The most pressing status of the Bill's occurrences wins
+	assert.Equal(t, statusInGrace, billStatus(items, "phone"))
+	assert.Equal(t, statusOverdue, billStatus(items, "gym"))
+
+	// Bills that don't need attention have no status
+	assert.Equal(t, "", billStatus(items, "rent"))
+	assert.Equal(t, "", billStatus(items, "water"))
+	assert.Equal(t, "", billStatus(items, "internet"))
+}
+
+func TestBuildAgendaLateFees(t *testing.T) {
+	phone := &models.Bill{
+		Id:                 "phone",
+		Name:               "Phone",
+		Frequency:          "monthly",
+		EstimatedTotalDue:  models.Money{Amount: 4000, Currency: "USD"},
+		FirstDueDate:       mustParseDate("2020-01-10"),
+		RecurrenceInterval: 1,
+		RecurrenceUnit:     "month",
+		GracePeriodDays:    7,
+		LateFee:            models.Money{Amount: 1500, Currency: "USD"},
+	}
+	items, err := buildAgenda(
+		[]*models.Bill{phone},
+		nil,
+		mustParseDate("2020-01-01"),
+		mustParseDate("2020-03-01"),
+		mustParseDate("2020-02-15"),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(items))
+	assert.Equal(t, statusOverdue, items[0].Status)
+	assert.Equal(t, models.Money{Amount: 1500, Currency: "USD"}, items[0].LateFee)
+	assert.Equal(t, statusInGrace, items[1].Status)
+	assert.Equal(t, models.Money{Amount: 0, Currency: "USD"}, items[1].LateFee)
+}
+
+func TestAgendaFetch(t *testing.T) {
+	// Prepare the Server & seed some data
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	user1 := server.SeedUser()
+	user1Bill := server.SeedBill(user1["id"].(string))
+	user1Payment := server.SeedPayment(user1Bill["id"].(string))
+	user2 := server.SeedUser()
+	server.SeedBill(user2["id"].(string))
+
+	// Validate auth is required
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/agenda", nil)
+	server.fetchAgenda()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusUnauthorized,
+			StatusText:   http.StatusText(http.StatusUnauthorized),
+			ErrorDetails: nil,
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Ensure that we are validating our requests
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/agenda?from=invalid&to=invalid", user1["id"].(string), nil)
+	server.fetchAgenda()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode: http.StatusBadRequest,
+			StatusText: http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{
+				"From does not match the 2006-01-02 format",
+				"To does not match the 2006-01-02 format",
+			},
+			Result: nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Ensure that backwards & overly long windows are rejected
+	for query, message := range map[string]string{
+		"from=2020-06-01&to=2020-05-01": "To must not be before From.",
+		"from=2020-01-01&to=2021-01-03": "The window must not span more than 366 days.",
+	} {
+		recorder = httptest.NewRecorder()
+		req = server.NewAuthenticatedRequest(http.MethodGet, "/agenda?"+query, user1["id"].(string), nil)
+		server.fetchAgenda()(recorder, req)
+		resp := response.Parse(recorder.Result().Body)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		assert.Equal(t, &[]string{message}, resp.ErrorDetails, query)
+	}
+
+	// Ensure that user1 only sees their own bill, marked as paid. The seeded
+	// bill & payment are both due 10 days from now, so a window spanning
+	// the next 20 days contains exactly one occurrence.
+	from := time.Now().UTC().Format("2006-01-02")
+	to := time.Now().UTC().Add(time.Hour * 24 * 20).Format("2006-01-02")
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/agenda?from="+from+"&to="+to, user1["id"].(string), nil)
+	server.fetchAgenda()(recorder, req)
+	resp := response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	occurrences := resp.Result.(map[string]interface{})["occurrences"].([]interface{})
+	assert.Equal(t, 1, len(occurrences))
+	occurrence := occurrences[0].(map[string]interface{})
+	assert.Equal(t, user1Bill["id"], occurrence["bill_id"])
+	assert.Equal(t, statusPaid, occurrence["status"])
+	assert.Equal(t, user1Payment["id"], occurrence["payments"].([]interface{})[0].(map[string]interface{})["id"])
+	totals := resp.Result.(map[string]interface{})["totals"].(map[string]interface{})
+	assert.Equal(t, "USD", totals["currency"])
+	assert.Equal(t, []interface{}{}, totals["missing_rates"])
+}
+
+// staticRates is a RateFetcher serving a fixed set of EUR based rates.
+type staticRates map[string]string
+
+func (s staticRates) FetchRate(base string, quote string, on time.Time) (*models.ExchangeRate, error) {
+	value, ok := s[base+quote]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	rate, _ := new(big.Rat).SetString(value)
+	return &models.ExchangeRate{Base: base, Quote: quote, EffectiveDate: on, Rate: rate}, nil
+}
+
+func TestSumAgenda(t *testing.T) {
+	items := []agendaItem{
+		{
+			billOccurrence: billOccurrence{BillId: "rent", DueDate: mustParseDate("2020-05-01"), EstimatedTotalDue: models.Money{Amount: 100000, Currency: "EUR"}},
+			TotalPaid:      models.Money{Amount: 50000, Currency: "EUR"},
+		},
+		{
+			billOccurrence: billOccurrence{BillId: "internet", DueDate: mustParseDate("2020-05-01"), EstimatedTotalDue: models.Money{Amount: 5000, Currency: "USD"}},
+			TotalPaid:      models.Money{Amount: 5000, Currency: "USD"},
+		},
+		{
+			billOccurrence: billOccurrence{BillId: "phone", DueDate: mustParseDate("2020-05-01"), EstimatedTotalDue: models.Money{Amount: 3000, Currency: "JPY"}},
+			TotalPaid:      models.Money{Amount: 0, Currency: "JPY"},
+		},
+	}
+	totals, err := sumAgenda(items, "USD", exchange.NewConverter(staticRates{"EURUSD": "1.10"}, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, agendaTotals{
+		Currency:     "USD",
+		TotalDue:     models.Money{Amount: 115000, Currency: "USD"},
+		TotalPaid:    models.Money{Amount: 60000, Currency: "USD"},
+		MissingRates: []string{"JPY"},
+	}, totals)
+}
+
+// unreachableRates is a RateFetcher whose database can't be reached.
+type unreachableRates struct{}
+
+func (unreachableRates) FetchRate(base string, quote string, on time.Time) (*models.ExchangeRate, error) {
+	return nil, errors.New("dial tcp: connection refused")
+}
+
+func TestSumAgendaRateErrors(t *testing.T) {
+	items := []agendaItem{
+		{
+			billOccurrence: billOccurrence{BillId: "rent", DueDate: mustParseDate("2020-05-01"), EstimatedTotalDue: models.Money{Amount: 100000, Currency: "EUR"}},
+			TotalPaid:      models.Money{Amount: 0, Currency: "EUR"},
+		},
+	}
+	// Rates that can't be looked up fail the totals, rather than being
+	// reported as missing
+	_, err := sumAgenda(items, "USD", exchange.NewConverter(unreachableRates{}, 0))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/jwt"
+	"github.com/generalledger/response"
+	"net/http"
+	"strings"
+)
+
+const (
+	// apiKeyPrefix starts every API key, so that they're easy to tell apart
+	// from access tokens, & to spot when they're leaked.
+	apiKeyPrefix = "bp_"
+
+	// apiKeyVisibleLength is how much of a key is stored as its Prefix,
+	// which is shown to users so that they can tell their keys apart.
+	apiKeyVisibleLength = len(apiKeyPrefix) + 8
+)
+
+type apiKeyCreatedResponseBody struct {
+	*models.ApiKey
+
+	// Key is only ever returned when the key is created.
+	Key string `json:"key"`
+}
+
+// newApiKey generates a random API key, along with the hash of it that we
+// store in its place.
+func newApiKey() (key string, hash string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, hashSecretToken(key), nil
+}
+
+// authenticateApiKey is the middleware.ApiKeyAuthenticator, which returns
+// Claims granted the scopes of the key.
+func (s *Server) authenticateApiKey(key string) (*jwt.Claims, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, errors.New("Not an API key")
+	}
+	apiKeyRepo := models.ApiKeyRepository{DB: s.DB}
+	apiKey, err := apiKeyRepo.FetchByKeyHash(hashSecretToken(key))
+	if err != nil {
+		return nil, err
+	}
+	err = apiKeyRepo.Touch(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return &jwt.Claims{
+		UserID:   apiKey.UserId,
+		Scope:    strings.Join(apiKey.Scopes, " "),
+		ApiKeyId: apiKey.Id,
+	}, nil
+}
+
+// sessionClaims pulls out the JWT Claims of a request, which has to have
+// been made with an access token. API keys can't be used to manage API
+// keys or the user's credentials, so that a leaked key can't be used to
+// make more of them, or to take over the account.
+func sessionClaims(resp *response.Response, r *http.Request) (jwt.Claims, bool) {
+	claims, ok := r.Context().Value("jwtClaims").(jwt.Claims)
+	if !ok {
+		resp.SetResult(http.StatusUnauthorized, nil)
+		return claims, false
+	}
+	if claims.ApiKeyId != "" {
+		resp.SetResult(http.StatusForbidden, nil).
+			WithErrorDetails("API keys can't be used for this.")
+		return claims, false
+	}
+	return claims, true
+}
+
+func (s *Server) fetchApiKeys() http.HandlerFunc {
+	apiKeyRepo := models.ApiKeyRepository{DB: s.DB}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := sessionClaims(resp, r)
+		if !ok {
+			return
+		}
+
+		// Fetch the User's API keys
+		apiKeys, err := apiKeyRepo.FetchAllUserKeys(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, apiKeys)
+	}
+}
+
+func (s *Server) createApiKey() http.HandlerFunc {
+	apiKeyRepo := models.ApiKeyRepository{DB: s.DB}
+	type RequestBody struct {
+		Name   string   `json:"name" validate:"required,max=100"`
+		Scopes []string `json:"scopes" validate:"required,min=1,dive,oneof=read write"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := sessionClaims(resp, r)
+		if !ok {
+			return
+		}
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Generate & store the key
+		key, hash, err := newApiKey()
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		apiKey := &models.ApiKey{
+			UserId:  claims.UserID,
+			Name:    requestBody.Name,
+			Prefix:  key[:apiKeyVisibleLength],
+			KeyHash: hash,
+			Scopes:  requestBody.Scopes,
+		}
+		err = apiKeyRepo.Insert(apiKey)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, apiKeyCreatedResponseBody{
+			ApiKey: apiKey,
+			Key:    key,
+		})
+	}
+}
+
+func (s *Server) deleteApiKey() http.HandlerFunc {
+	apiKeyRepo := models.ApiKeyRepository{DB: s.DB}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := sessionClaims(resp, r)
+		if !ok {
+			return
+		}
+
+		// Revoke the API key, which has to be one of the User's
+		id := strings.Split(r.URL.Path, "/")[4]
+		err := apiKeyRepo.DeleteUserKey(claims.UserID, id)
+		if err != nil {
+			resp.SetResult(http.StatusNotFound, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, nil)
+	}
+}
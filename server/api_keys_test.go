@@ -0,0 +1,126 @@
+package server
+
+import (
+	"github.com/beanpay/api/server/jwt"
+	"github.com/beanpay/api/server/middleware"
+	"github.com/generalledger/response"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// seedApiKey creates a key of the user with every scope, returning it.
+func seedApiKey(t *testing.T, server *TestServer, userId string) string {
+	recorder := httptest.NewRecorder()
+	req := server.NewAuthenticatedRequest(http.MethodPost, "/users/me/api-keys", userId, UpdateUserBody{
+		"name":   "Script",
+		"scopes": []string{jwt.ScopeRead, jwt.ScopeWrite},
+	})
+	server.createApiKey()(recorder, req)
+	resp := response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	return resp.Result.(map[string]interface{})["key"].(string)
+}
+
+// apiKeyStatus returns the status code of fetching bills with an API key.
+func apiKeyStatus(server *TestServer, key string) int {
+	requireAuth := middleware.GetRequireAuthMiddleware(server.JwtSignatory, server.authenticateApiKey)
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/bills", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	requireAuth(server.fetchBills(), jwt.ScopeRead)(recorder, req)
+	return response.Parse(recorder.Result().Body).StatusCode
+}
+
+func TestApiKeys(t *testing.T) {
+	// Prepare the Server & a logged in user
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	userId, _ := seedLoggedInUser(t, server, realUserEmail, realUserPassword)
+	otherUser := server.SeedUser()
+	requireAuth := middleware.GetRequireAuthMiddleware(server.JwtSignatory, server.authenticateApiKey)
+
+	// Validate the scopes
+	recorder := httptest.NewRecorder()
+	req := server.NewAuthenticatedRequest(http.MethodPost, "/users/me/api-keys", userId, UpdateUserBody{
+		"name":   "Bill importer",
+		"scopes": []string{"admin"},
+	})
+	server.createApiKey()(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Create a read-only key, which is only returned this once
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/api-keys", userId, UpdateUserBody{
+		"name":   "Dashboard",
+		"scopes": []string{jwt.ScopeRead},
+	})
+	server.createApiKey()(recorder, req)
+	resp := response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	created := resp.Result.(map[string]interface{})
+	key := created["key"].(string)
+	assert.Regexp(t, "^bp_", key)
+	assert.Equal(t, key[:apiKeyVisibleLength], created["prefix"])
+	assert.Nil(t, created["key_hash"])
+
+	// The key can read, but not write
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/bills", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	requireAuth(server.fetchBills(), jwt.ScopeRead)(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/bills", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	requireAuth(server.createBill(), jwt.ScopeWrite)(recorder, req)
+	assert.Equal(t, http.StatusForbidden, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Nor can it be used to manage API keys
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/users/me/api-keys", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	requireAuth(server.fetchApiKeys(), jwt.ScopeRead)(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusForbidden,
+			StatusText:   http.StatusText(http.StatusForbidden),
+			ErrorDetails: &[]string{"API keys can't be used for this."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// List the keys, which records when they were last used
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/users/me/api-keys", userId, nil)
+	server.fetchApiKeys()(recorder, req)
+	resp = response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	apiKeys := resp.Result.([]interface{})
+	assert.Equal(t, 1, len(apiKeys))
+	apiKey := apiKeys[0].(map[string]interface{})
+	assert.Equal(t, "Dashboard", apiKey["name"])
+	assert.Equal(t, []interface{}{jwt.ScopeRead}, apiKey["scopes"])
+	assert.NotNil(t, apiKey["last_used_at"])
+	assert.Nil(t, apiKey["key"])
+
+	// Ensure other users can't revoke it
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodDelete, "/users/me/api-keys/"+apiKey["id"].(string), otherUser["id"].(string), nil)
+	server.deleteApiKey()(recorder, req)
+	assert.Equal(t, http.StatusNotFound, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Revoke it, after which it's rejected
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodDelete, "/users/me/api-keys/"+apiKey["id"].(string), userId, nil)
+	server.deleteApiKey()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/bills", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	requireAuth(server.fetchBills(), jwt.ScopeRead)(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+}
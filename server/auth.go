@@ -1,11 +1,17 @@
 package server
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/jwt"
+	"github.com/beanpay/api/server/mail"
+	"github.com/beanpay/api/server/middleware"
 	"github.com/generalledger/response"
 	"github.com/satori/go.uuid"
 	"golang.org/x/crypto/bcrypt"
+	"io"
 	"net/http"
 	"time"
 )
@@ -15,17 +21,34 @@ const (
 	refreshTokenDuration = 90 * (24 * time.Hour)
 )
 
+// The ways a RefreshToken can be handed to the client. Browsers get it as
+// an HttpOnly cookie, which is the default. Native clients, which can't rely
+// on cookies, opt in to getting it in the response body instead, & send it
+// back in the request body.
+const (
+	refreshTokenDeliveryCookie = "cookie"
+	refreshTokenDeliveryBody   = "body"
+)
+
 type authResponseBody struct {
 	AccessToken           string    `json:"access_token"`
 	AccessTokenExpiration time.Time `json:"access_token_expiration"`
+
+	// RefreshToken is only set when it's delivered in the body.
+	RefreshToken           string     `json:"refresh_token,omitempty"`
+	RefreshTokenExpiration *time.Time `json:"refresh_token_expiration,omitempty"`
+}
+
+type refreshTokenRequestBody struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 func (s *Server) login() http.HandlerFunc {
-	refreshTokenRepo := models.RefreshTokenRepository{DB: s.DB}
 	userRepo := models.UserRepository{DB: s.DB}
 	type RequestBody struct {
-		Email    string `json:"email" validate:"required,email"`
-		Password string `json:"password" validate:"required"`
+		Email                string `json:"email" validate:"required,email"`
+		Password             string `json:"password" validate:"required"`
+		RefreshTokenDelivery string `json:"refresh_token_delivery" validate:"omitempty,oneof=cookie body"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -47,6 +70,19 @@ func (s *Server) login() http.HandlerFunc {
 			return
 		}
 
+		// Turn away accounts & clients that have failed too many times,
+		// before spending any time on the password. Otherwise the attempt
+		// is counted as failed until the password proves otherwise.
+		retryAfter, err := s.attemptLogin(r, requestBody.Email)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if retryAfter > 0 {
+			tooManyLoginAttempts(w, resp, retryAfter)
+			return
+		}
+
 		// Fetch the user
 		user, err := userRepo.FetchByEmail(requestBody.Email)
 		if err != nil {
@@ -61,60 +97,153 @@ func (s *Server) login() http.HandlerFunc {
 			return
 		}
 
-		// Generate a Signed JWT AccessToken
-		accessTokenExpiration := time.Now().Add(accessTokenDuration)
-		accessToken, err := s.JwtSignatory.GenerateSignedToken(user.Id, accessTokenExpiration)
-		if err != nil {
-			resp.SetResult(http.StatusInternalServerError, nil)
+		// Depending on our policy, the email address must be verified
+		if s.EmailVerification == EmailVerificationRequired && !user.IsVerified() {
+			s.releaseLogin(r, requestBody.Email)
+			resp.SetResult(http.StatusForbidden, nil).
+				WithErrorDetails("Please verify your email address before logging in.")
 			return
 		}
 
-		// Generate a RefreshToken
-		chainId := uuid.NewV4()
-		refreshToken := &models.RefreshToken{
-			ChainId: chainId.String(),
-			UserId:  user.Id,
+		// Users with two-factor authentication get a challenge to answer
+		// with a code at /auth/login/mfa, rather than a session.
+		if user.IsTotpEnabled() {
+			s.releaseLogin(r, requestBody.Email)
+			mfaTokenExpiration := time.Now().Add(mfaTokenDuration)
+			mfaToken, err := s.JwtSignatory.GenerateSignedPurposeToken(user.Id, mfaTokenPurpose, mfaTokenExpiration)
+			if err != nil {
+				resp.SetResult(http.StatusInternalServerError, nil)
+				return
+			}
+			resp.SetResult(http.StatusOK, mfaChallengeResponseBody{
+				MfaRequired:        true,
+				MfaToken:           mfaToken,
+				MfaTokenExpiration: mfaTokenExpiration,
+			})
+			return
 		}
-		err = refreshTokenRepo.Insert(refreshToken)
+
+		// OK
+		s.succeedLogin(r, requestBody.Email)
+		body, err := s.startSession(w, r, user.Id, requestBody.RefreshTokenDelivery)
 		if err != nil {
 			resp.SetResult(http.StatusInternalServerError, nil)
 			return
 		}
+		resp.SetResult(http.StatusOK, body)
+	}
+}
 
-		// OK
-		http.SetCookie(w, &http.Cookie{
-			Name:     "refresh_token",
-			Value:    refreshToken.Id,
-			Expires:  time.Now().Add(refreshTokenDuration),
-			Path:     "/",
-			Secure:   true,
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
-		})
-		resp.SetResult(http.StatusOK, authResponseBody{
-			AccessToken:           accessToken,
-			AccessTokenExpiration: accessTokenExpiration,
-		})
+// startSession generates an AccessToken & the first RefreshToken of a new
+// chain for the user, & delivers the RefreshToken as a cookie, or in the
+// body.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, userId string, delivery string) (*authResponseBody, error) {
+	refreshTokenRepo := models.RefreshTokenRepository{DB: s.DB}
+
+	// Generate a Signed JWT AccessToken
+	accessTokenExpiration := time.Now().Add(accessTokenDuration)
+	accessToken, err := s.JwtSignatory.GenerateSignedToken(userId, jwt.AllScopes, accessTokenExpiration)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate a RefreshToken
+	chainId := uuid.NewV4()
+	refreshToken := &models.RefreshToken{
+		ChainId:   chainId.String(),
+		UserId:    userId,
+		UserAgent: userAgent(r),
+		IpAddress: middleware.ClientIP(r),
+	}
+	err = refreshTokenRepo.Insert(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	body := &authResponseBody{
+		AccessToken:           accessToken,
+		AccessTokenExpiration: accessTokenExpiration,
 	}
+	deliverRefreshToken(w, body, refreshToken, delivery)
+	return body, nil
+}
+
+// deliverRefreshToken hands the RefreshToken to the client, in the body of
+// the response, or as a cookie by default.
+func deliverRefreshToken(w http.ResponseWriter, body *authResponseBody, refreshToken *models.RefreshToken, delivery string) {
+	if delivery == refreshTokenDeliveryBody {
+		expiration := refreshToken.CreatedAt.Add(refreshTokenDuration)
+		body.RefreshToken = refreshToken.Id
+		body.RefreshTokenExpiration = &expiration
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken.Id,
+		Expires:  time.Now().Add(refreshTokenDuration),
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// requestRefreshToken returns the RefreshToken a request was sent with, &
+// how it was sent. Tokens in the body take precedence over the cookie.
+func requestRefreshToken(r *http.Request) (refreshTokenId string, delivery string, err error) {
+	var requestBody refreshTokenRequestBody
+	err = json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil && err != io.EOF {
+		return "", "", err
+	}
+	if requestBody.RefreshToken != "" {
+		return requestBody.RefreshToken, refreshTokenDeliveryBody, nil
+	}
+	refreshTokenCookie, err := r.Cookie("refresh_token")
+	if err != nil {
+		return "", "", err
+	}
+	return refreshTokenCookie.Value, refreshTokenDeliveryCookie, nil
+}
+
+// clearRefreshTokenCookie sets the refresh_token to force an override of
+// any existing cookies. This cookie is set as already expired. This needs
+// to be done as it's a HttpOnly cookie, so this cannot be deleted from the
+// client.
+func clearRefreshTokenCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    "",
+		Expires:  time.Unix(0, 0),
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
 }
 
 func (s *Server) logout() http.HandlerFunc {
+	refreshTokenRepo := models.RefreshTokenRepository{DB: s.DB}
 	return func(w http.ResponseWriter, r *http.Request) {
 		resp := response.New(w)
 		defer resp.Output()
-		// Set the refresh_token to force an override of any
-		// existing cookies. This cookie is set as already expired.
-		// This needs to be done as it's a HttpOnly cookie,
-		// so this cannot be deleted from the client.
-		http.SetCookie(w, &http.Cookie{
-			Name:     "refresh_token",
-			Value:    "",
-			Expires:  time.Unix(0, 0),
-			Path:     "/",
-			Secure:   true,
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
-		})
+
+		// Revoke the session, so the refresh token can't be used again even
+		// if it was copied out of the cookie. It may already be gone, in
+		// which case there's nothing to revoke.
+		refreshTokenId, _, err := requestRefreshToken(r)
+		if err == nil {
+			refreshToken, err := refreshTokenRepo.FetchByID(refreshTokenId)
+			if err == nil {
+				err = refreshTokenRepo.DeleteChain(refreshToken.ChainId)
+				if err != nil {
+					resp.SetResult(http.StatusInternalServerError, nil)
+					return
+				}
+			}
+		}
+
+		clearRefreshTokenCookie(w)
 		resp.SetResult(http.StatusOK, nil)
 	}
 }
@@ -127,14 +256,14 @@ func (s *Server) authRefresh() http.HandlerFunc {
 		defer resp.Output()
 
 		// Get the refresh token
-		refreshTokenCookie, err := r.Cookie("refresh_token")
+		refreshTokenId, delivery, err := requestRefreshToken(r)
 		if err != nil {
 			resp.SetResult(http.StatusUnauthorized, nil)
 			return
 		}
 
 		// Load the Refresh Token
-		refreshToken, err := refreshTokenRepo.FetchByID(refreshTokenCookie.Value)
+		refreshToken, err := refreshTokenRepo.FetchByID(refreshTokenId)
 		if err != nil {
 			resp.SetResult(http.StatusUnauthorized, nil)
 			return
@@ -180,7 +309,7 @@ func (s *Server) authRefresh() http.HandlerFunc {
 
 		// Generate a new Signed JWT AccessToken
 		accessTokenExpiration := time.Now().Add(accessTokenDuration)
-		accessToken, err := s.JwtSignatory.GenerateSignedToken(refreshToken.UserId, accessTokenExpiration)
+		accessToken, err := s.JwtSignatory.GenerateSignedToken(refreshToken.UserId, jwt.AllScopes, accessTokenExpiration)
 		if err != nil {
 			resp.SetResult(http.StatusInternalServerError, nil)
 			return
@@ -188,8 +317,10 @@ func (s *Server) authRefresh() http.HandlerFunc {
 
 		// Generate a new RefreshToken
 		newRefreshToken := &models.RefreshToken{
-			ChainId: refreshToken.ChainId,
-			UserId:  refreshToken.UserId,
+			ChainId:   refreshToken.ChainId,
+			UserId:    refreshToken.UserId,
+			UserAgent: userAgent(r),
+			IpAddress: middleware.ClientIP(r),
 		}
 		err = refreshTokenRepo.Insert(newRefreshToken)
 		if err != nil {
@@ -197,19 +328,193 @@ func (s *Server) authRefresh() http.HandlerFunc {
 			return
 		}
 
-		// OK
-		http.SetCookie(w, &http.Cookie{
-			Name:     "refresh_token",
-			Value:    newRefreshToken.Id,
-			Expires:  time.Now().Add(refreshTokenDuration),
-			Path:     "/",
-			Secure:   true,
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
-		})
-		resp.SetResult(http.StatusOK, authResponseBody{
+		// OK, delivering the new RefreshToken the same way the old one was
+		body := &authResponseBody{
 			AccessToken:           accessToken,
 			AccessTokenExpiration: accessTokenExpiration,
+		}
+		deliverRefreshToken(w, body, newRefreshToken, delivery)
+		resp.SetResult(http.StatusOK, body)
+	}
+}
+
+// passwordResetTokenDuration is how long the link to reset a forgotten
+// password stays valid.
+const passwordResetTokenDuration = 1 * time.Hour
+
+func (s *Server) requestPasswordReset() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	userTokenRepo := models.UserTokenRepository{DB: s.DB}
+	type RequestBody struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Fetch the User. We respond the same way whether or not there's an
+		// account for the address, so this can't be used to find out who
+		// has one.
+		user, err := userRepo.FetchByEmail(requestBody.Email)
+		if err != nil {
+			resp.SetResult(http.StatusOK, nil)
+			return
+		}
+
+		// Issue a token, replacing any that were issued before
+		token, tokenHash, err := newSecretToken()
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = userTokenRepo.DeleteAllUserTokens(user.Id, models.UserTokenResetPassword)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = userTokenRepo.Insert(&models.UserToken{
+			UserId:    user.Id,
+			Purpose:   models.UserTokenResetPassword,
+			Email:     user.Email,
+			TokenHash: tokenHash,
+			ExpiresAt: time.Now().Add(passwordResetTokenDuration),
+		})
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// Mail the token to the account's address
+		err = s.Mailer.Send(mail.Message{
+			To:      user.Email,
+			Subject: "Reset your BeanPay password",
+			Body: fmt.Sprintf(
+				"Follow this link within an hour to choose a new password for your BeanPay account:\n\n%v/reset-password?token=%v\n\nIf you didn't ask to reset your password, you can ignore this email.",
+				s.AppURL,
+				token,
+			),
+		})
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, nil)
+	}
+}
+
+func (s *Server) confirmPasswordReset() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	userTokenRepo := models.UserTokenRepository{DB: s.DB}
+	refreshTokenRepo := models.RefreshTokenRepository{DB: s.DB}
+	apiKeyRepo := models.ApiKeyRepository{DB: s.DB}
+	type RequestBody struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required,min=8"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Consume the Token before anything else, so that it's only ever
+		// used once, & fetch the User it was issued to. A token mailed to an
+		// address the User has since moved away from is no longer honoured.
+		userToken, err := userTokenRepo.Consume(models.UserTokenResetPassword, hashSecretToken(requestBody.Token))
+		if err == sql.ErrNoRows {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("The token is invalid or has expired.")
+			return
+		}
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		user, err := userRepo.FetchByID(userToken.UserId)
+		if err != nil || user.Email != userToken.Email {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("The token is invalid or has expired.")
+			return
+		}
+
+		// Encrypt & store the new Password. The token was mailed to the
+		// User's address, so it also proves they own it.
+		pwBytes, err := bcrypt.GenerateFromPassword([]byte(requestBody.Password), 14)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		user.Password = string(pwBytes)
+		err = userRepo.UpdatePassword(user)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if !user.IsVerified() {
+			err = userRepo.MarkVerified(user, time.Now())
+			if err != nil {
+				resp.SetResult(http.StatusInternalServerError, nil)
+				return
+			}
+		}
+
+		// Any other tokens are revoked, every session is signed out & every
+		// API key is revoked
+		err = userTokenRepo.DeleteAllUserTokens(user.Id, models.UserTokenResetPassword)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = refreshTokenRepo.DeleteAllUserChains(user.Id)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = apiKeyRepo.DeleteAllUserKeys(user.Id)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		clearRefreshTokenCookie(w)
+
+		// Let the user know, in case it wasn't them
+		s.Mailer.Send(mail.Message{
+			To:      user.Email,
+			Subject: "Your BeanPay password was reset",
+			Body:    "The password of your BeanPay account was just reset, and every device was signed out and every API key revoked.",
 		})
+
+		// OK
+		resp.SetResult(http.StatusOK, nil)
 	}
 }
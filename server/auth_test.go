@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -160,6 +161,77 @@ func TestAuthRefresh(t *testing.T) {
 	)
 }
 
+func TestAuthRefreshInBody(t *testing.T) {
+	// Prepare the Server & a user
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	seedLoggedInUser(t, server, realUserEmail, realUserPassword)
+
+	// Validate the delivery
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", UpdateUserBody{
+		"email":                  realUserEmail,
+		"password":               realUserPassword,
+		"refresh_token_delivery": "carrier-pigeon",
+	})
+	server.login()(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Login, getting the Refresh Token in the body rather than a cookie
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", UpdateUserBody{
+		"email":                  realUserEmail,
+		"password":               realUserPassword,
+		"refresh_token_delivery": "body",
+	})
+	server.login()(recorder, req)
+	resp := response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, len(recorder.Result().Cookies()))
+	firstRefreshToken := resp.Result.(map[string]interface{})["refresh_token"].(string)
+	assert.NotNil(t, resp.Result.(map[string]interface{})["refresh_token_expiration"])
+
+	// Refresh with it in the body, which rotates it in the body too
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/refresh", UpdateUserBody{"refresh_token": firstRefreshToken})
+	server.authRefresh()(recorder, req)
+	resp = response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, len(recorder.Result().Cookies()))
+	assert.NotNil(t, resp.Result.(map[string]interface{})["access_token"])
+	secondRefreshToken := resp.Result.(map[string]interface{})["refresh_token"].(string)
+	assert.NotEqual(t, firstRefreshToken, secondRefreshToken)
+
+	// Reusing the first token wipes the chain, just like with cookies
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/refresh", UpdateUserBody{"refresh_token": firstRefreshToken})
+	server.authRefresh()(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/refresh", UpdateUserBody{"refresh_token": secondRefreshToken})
+	server.authRefresh()(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Logout revokes a token sent in the body
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", UpdateUserBody{
+		"email":                  realUserEmail,
+		"password":               realUserPassword,
+		"refresh_token_delivery": "body",
+	})
+	server.login()(recorder, req)
+	refreshToken := response.Parse(recorder.Result().Body).Result.(map[string]interface{})["refresh_token"].(string)
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/logout", UpdateUserBody{"refresh_token": refreshToken})
+	server.logout()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/refresh", UpdateUserBody{"refresh_token": refreshToken})
+	server.authRefresh()(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+}
+
 func TestLoginUser(t *testing.T) {
 	// Prepare the Server
 	server, err := NewTestServer()
@@ -294,3 +366,123 @@ func TestLoginUser(t *testing.T) {
 	}
 	assert.True(t, hasRefreshCookie)
 }
+
+func TestPasswordReset(t *testing.T) {
+	// Prepare the Server & a logged in user
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	userId, refreshToken := seedLoggedInUser(t, server, realUserEmail, realUserPassword)
+	apiKey := seedApiKey(t, server, userId)
+	sentMessages := len(server.Outbox().Messages())
+
+	// Test that we are validating our request body
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/password-reset/request", UpdateUserBody{"email": "invalid-email"})
+	server.requestPasswordReset()(recorder, req)
+	assert.Equal(t,
+		&[]string{"Email must be a valid email address"},
+		response.Parse(recorder.Result().Body).ErrorDetails,
+	)
+
+	// Ensure unknown addresses get the same response, but no mail
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/password-reset/request", UpdateUserBody{"email": "nobody@example.com"})
+	server.requestPasswordReset()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	assert.Equal(t, sentMessages, len(server.Outbox().Messages()))
+
+	// Request a reset, which mails a token to the user
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/password-reset/request", UpdateUserBody{"email": realUserEmail})
+	server.requestPasswordReset()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	message, ok := server.Outbox().Last()
+	assert.True(t, ok)
+	assert.Equal(t, realUserEmail, message.To)
+	assert.Contains(t, message.Body, "https://app.example.com/reset-password?token=")
+	token := mailedToken(message.Body)
+
+	// Test that we are validating the confirmation
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/password-reset/confirm", UpdateUserBody{"token": token, "password": "short"})
+	server.confirmPasswordReset()(recorder, req)
+	assert.Equal(t,
+		&[]string{"Password must be at least 8 characters in length"},
+		response.Parse(recorder.Result().Body).ErrorDetails,
+	)
+
+	// Ensure invalid tokens are rejected
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/password-reset/confirm", UpdateUserBody{"token": "invalid-token", "password": "a-brand-new-password"})
+	server.confirmPasswordReset()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusBadRequest,
+			StatusText:   http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{"The token is invalid or has expired."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Reset the password
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/password-reset/confirm", UpdateUserBody{"token": token, "password": "a-brand-new-password"})
+	server.confirmPasswordReset()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Ensure every session was signed out, every API key revoked, & the
+	// token can't be reused
+	assert.Equal(t, http.StatusUnauthorized, refreshStatus(server, refreshToken))
+	assert.Equal(t, http.StatusUnauthorized, apiKeyStatus(server, apiKey))
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/password-reset/confirm", UpdateUserBody{"token": token, "password": "another-new-password"})
+	server.confirmPasswordReset()(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Ensure only the new password can be used to login
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: realUserEmail, Password: realUserPassword})
+	server.login()(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: realUserEmail, Password: "a-brand-new-password"})
+	server.login()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+}
+
+func TestPasswordResetReplay(t *testing.T) {
+	// Prepare the Server & a user, & mail them a reset link
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	seedLoggedInUser(t, server, realUserEmail, realUserPassword)
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/password-reset/request", UpdateUserBody{"email": realUserEmail})
+	server.requestPasswordReset()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	message, _ := server.Outbox().Last()
+	token := mailedToken(message.Body)
+
+	// Follow the link a few times at once, which only works once
+	var wg sync.WaitGroup
+	statusCodes := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/auth/password-reset/confirm", UpdateUserBody{"token": token, "password": "a-brand-new-password"})
+			server.confirmPasswordReset()(recorder, req)
+			statusCodes <- response.Parse(recorder.Result().Body).StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statusCodes)
+	counts := map[int]int{}
+	for statusCode := range statusCodes {
+		counts[statusCode]++
+	}
+	assert.Equal(t, map[int]int{http.StatusOK: 1, http.StatusBadRequest: 4}, counts)
+}
@@ -2,8 +2,11 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/beanpay/api/database/models"
 	"github.com/beanpay/api/server/jwt"
+	"github.com/beanpay/api/server/recurrence"
+	"github.com/beanpay/api/server/validator"
 	"github.com/generalledger/response"
 	"net/http"
 	"strings"
@@ -11,7 +14,9 @@ import (
 )
 
 func (s *Server) fetchBills() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
 	billRepo := models.BillRepository{DB: s.DB}
+	paymentRepo := models.PaymentRepository{DB: s.DB}
 	return func(w http.ResponseWriter, r *http.Request) {
 		resp := response.New(w)
 		defer resp.Output()
@@ -30,19 +35,305 @@ func (s *Server) fetchBills() http.HandlerFunc {
 			return
 		}
 
+		// Work out the status of each Bill from its occurrences between the
+		// overdue lookback & the end of the due soon window
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		today := localDate(time.Now(), user.Location())
+		fromDate := today.AddDate(0, 0, -overdueLookbackDays)
+		toDate := today.AddDate(0, 0, dueSoonDays)
+		payments, err := paymentRepo.FetchAllUserPayments(claims.UserID, fromDate, toDate)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		occurrences, err := buildAgenda(bills, payments, fromDate, toDate, today)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		body := make([]billResponseBody, 0, len(bills))
+		for _, bill := range bills {
+			body = append(body, billResponseBody{Bill: bill, Status: billStatus(occurrences, bill.Id)})
+		}
+
 		// OK
-		resp.SetResult(http.StatusOK, bills)
+		resp.SetResult(http.StatusOK, body)
+	}
+}
+
+// billOccurrence is a single projected due date of a Bill. Its Status is
+// set by buildAgenda, once the Payments made against it are known.
+type billOccurrence struct {
+	BillId            string       `json:"bill_id"`
+	DueDate           time.Time    `json:"due_date"`
+	EstimatedTotalDue models.Money `json:"estimated_total_due"`
+	Status            string       `json:"status"`
+}
+
+// billResponseBody is a Bill along with its billStatus, which is only set
+// when one of its occurrences needs the user's attention.
+type billResponseBody struct {
+	*models.Bill
+	Status string `json:"status,omitempty"`
+}
+
+// billSchedule returns the recurrence.Schedule that a Bill is due on.
+func billSchedule(bill *models.Bill) recurrence.Schedule {
+	return recurrence.Schedule{
+		FirstDueDate:    bill.FirstDueDate,
+		Interval:        bill.RecurrenceInterval,
+		Unit:            bill.RecurrenceUnit,
+		EndDate:         bill.EndDate,
+		OccurrenceLimit: bill.OccurrenceLimit,
+	}
+}
+
+// applyFrequency sets the Frequency of a Bill along with the recurrence
+// interval & unit it implies. An empty frequency keeps the Bill's current
+// Frequency. The interval & unit may only be set (and are then required)
+// when the Frequency is custom, every other Frequency fixes them.
+func applyFrequency(bill *models.Bill, frequency string, interval int, unit string) error {
+	if frequency == "" {
+		frequency = bill.Frequency
+	}
+	if frequency != recurrence.Custom {
+		if interval != 0 || unit != "" {
+			return errors.New("RecurrenceInterval and RecurrenceUnit can only be set when the Frequency is custom.")
+		}
+		bill.RecurrenceInterval, bill.RecurrenceUnit, _ = recurrence.FrequencyInterval(frequency)
+	} else {
+		if interval != 0 {
+			bill.RecurrenceInterval = interval
+		}
+		if unit != "" {
+			bill.RecurrenceUnit = unit
+		}
+		if bill.RecurrenceInterval == 0 || bill.RecurrenceUnit == "" {
+			return errors.New("RecurrenceInterval and RecurrenceUnit are required when the Frequency is custom.")
+		}
+	}
+	bill.Frequency = frequency
+	return nil
+}
+
+// projectOccurrences returns every occurrence of a Bill between the dates
+// 'from' (inclusive) and 'to' (exclusive).
+func projectOccurrences(bill *models.Bill, from time.Time, to time.Time) ([]billOccurrence, error) {
+	dueDates, err := billSchedule(bill).Between(from, to)
+	if err != nil {
+		return nil, err
+	}
+	occurrences := make([]billOccurrence, 0, len(dueDates))
+	for _, dueDate := range dueDates {
+		occurrences = append(occurrences, billOccurrence{
+			BillId:            bill.Id,
+			DueDate:           dueDate,
+			EstimatedTotalDue: bill.EstimatedTotalDue,
+		})
+	}
+	return occurrences, nil
+}
+
+func (s *Server) fetchBillOccurrences() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	billRepo := models.BillRepository{DB: s.DB}
+	paymentRepo := models.PaymentRepository{DB: s.DB}
+	type RequestParams struct {
+		From string `json:"from" validate:"required,datetime=2006-01-02"`
+		To   string `json:"to" validate:"required,datetime=2006-01-02"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := r.Context().Value("jwtClaims").(jwt.Claims)
+		if !ok {
+			resp.SetResult(http.StatusUnauthorized, nil)
+			return
+		}
+
+		// Fetch the Bill
+		billId := strings.Split(r.URL.Path, "/")[2]
+		bill, err := billRepo.FetchByID(billId)
+		if err != nil {
+			resp.SetResult(http.StatusNotFound, nil)
+			return
+		}
+
+		// Verify the authorized user owns the bill
+		if bill.UserId != claims.UserID {
+			resp.SetResult(http.StatusForbidden, nil)
+			return
+		}
+
+		// Validate the request params
+		requestParams := &RequestParams{}
+		queryParams := r.URL.Query()
+		from, ok := queryParams["from"]
+		if ok && (len(from) > 0) {
+			requestParams.From = from[0]
+		}
+		to, ok := queryParams["to"]
+		if ok && (len(to) > 0) {
+			requestParams.To = to[0]
+		}
+		messages, err := s.Validator.Validate(requestParams)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Parse out the dates
+		fromDate, fromErr := time.Parse("2006-01-02", requestParams.From)
+		toDate, toErr := time.Parse("2006-01-02", requestParams.To)
+		if fromErr != nil || toErr != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = validateWindow(fromDate, toDate)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(err.Error())
+			return
+		}
+
+		// Project the occurrences, along with their status
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		payments, err := paymentRepo.FetchAllUserPayments(claims.UserID, fromDate, toDate)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		today := localDate(time.Now(), user.Location())
+		items, err := buildAgenda([]*models.Bill{bill}, payments, fromDate, toDate, today)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		occurrences := make([]billOccurrence, 0, len(items))
+		for _, item := range items {
+			occurrences = append(occurrences, item.billOccurrence)
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, occurrences)
+	}
+}
+
+// overdueLookbackDays is how far back GET /overdue-bills looks for late
+// occurrences when no 'from' date is specified.
+const overdueLookbackDays = 90
+
+// fetchOverdueBills lists the occurrences that are past their due date and
+// still not fully paid, whether they're in their grace period or overdue.
+func (s *Server) fetchOverdueBills() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	billRepo := models.BillRepository{DB: s.DB}
+	paymentRepo := models.PaymentRepository{DB: s.DB}
+	type RequestParams struct {
+		From string `json:"from" validate:"omitempty,datetime=2006-01-02"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := r.Context().Value("jwtClaims").(jwt.Claims)
+		if !ok {
+			resp.SetResult(http.StatusUnauthorized, nil)
+			return
+		}
+
+		// Validate the request params
+		requestParams := &RequestParams{}
+		from, ok := r.URL.Query()["from"]
+		if ok && (len(from) > 0) {
+			requestParams.From = from[0]
+		}
+		messages, err := s.Validator.Validate(requestParams)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Work out the window in the user's time zone. Nothing due today or
+		// later can be late.
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		today := localDate(time.Now(), user.Location())
+		fromDate := today.AddDate(0, 0, -overdueLookbackDays)
+		if requestParams.From != "" {
+			fromDate, err = time.Parse("2006-01-02", requestParams.From)
+			if err != nil {
+				resp.SetResult(http.StatusInternalServerError, nil)
+				return
+			}
+		}
+		err = validateWindow(fromDate, today)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(err.Error())
+			return
+		}
+
+		// Fetch the Bills & the Payments made within the window
+		bills, err := billRepo.FetchAllUserBills(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		payments, err := paymentRepo.FetchAllUserPayments(claims.UserID, fromDate, today)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// Keep the occurrences that are late
+		occurrences, err := buildAgenda(bills, payments, fromDate, today, today)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		late := make([]agendaItem, 0)
+		for _, occurrence := range occurrences {
+			if occurrence.Status == statusInGrace || occurrence.Status == statusOverdue {
+				late = append(late, occurrence)
+			}
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, late)
 	}
 }
 
 func (s *Server) updateBill() http.HandlerFunc {
 	billRepo := models.BillRepository{DB: s.DB}
 	type RequestBody struct {
-		Name              string  `json:"name" validate:"omitempty"`
-		PaymentURL        string  `json:"payment_url" validate:"omitempty,url"`
-		Frequency         string  `json:"frequency" validate:"omitempty,oneof=monthly quarterly biannually annually"`
-		EstimatedTotalDue float64 `json:"estimated_total_due" validate:"omitempty,gte=0"`
-		FirstDueDate      string  `json:"first_due_date" validate:"omitempty,datetime=2006-01-02"`
+		Name               string                     `json:"name" validate:"omitempty"`
+		PaymentURL         string                     `json:"payment_url" validate:"omitempty,url"`
+		Frequency          string                     `json:"frequency" validate:"omitempty,oneof=weekly biweekly monthly quarterly biannually annually custom"`
+		RecurrenceInterval int                        `json:"recurrence_interval" validate:"omitempty,min=1"`
+		RecurrenceUnit     string                     `json:"recurrence_unit" validate:"omitempty,oneof=day week month year"`
+		EstimatedTotalDue  json.Number                `json:"estimated_total_due" validate:"omitempty,money"`
+		FirstDueDate       string                     `json:"first_due_date" validate:"omitempty,datetime=2006-01-02"`
+		EndDate            validator.Nullable[string] `json:"end_date" validate:"omitempty,datetime=2006-01-02"`
+		OccurrenceLimit    validator.Nullable[int]    `json:"occurrence_limit" validate:"omitempty,min=1"`
+		GracePeriodDays    *int                       `json:"grace_period_days" validate:"omitempty,min=0"`
+		LateFee            json.Number                `json:"late_fee" validate:"omitempty,money"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		resp := response.New(w)
@@ -91,11 +382,21 @@ func (s *Server) updateBill() http.HandlerFunc {
 		if requestBody.PaymentURL != "" {
 			bill.PaymentURL = requestBody.PaymentURL
 		}
-		if requestBody.Frequency != "" {
-			bill.Frequency = requestBody.Frequency
+		err = applyFrequency(bill, requestBody.Frequency, requestBody.RecurrenceInterval, requestBody.RecurrenceUnit)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(err.Error())
+			return
 		}
-		if requestBody.EstimatedTotalDue != 0 {
-			bill.EstimatedTotalDue = requestBody.EstimatedTotalDue
+		if requestBody.EstimatedTotalDue != "" {
+			estimatedTotalDue, err := models.ParseMoney(requestBody.EstimatedTotalDue.String(), bill.EstimatedTotalDue.Currency)
+			if err != nil {
+				// We just validated this would be in the right format, so if this
+				// error happens something is wrong with our Validator internals.
+				resp.SetResult(http.StatusInternalServerError, nil)
+				return
+			}
+			bill.EstimatedTotalDue = estimatedTotalDue
 		}
 		if requestBody.FirstDueDate != "" {
 			firstDueDate, err := time.Parse("2006-01-02", requestBody.FirstDueDate)
@@ -107,6 +408,42 @@ func (s *Server) updateBill() http.HandlerFunc {
 			}
 			bill.FirstDueDate = firstDueDate
 		}
+		// Sending the end date or occurrence limit as null removes it, so
+		// that the Bill recurs indefinitely again
+		if requestBody.EndDate.Set {
+			bill.EndDate = nil
+			if requestBody.EndDate.Value != "" {
+				endDate, err := time.Parse("2006-01-02", requestBody.EndDate.Value)
+				if err != nil {
+					resp.SetResult(http.StatusInternalServerError, nil)
+					return
+				}
+				bill.EndDate = &endDate
+			}
+		}
+		if requestBody.OccurrenceLimit.Set {
+			bill.OccurrenceLimit = nil
+			if !requestBody.OccurrenceLimit.Null {
+				bill.OccurrenceLimit = &requestBody.OccurrenceLimit.Value
+			}
+		}
+		if requestBody.GracePeriodDays != nil {
+			bill.GracePeriodDays = *requestBody.GracePeriodDays
+		}
+		if requestBody.LateFee != "" {
+			lateFee, err := models.ParseMoney(requestBody.LateFee.String(), bill.EstimatedTotalDue.Currency)
+			if err != nil {
+				resp.SetResult(http.StatusInternalServerError, nil)
+				return
+			}
+			bill.LateFee = lateFee
+		}
+		err = billSchedule(bill).Validate()
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(err.Error())
+			return
+		}
 		err = billRepo.Update(bill)
 		if err != nil {
 			resp.SetResult(http.StatusInternalServerError, nil)
@@ -160,11 +497,18 @@ func (s *Server) deleteBill() http.HandlerFunc {
 func (s *Server) createBill() http.HandlerFunc {
 	billRepo := models.BillRepository{DB: s.DB}
 	type RequestBody struct {
-		Name              string  `json:"name" validate:"required"`
-		PaymentURL        string  `json:"payment_url" validate:"required,url"`
-		Frequency         string  `json:"frequency" validate:"required,oneof=monthly quarterly biannually annually"`
-		EstimatedTotalDue float64 `json:"estimated_total_due" validate:"required,gte=0"`
-		FirstDueDate      string  `json:"first_due_date" validate:"required,datetime=2006-01-02"`
+		Name               string      `json:"name" validate:"required"`
+		PaymentURL         string      `json:"payment_url" validate:"required,url"`
+		Frequency          string      `json:"frequency" validate:"required,oneof=weekly biweekly monthly quarterly biannually annually custom"`
+		RecurrenceInterval int         `json:"recurrence_interval" validate:"omitempty,min=1"`
+		RecurrenceUnit     string      `json:"recurrence_unit" validate:"omitempty,oneof=day week month year"`
+		EstimatedTotalDue  json.Number `json:"estimated_total_due" validate:"required,money"`
+		Currency           string      `json:"currency" validate:"omitempty,currency"`
+		FirstDueDate       string      `json:"first_due_date" validate:"required,datetime=2006-01-02"`
+		EndDate            string      `json:"end_date" validate:"omitempty,datetime=2006-01-02"`
+		OccurrenceLimit    int         `json:"occurrence_limit" validate:"omitempty,min=1"`
+		GracePeriodDays    int         `json:"grace_period_days" validate:"min=0"`
+		LateFee            json.Number `json:"late_fee" validate:"omitempty,money"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		resp := response.New(w)
@@ -198,15 +542,55 @@ func (s *Server) createBill() http.HandlerFunc {
 			resp.SetResult(http.StatusInternalServerError, nil)
 			return
 		}
+		if requestBody.Currency == "" {
+			requestBody.Currency = models.DefaultCurrency
+		}
+		estimatedTotalDue, err := models.ParseMoney(requestBody.EstimatedTotalDue.String(), requestBody.Currency)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		lateFee := models.Money{Currency: requestBody.Currency}
+		if requestBody.LateFee != "" {
+			lateFee, err = models.ParseMoney(requestBody.LateFee.String(), requestBody.Currency)
+			if err != nil {
+				resp.SetResult(http.StatusInternalServerError, nil)
+				return
+			}
+		}
 
 		// Create a new Bill Record
 		newBill := &models.Bill{
 			UserId:            claims.UserID,
 			Name:              requestBody.Name,
 			PaymentURL:        requestBody.PaymentURL,
-			Frequency:         requestBody.Frequency,
-			EstimatedTotalDue: requestBody.EstimatedTotalDue,
+			EstimatedTotalDue: estimatedTotalDue,
 			FirstDueDate:      firstDueDate,
+			GracePeriodDays:   requestBody.GracePeriodDays,
+			LateFee:           lateFee,
+		}
+		if requestBody.EndDate != "" {
+			endDate, err := time.Parse("2006-01-02", requestBody.EndDate)
+			if err != nil {
+				resp.SetResult(http.StatusInternalServerError, nil)
+				return
+			}
+			newBill.EndDate = &endDate
+		}
+		if requestBody.OccurrenceLimit != 0 {
+			newBill.OccurrenceLimit = &requestBody.OccurrenceLimit
+		}
+		err = applyFrequency(newBill, requestBody.Frequency, requestBody.RecurrenceInterval, requestBody.RecurrenceUnit)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(err.Error())
+			return
+		}
+		err = billSchedule(newBill).Validate()
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(err.Error())
+			return
 		}
 		err = billRepo.Insert(newBill)
 		if err != nil {
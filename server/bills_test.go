@@ -3,20 +3,29 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"github.com/beanpay/api/database/models"
 	"github.com/generalledger/response"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 type BillRequestBody struct {
-	Name              string  `json:"name,omitempty"`
-	PaymentURL        string  `json:"payment_url,omitempty"`
-	Frequency         string  `json:"frequency,omitempty"`
-	EstimatedTotalDue float64 `json:"estimated_total_due,omitempty"`
-	FirstDueDate      string  `json:"first_due_date,omitempty"`
+	Name               string  `json:"name,omitempty"`
+	PaymentURL         string  `json:"payment_url,omitempty"`
+	Frequency          string  `json:"frequency,omitempty"`
+	RecurrenceInterval int     `json:"recurrence_interval,omitempty"`
+	RecurrenceUnit     string  `json:"recurrence_unit,omitempty"`
+	EstimatedTotalDue  float64 `json:"estimated_total_due,omitempty"`
+	Currency           string  `json:"currency,omitempty"`
+	FirstDueDate       string  `json:"first_due_date,omitempty"`
+	EndDate            string  `json:"end_date,omitempty"`
+	OccurrenceLimit    int     `json:"occurrence_limit,omitempty"`
+	GracePeriodDays    *int    `json:"grace_period_days,omitempty"`
+	LateFee            float64 `json:"late_fee,omitempty"`
 }
 
 func (r *BillRequestBody) Read(p []byte) (n int, err error) {
@@ -97,6 +106,67 @@ func TestBillFetch(t *testing.T) {
 	)
 }
 
+func TestBillFetchStatus(t *testing.T) {
+	// Prepare the Server & seed some data
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	user := server.SeedUser()
+	upcoming := server.SeedBill(user["id"].(string))
+
+	// Seed bills that are overdue, in their grace period, due soon & paid
+	billRepo := models.BillRepository{DB: server.DB}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	seed := func(name string, firstDueDate time.Time) *models.Bill {
+		bill := &models.Bill{
+			UserId:             user["id"].(string),
+			Name:               name,
+			PaymentURL:         "https://example.com",
+			Frequency:          "annually",
+			EstimatedTotalDue:  models.Money{Amount: 10000, Currency: "USD"},
+			FirstDueDate:       firstDueDate,
+			RecurrenceInterval: 1,
+			RecurrenceUnit:     "year",
+			GracePeriodDays:    5,
+		}
+		err := billRepo.Insert(bill)
+		assert.Nil(t, err)
+		return bill
+	}
+	overdue := seed("Insurance", today.AddDate(0, 0, -20))
+	inGrace := seed("Gym", today.AddDate(0, 0, -1))
+	dueSoon := seed("Phone", today.AddDate(0, 0, 1))
+	paid := seed("Water", today.AddDate(0, 0, -10))
+	paymentRepo := models.PaymentRepository{DB: server.DB}
+	err = paymentRepo.Insert(&models.Payment{
+		BillId:    paid.Id,
+		DueDate:   paid.FirstDueDate,
+		TotalPaid: paid.EstimatedTotalDue,
+	})
+	assert.Nil(t, err)
+
+	// Ensure each bill has the status of its most pressing occurrence
+	recorder := httptest.NewRecorder()
+	req := server.NewAuthenticatedRequest(http.MethodGet, "/bills", user["id"].(string), nil)
+	server.fetchBills()(recorder, req)
+	resp := response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	statuses := map[string]interface{}{}
+	for _, bill := range resp.Result.([]interface{}) {
+		statuses[bill.(map[string]interface{})["id"].(string)] = bill.(map[string]interface{})["status"]
+	}
+	assert.Equal(t,
+		map[string]interface{}{
+			overdue.Id:              statusOverdue,
+			inGrace.Id:              statusInGrace,
+			dueSoon.Id:              statusDueSoon,
+			paid.Id:                 nil,
+			upcoming["id"].(string): nil,
+		},
+		statuses,
+	)
+}
+
 func TestBillUpdate(t *testing.T) {
 	// Prepare the Server & seed some data
 	server, err := NewTestServer()
@@ -179,6 +249,52 @@ func TestBillUpdate(t *testing.T) {
 		response.Parse(recorder.Result().Body),
 	)
 
+	// Test that a bill can be given an end date & occurrence limit
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/bills/"+bill1["id"].(string), user1["id"].(string), UpdateUserBody{
+		"end_date":         "2099-01-01",
+		"occurrence_limit": 12,
+	})
+	server.updateBill()(recorder, req)
+	result := response.Parse(recorder.Result().Body).Result.(map[string]interface{})
+	assert.Equal(t, "2099-01-01T00:00:00Z", result["end_date"])
+	assert.Equal(t, float64(12), result["occurrence_limit"])
+
+	// Which are kept when they're left out
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/bills/"+bill1["id"].(string), user1["id"].(string), UpdateUserBody{
+		"name": "Bounded Bill",
+	})
+	server.updateBill()(recorder, req)
+	result = response.Parse(recorder.Result().Body).Result.(map[string]interface{})
+	assert.Equal(t, "2099-01-01T00:00:00Z", result["end_date"])
+	assert.Equal(t, float64(12), result["occurrence_limit"])
+
+	// And removed when they're sent as null, so the bill recurs indefinitely
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/bills/"+bill1["id"].(string), user1["id"].(string), UpdateUserBody{
+		"end_date":         nil,
+		"occurrence_limit": nil,
+	})
+	server.updateBill()(recorder, req)
+	parsed := response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, parsed.StatusCode)
+	result = parsed.Result.(map[string]interface{})
+	assert.Nil(t, result["end_date"])
+	assert.Nil(t, result["occurrence_limit"])
+	assert.Equal(t, "Bounded Bill", result["name"])
+
+	// Test that the occurrence limit must still be at least 1
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/bills/"+bill1["id"].(string), user1["id"].(string), UpdateUserBody{
+		"occurrence_limit": 0,
+	})
+	server.updateBill()(recorder, req)
+	assert.Equal(t,
+		&[]string{"The occurrence limit must be at least 1."},
+		response.Parse(recorder.Result().Body).ErrorDetails,
+	)
+
 	// Test that we can successfully update a bill
 	recorder = httptest.NewRecorder()
 	req = server.NewAuthenticatedRequest(http.MethodPut, "/bills/"+bill1["id"].(string), user1["id"].(string), &BillRequestBody{
@@ -329,6 +445,92 @@ func TestBillCreate(t *testing.T) {
 		response.Parse(recorder.Result().Body),
 	)
 
+	// Test that custom frequencies require an interval & unit
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/bills", user1["id"].(string), &BillRequestBody{
+		Name:              "some-name",
+		PaymentURL:        "https://example.com",
+		Frequency:         "custom",
+		EstimatedTotalDue: 19.99,
+		FirstDueDate:      "2020-01-01",
+	})
+	server.createBill()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusBadRequest,
+			StatusText:   http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{"RecurrenceInterval and RecurrenceUnit are required when the Frequency is custom."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Test that non-custom frequencies cannot override their interval
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/bills", user1["id"].(string), &BillRequestBody{
+		Name:               "some-name",
+		PaymentURL:         "https://example.com",
+		Frequency:          "weekly",
+		RecurrenceInterval: 3,
+		EstimatedTotalDue:  19.99,
+		FirstDueDate:       "2020-01-01",
+	})
+	server.createBill()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusBadRequest,
+			StatusText:   http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{"RecurrenceInterval and RecurrenceUnit can only be set when the Frequency is custom."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Test that the end date cannot come before the first due date
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/bills", user1["id"].(string), &BillRequestBody{
+		Name:              "some-name",
+		PaymentURL:        "https://example.com",
+		Frequency:         "monthly",
+		EstimatedTotalDue: 19.99,
+		FirstDueDate:      "2020-01-01",
+		EndDate:           "2019-01-01",
+	})
+	server.createBill()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusBadRequest,
+			StatusText:   http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{"The end date cannot be before the first due date."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Test that we are validating amounts & currencies
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/bills", user1["id"].(string), &BillRequestBody{
+		Name:              "some-name",
+		PaymentURL:        "https://example.com",
+		Frequency:         "monthly",
+		EstimatedTotalDue: 19.999,
+		Currency:          "ABC",
+		FirstDueDate:      "2020-01-01",
+	})
+	server.createBill()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode: http.StatusBadRequest,
+			StatusText: http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{
+				"EstimatedTotalDue must be an amount with at most two decimal places",
+				"Currency must be a valid ISO-4217 currency code",
+			},
+			Result: nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
 	// Test that we can create a new bill
 	recorder = httptest.NewRecorder()
 	req = server.NewAuthenticatedRequest(http.MethodPost, "/bills", user1["id"].(string), &BillRequestBody{
@@ -341,4 +543,341 @@ func TestBillCreate(t *testing.T) {
 	server.createBill()(recorder, req)
 	result := response.Parse(recorder.Result().Body)
 	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t,
+		map[string]interface{}{"amount": float64(1999), "currency": "USD"},
+		result.Result.(map[string]interface{})["estimated_total_due"],
+	)
+
+	// Test that large amounts in other currencies are supported
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/bills", user1["id"].(string), &BillRequestBody{
+		Name:              "Tuition",
+		PaymentURL:        "https://example.com",
+		Frequency:         "annually",
+		EstimatedTotalDue: 2500000.5,
+		Currency:          "EUR",
+		FirstDueDate:      "2020-09-01",
+	})
+	server.createBill()(recorder, req)
+	result = response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t,
+		map[string]interface{}{"amount": float64(250000050), "currency": "EUR"},
+		result.Result.(map[string]interface{})["estimated_total_due"],
+	)
+
+	// Test that preset frequencies fill in their interval & unit
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/bills", user1["id"].(string), &BillRequestBody{
+		Name:              "Childcare",
+		PaymentURL:        "https://example.com",
+		Frequency:         "biweekly",
+		EstimatedTotalDue: 250,
+		FirstDueDate:      "2020-01-03",
+	})
+	server.createBill()(recorder, req)
+	result = response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, float64(2), result.Result.(map[string]interface{})["recurrence_interval"])
+	assert.Equal(t, "week", result.Result.(map[string]interface{})["recurrence_unit"])
+
+	// Test that we can create a bounded custom bill
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/bills", user1["id"].(string), &BillRequestBody{
+		Name:               "Water",
+		PaymentURL:         "https://example.com",
+		Frequency:          "custom",
+		RecurrenceInterval: 2,
+		RecurrenceUnit:     "month",
+		EstimatedTotalDue:  60,
+		FirstDueDate:       "2020-01-15",
+		OccurrenceLimit:    6,
+	})
+	server.createBill()(recorder, req)
+	result = response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "custom", result.Result.(map[string]interface{})["frequency"])
+	assert.Equal(t, float64(2), result.Result.(map[string]interface{})["recurrence_interval"])
+	assert.Equal(t, float64(6), result.Result.(map[string]interface{})["occurrence_limit"])
+
+	// Test that we can create a bill with a grace period & late fee
+	gracePeriodDays := 5
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/bills", user1["id"].(string), &BillRequestBody{
+		Name:              "Credit Card",
+		PaymentURL:        "https://example.com",
+		Frequency:         "monthly",
+		EstimatedTotalDue: 300,
+		FirstDueDate:      "2020-01-20",
+		GracePeriodDays:   &gracePeriodDays,
+		LateFee:           29.5,
+	})
+	server.createBill()(recorder, req)
+	result = response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, float64(5), result.Result.(map[string]interface{})["grace_period_days"])
+	assert.Equal(t,
+		map[string]interface{}{"amount": float64(2950), "currency": "USD"},
+		result.Result.(map[string]interface{})["late_fee"],
+	)
+
+	// Test that negative grace periods are rejected
+	gracePeriodDays = -1
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/bills", user1["id"].(string), &BillRequestBody{
+		Name:              "Credit Card",
+		PaymentURL:        "https://example.com",
+		Frequency:         "monthly",
+		EstimatedTotalDue: 300,
+		FirstDueDate:      "2020-01-20",
+		GracePeriodDays:   &gracePeriodDays,
+	})
+	server.createBill()(recorder, req)
+	assert.Equal(t,
+		&[]string{"GracePeriodDays must be 0 or greater"},
+		response.Parse(recorder.Result().Body).ErrorDetails,
+	)
+}
+
+func TestBillOverdue(t *testing.T) {
+	// Prepare the Server & seed some data
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	user1 := server.SeedUser()
+	user2 := server.SeedUser()
+	server.SeedBill(user1["id"].(string))
+
+	// Seed bills that fell due yesterday & 20 days ago
+	billRepo := models.BillRepository{DB: server.DB}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	insurance := &models.Bill{
+		UserId:             user1["id"].(string),
+		Name:               "Insurance",
+		PaymentURL:         "https://example.com",
+		Frequency:          "annually",
+		EstimatedTotalDue:  models.Money{Amount: 50000, Currency: "USD"},
+		FirstDueDate:       today.AddDate(0, 0, -20),
+		RecurrenceInterval: 1,
+		RecurrenceUnit:     "year",
+		GracePeriodDays:    5,
+		LateFee:            models.Money{Amount: 2500, Currency: "USD"},
+	}
+	err = billRepo.Insert(insurance)
+	assert.Nil(t, err)
+	gym := &models.Bill{
+		UserId:             user1["id"].(string),
+		Name:               "Gym",
+		PaymentURL:         "https://example.com",
+		Frequency:          "annually",
+		EstimatedTotalDue:  models.Money{Amount: 30000, Currency: "USD"},
+		FirstDueDate:       today.AddDate(0, 0, -1),
+		RecurrenceInterval: 1,
+		RecurrenceUnit:     "year",
+		GracePeriodDays:    5,
+	}
+	err = billRepo.Insert(gym)
+	assert.Nil(t, err)
+
+	// Validate auth is required
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/overdue-bills", nil)
+	server.fetchOverdueBills()(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Ensure that we are validating our requests
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/overdue-bills?from=invalid", user1["id"].(string), nil)
+	server.fetchOverdueBills()(recorder, req)
+	assert.Equal(t,
+		&[]string{"From does not match the 2006-01-02 format"},
+		response.Parse(recorder.Result().Body).ErrorDetails,
+	)
+
+	// Ensure that the window can't start in the future, or too far back
+	for from, message := range map[string]string{
+		today.AddDate(0, 0, 1).Format("2006-01-02"):    "To must not be before From.",
+		today.AddDate(0, 0, -367).Format("2006-01-02"): "The window must not span more than 366 days.",
+	} {
+		recorder = httptest.NewRecorder()
+		req = server.NewAuthenticatedRequest(http.MethodGet, "/overdue-bills?from="+from, user1["id"].(string), nil)
+		server.fetchOverdueBills()(recorder, req)
+		resp := response.Parse(recorder.Result().Body)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, from)
+		assert.Equal(t, &[]string{message}, resp.ErrorDetails, from)
+	}
+
+	// Ensure that user1 sees their late bills, most overdue first
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/overdue-bills", user1["id"].(string), nil)
+	server.fetchOverdueBills()(recorder, req)
+	resp := response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	occurrences := resp.Result.([]interface{})
+	assert.Equal(t, 2, len(occurrences))
+	assert.Equal(t, insurance.Id, occurrences[0].(map[string]interface{})["bill_id"])
+	assert.Equal(t, statusOverdue, occurrences[0].(map[string]interface{})["status"])
+	assert.Equal(t,
+		map[string]interface{}{"amount": float64(2500), "currency": "USD"},
+		occurrences[0].(map[string]interface{})["late_fee"],
+	)
+	assert.Equal(t, gym.Id, occurrences[1].(map[string]interface{})["bill_id"])
+	assert.Equal(t, statusInGrace, occurrences[1].(map[string]interface{})["status"])
+
+	// Ensure paying a bill takes it off the list
+	paymentRepo := models.PaymentRepository{DB: server.DB}
+	err = paymentRepo.Insert(&models.Payment{
+		BillId:    gym.Id,
+		DueDate:   gym.FirstDueDate,
+		TotalPaid: gym.EstimatedTotalDue,
+	})
+	assert.Nil(t, err)
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/overdue-bills", user1["id"].(string), nil)
+	server.fetchOverdueBills()(recorder, req)
+	assert.Equal(t, 1, len(response.Parse(recorder.Result().Body).Result.([]interface{})))
+
+	// Ensure that user2 has nothing overdue
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/overdue-bills", user2["id"].(string), nil)
+	server.fetchOverdueBills()(recorder, req)
+	assert.Equal(t, []interface{}{}, response.Parse(recorder.Result().Body).Result)
+}
+
+func TestBillOccurrences(t *testing.T) {
+	// Prepare the Server & seed some data
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	user1 := server.SeedUser()
+	user2 := server.SeedUser()
+	user2Bill := server.SeedBill(user2["id"].(string))
+
+	// Seed a bill with a known first due date that falls on a month end
+	billRepo := models.BillRepository{DB: server.DB}
+	firstDueDate, _ := time.Parse("2006-01-02", "2020-01-31")
+	bill := &models.Bill{
+		UserId:             user1["id"].(string),
+		Name:               "Rent",
+		PaymentURL:         "https://example.com",
+		Frequency:          "monthly",
+		EstimatedTotalDue:  models.Money{Amount: 120000, Currency: "USD"},
+		FirstDueDate:       firstDueDate,
+		RecurrenceInterval: 1,
+		RecurrenceUnit:     "month",
+	}
+	err = billRepo.Insert(bill)
+	assert.Nil(t, err)
+
+	// Validate auth is required
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/bills/"+bill.Id+"/occurrences", nil)
+	server.fetchBillOccurrences()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusUnauthorized,
+			StatusText:   http.StatusText(http.StatusUnauthorized),
+			ErrorDetails: nil,
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Validate that we cannot project a bill that does not exist
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/bills/fake-bill-id/occurrences?from=2020-01-01&to=2020-06-01", user1["id"].(string), nil)
+	server.fetchBillOccurrences()(recorder, req)
+	assert.Equal(t, http.StatusNotFound, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Validate that we cannot project a bill that does not belong to us
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/bills/"+user2Bill["id"].(string)+"/occurrences?from=2020-01-01&to=2020-06-01", user1["id"].(string), nil)
+	server.fetchBillOccurrences()(recorder, req)
+	assert.Equal(t, http.StatusForbidden, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Ensure that we are validating our requests
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/bills/"+bill.Id+"/occurrences?from=invalid", user1["id"].(string), nil)
+	server.fetchBillOccurrences()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode: http.StatusBadRequest,
+			StatusText: http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{
+				"From does not match the 2006-01-02 format",
+				"To is a required field",
+			},
+			Result: nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Ensure that backwards & overly long windows are rejected
+	for query, message := range map[string]string{
+		"from=2020-06-01&to=2020-05-01": "To must not be before From.",
+		"from=2020-01-01&to=2021-01-03": "The window must not span more than 366 days.",
+	} {
+		recorder = httptest.NewRecorder()
+		req = server.NewAuthenticatedRequest(http.MethodGet, "/bills/"+bill.Id+"/occurrences?"+query, user1["id"].(string), nil)
+		server.fetchBillOccurrences()(recorder, req)
+		resp := response.Parse(recorder.Result().Body)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		assert.Equal(t, &[]string{message}, resp.ErrorDetails, query)
+	}
+
+	// Ensure occurrences are projected with month-end clamping
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/bills/"+bill.Id+"/occurrences?from=2020-02-01&to=2020-05-01", user1["id"].(string), nil)
+	server.fetchBillOccurrences()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusOK,
+			StatusText:   http.StatusText(http.StatusOK),
+			ErrorDetails: nil,
+			Result: []interface{}{
+				map[string]interface{}{"bill_id": bill.Id, "due_date": "2020-02-29T00:00:00Z", "estimated_total_due": map[string]interface{}{"amount": float64(120000), "currency": "USD"}, "status": "overdue"},
+				map[string]interface{}{"bill_id": bill.Id, "due_date": "2020-03-31T00:00:00Z", "estimated_total_due": map[string]interface{}{"amount": float64(120000), "currency": "USD"}, "status": "overdue"},
+				map[string]interface{}{"bill_id": bill.Id, "due_date": "2020-04-30T00:00:00Z", "estimated_total_due": map[string]interface{}{"amount": float64(120000), "currency": "USD"}, "status": "overdue"},
+			},
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Seed a daily bill around today, with one of its occurrences paid
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	daily := &models.Bill{
+		UserId:             user1["id"].(string),
+		Name:               "Parking",
+		PaymentURL:         "https://example.com",
+		Frequency:          "custom",
+		EstimatedTotalDue:  models.Money{Amount: 500, Currency: "USD"},
+		FirstDueDate:       today.AddDate(0, 0, -10),
+		RecurrenceInterval: 1,
+		RecurrenceUnit:     "day",
+		GracePeriodDays:    2,
+	}
+	err = billRepo.Insert(daily)
+	assert.Nil(t, err)
+	paymentRepo := models.PaymentRepository{DB: server.DB}
+	err = paymentRepo.Insert(&models.Payment{
+		BillId:    daily.Id,
+		DueDate:   today.AddDate(0, 0, -4),
+		TotalPaid: daily.EstimatedTotalDue,
+	})
+	assert.Nil(t, err)
+
+	// Ensure each occurrence has its status
+	from := today.AddDate(0, 0, -4).Format("2006-01-02")
+	to := today.AddDate(0, 0, 4).Format("2006-01-02")
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/bills/"+daily.Id+"/occurrences?from="+from+"&to="+to, user1["id"].(string), nil)
+	server.fetchBillOccurrences()(recorder, req)
+	var statuses []interface{}
+	for _, occurrence := range response.Parse(recorder.Result().Body).Result.([]interface{}) {
+		statuses = append(statuses, occurrence.(map[string]interface{})["status"])
+	}
+	assert.Equal(t,
+		[]interface{}{statusPaid, statusOverdue, statusInGrace, statusInGrace, statusDueSoon, statusDueSoon, statusDueSoon, statusUnpaid},
+		statuses,
+	)
 }
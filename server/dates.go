@@ -0,0 +1,33 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxWindowDays is the longest span of dates that can be projected in a
+// single request, since every Bill is projected across the whole span.
+const maxWindowDays = 366
+
+// localDate returns the calendar date that an instant falls on in a
+// location. Dates are represented as midnight UTC throughout the API (that's
+// how they're parsed from requests & scanned from the database), so the
+// result can be compared directly against due dates.
+func localDate(instant time.Time, location *time.Location) time.Time {
+	local := instant.In(location)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// validateWindow ensures the dates 'from' & 'to' make a window that can be
+// projected: 'to' can't come before 'from', & the window can't span more
+// than maxWindowDays.
+func validateWindow(from time.Time, to time.Time) error {
+	if to.Before(from) {
+		return errors.New("To must not be before From.")
+	}
+	if to.Sub(from) > maxWindowDays*24*time.Hour {
+		return fmt.Errorf("The window must not span more than %d days.", maxWindowDays)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"github.com/beanpay/api/database/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
+}
+
+func mustParseInstant(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestLocalDate(t *testing.T) {
+	losAngeles := mustLoadLocation("America/Los_Angeles")
+	london := mustLoadLocation("Europe/London")
+	auckland := mustLoadLocation("Pacific/Auckland")
+
+	for _, test := range []struct {
+		instant  string
+		location *time.Location
+		expected string
+	}{
+		// UTC-8 is still on the previous day for the first 8 hours of a UTC day
+		{"2020-01-15T07:59:59Z", losAngeles, "2020-01-14"},
+		{"2020-01-15T08:00:00Z", losAngeles, "2020-01-15"},
+
+		// Around the spring forward on 2020-03-08, the offset drops to UTC-7
+		{"2020-03-08T07:59:59Z", losAngeles, "2020-03-07"},
+		{"2020-03-08T10:30:00Z", losAngeles, "2020-03-08"},
+		{"2020-03-09T06:59:59Z", losAngeles, "2020-03-08"},
+		{"2020-03-09T07:00:00Z", losAngeles, "2020-03-09"},
+
+		// Around the fall back on 2020-11-01, the offset returns to UTC-8
+		{"2020-11-01T06:59:59Z", losAngeles, "2020-10-31"},
+		{"2020-11-01T09:30:00Z", losAngeles, "2020-11-01"},
+		{"2020-11-02T07:59:59Z", losAngeles, "2020-11-01"},
+		{"2020-11-02T08:00:00Z", losAngeles, "2020-11-02"},
+
+		// During British Summer Time, the day starts an hour before UTC's
+		{"2020-03-28T23:30:00Z", london, "2020-03-28"},
+		{"2020-03-29T23:30:00Z", london, "2020-03-30"},
+
+		// Southern hemisphere DST runs the other way around the year
+		{"2020-01-15T10:59:59Z", auckland, "2020-01-15"},
+		{"2020-01-15T11:00:00Z", auckland, "2020-01-16"},
+		{"2020-07-15T11:59:59Z", auckland, "2020-07-15"},
+		{"2020-07-15T12:00:00Z", auckland, "2020-07-16"},
+	} {
+		date := localDate(mustParseInstant(test.instant), test.location)
+		assert.Equal(t, mustParseDate(test.expected), date, test.instant+" in "+test.location.String())
+	}
+}
+
+func TestAgendaStatusAcrossTimeZones(t *testing.T) {
+	// A bill due on the morning after the clocks spring forward in the US
+	bill := &models.Bill{
+		Id:                 "rent",
+		Name:               "Rent",
+		Frequency:          "monthly",
+		EstimatedTotalDue:  models.Money{Amount: 100000, Currency: "USD"},
+		FirstDueDate:       mustParseDate("2020-03-08"),
+		RecurrenceInterval: 1,
+		RecurrenceUnit:     "month",
+	}
+	status := func(instant string, timeZone string) string {
+		user := &models.User{TimeZone: timeZone}
+		today := localDate(mustParseInstant(instant), user.Location())
+		items, err := buildAgenda([]*models.Bill{bill}, nil, mustParseDate("2020-03-01"), mustParseDate("2020-04-01"), today)
+		assert.Nil(t, err)
+		return items[0].Status
+	}
+
+	// At 03:00 UTC on the 9th, it's still the due date in Los Angeles, but
+	// the bill is already overdue in UTC & London
+	assert.Equal(t, statusDueSoon, status("2020-03-09T03:00:00Z", "America/Los_Angeles"))
+	assert.Equal(t, statusOverdue, status("2020-03-09T03:00:00Z", "UTC"))
+	assert.Equal(t, statusOverdue, status("2020-03-09T03:00:00Z", "Europe/London"))
+
+	// After the DST change, Los Angeles is 7 hours behind rather than 8
+	assert.Equal(t, statusDueSoon, status("2020-03-09T06:59:59Z", "America/Los_Angeles"))
+	assert.Equal(t, statusOverdue, status("2020-03-09T07:00:00Z", "America/Los_Angeles"))
+
+	// Users without a valid time zone fall back to UTC
+	assert.Equal(t, statusOverdue, status("2020-03-09T03:00:00Z", ""))
+}
+
+func TestValidateWindow(t *testing.T) {
+	// Windows of up to a leap year are fine, including empty ones
+	assert.Nil(t, validateWindow(mustParseDate("2020-01-01"), mustParseDate("2020-01-01")))
+	assert.Nil(t, validateWindow(mustParseDate("2020-01-01"), mustParseDate("2021-01-01")))
+
+	// Backwards & longer windows are not
+	assert.Equal(t, "To must not be before From.", validateWindow(mustParseDate("2020-01-02"), mustParseDate("2020-01-01")).Error())
+	assert.Equal(t, "The window must not span more than 366 days.", validateWindow(mustParseDate("2020-01-01"), mustParseDate("2021-01-02")).Error())
+	assert.NotNil(t, validateWindow(mustParseDate("0001-01-01"), mustParseDate("9999-12-31")))
+}
@@ -0,0 +1,152 @@
+package exchange
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/beanpay/api/database/models"
+	"math/big"
+	"time"
+)
+
+// pivotCurrencies are the currencies we triangulate through when there's
+// no rate stored between two currencies. ECB files only contain EUR based
+// rates, so EUR is tried first.
+var pivotCurrencies = []string{"EUR", "USD"}
+
+// ErrNoRate is returned when there's no usable rate between two currencies.
+var ErrNoRate = errors.New("No exchange rate")
+
+// RateFetcher looks up the most recent rate from base to quote that was
+// in effect on a date. models.ExchangeRateRepository is a RateFetcher.
+type RateFetcher interface {
+	FetchRate(base string, quote string, on time.Time) (*models.ExchangeRate, error)
+}
+
+// Converter converts Money between currencies using locally stored rates.
+// Rates are cached for the lifetime of the Converter, so a Converter
+// should be created per request rather than shared.
+type Converter struct {
+	Rates RateFetcher
+
+	// MaxAge is how long before the date of a conversion a rate may have
+	// come into effect, so that a stale rate isn't used when the imports
+	// stop. Rates of any age are used when it's zero.
+	MaxAge time.Duration
+
+	cache map[string]*big.Rat
+}
+
+// NewConverter returns a Converter backed by the RateFetcher, which only
+// uses rates up to maxAge old.
+func NewConverter(rates RateFetcher, maxAge time.Duration) *Converter {
+	return &Converter{
+		Rates:  rates,
+		MaxAge: maxAge,
+		cache:  map[string]*big.Rat{},
+	}
+}
+
+// Convert converts Money into the target currency, using the rates that
+// were in effect on the specified date. The result is rounded half away
+// from zero to the nearest minor unit.
+func (c *Converter) Convert(money models.Money, currency string, on time.Time) (models.Money, error) {
+	if money.Currency == currency {
+		return money, nil
+	}
+	rate, err := c.Rate(money.Currency, currency, on)
+	if err != nil {
+		return models.Money{}, err
+	}
+	converted := new(big.Rat).Mul(new(big.Rat).SetInt64(money.Amount), rate)
+	return models.Money{Amount: roundHalfAwayFromZero(converted), Currency: currency}, nil
+}
+
+// Rate returns the rate from base to quote in effect on the specified date.
+// Rates are looked up directly, then inverted, then triangulated through
+// one of our pivotCurrencies. ErrNoRate is returned when there's none,
+// and any other error means the rates couldn't be looked up.
+func (c *Converter) Rate(base string, quote string, on time.Time) (*big.Rat, error) {
+	if base == quote {
+		return big.NewRat(1, 1), nil
+	}
+	rate, err := c.directRate(base, quote, on)
+	if err != nil || rate != nil {
+		return rate, err
+	}
+	for _, pivot := range pivotCurrencies {
+		if pivot == base || pivot == quote {
+			continue
+		}
+		toPivot, err := c.directRate(base, pivot, on)
+		if err != nil {
+			return nil, err
+		}
+		if toPivot == nil {
+			continue
+		}
+		fromPivot, err := c.directRate(pivot, quote, on)
+		if err != nil {
+			return nil, err
+		}
+		if fromPivot == nil {
+			continue
+		}
+		return new(big.Rat).Mul(toPivot, fromPivot), nil
+	}
+	return nil, fmt.Errorf("%w from %v to %v on %v", ErrNoRate, base, quote, on.Format("2006-01-02"))
+}
+
+// directRate looks up a stored rate from base to quote, or the inverse of
+// a stored rate from quote to base. The rate is nil when neither is stored,
+// or they're older than MaxAge.
+func (c *Converter) directRate(base string, quote string, on time.Time) (*big.Rat, error) {
+	key := base + quote + on.Format("2006-01-02")
+	if rate, ok := c.cache[key]; ok {
+		return rate, nil
+	}
+	result, err := c.fetchRate(base, quote, on)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		inverse, err := c.fetchRate(quote, base, on)
+		if err != nil {
+			return nil, err
+		}
+		if inverse != nil {
+			result = new(big.Rat).Inv(inverse)
+		}
+	}
+	c.cache[key] = result
+	return result, nil
+}
+
+// fetchRate fetches the stored rate from base to quote, which is nil when
+// there isn't one, or it's older than MaxAge.
+func (c *Converter) fetchRate(base string, quote string, on time.Time) (*big.Rat, error) {
+	rate, err := c.Rates.FetchRate(base, quote, on)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxAge > 0 && rate.EffectiveDate.Before(on.Add(-c.MaxAge)) {
+		return nil, nil
+	}
+	return rate.Rate, nil
+}
+
+// roundHalfAwayFromZero rounds a rational number to the nearest integer.
+func roundHalfAwayFromZero(r *big.Rat) int64 {
+	num := new(big.Int).Abs(r.Num())
+	quotient, remainder := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if new(big.Int).Mul(remainder, big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		quotient.Neg(quotient)
+	}
+	return quotient.Int64()
+}
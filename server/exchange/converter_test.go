@@ -0,0 +1,151 @@
+package exchange
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/beanpay/api/database/models"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// fakeRates is an in-memory RateFetcher that counts how often it's called.
+type fakeRates struct {
+	rates   []*models.ExchangeRate
+	fetches int
+}
+
+func (f *fakeRates) FetchRate(base string, quote string, on time.Time) (*models.ExchangeRate, error) {
+	f.fetches++
+	var result *models.ExchangeRate
+	for _, rate := range f.rates {
+		if rate.Base == base && rate.Quote == quote && !rate.EffectiveDate.After(on) {
+			if result == nil || rate.EffectiveDate.After(result.EffectiveDate) {
+				result = rate
+			}
+		}
+	}
+	if result == nil {
+		return nil, sql.ErrNoRows
+	}
+	return result, nil
+}
+
+func rate(date string, base string, quote string, value string) *models.ExchangeRate {
+	r, err := newExchangeRate(date, base, quote, value)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func day(value string) time.Time {
+	t, _ := time.Parse("2006-01-02", value)
+	return t
+}
+
+func TestConverter(t *testing.T) {
+	rates := &fakeRates{rates: []*models.ExchangeRate{
+		rate("2020-05-11", "EUR", "USD", "1.08"),
+		rate("2020-05-12", "EUR", "USD", "1.0827"),
+		rate("2020-05-12", "EUR", "GBP", "0.8785"),
+	}}
+	converter := NewConverter(rates, 0)
+
+	// Same currency is a no-op
+	money, err := converter.Convert(models.Money{Amount: 1999, Currency: "USD"}, "USD", day("2020-05-12"))
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money{Amount: 1999, Currency: "USD"}, money)
+
+	// Direct rates, using the rate in effect on the day
+	money, err = converter.Convert(models.Money{Amount: 10000, Currency: "EUR"}, "USD", day("2020-05-11"))
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money{Amount: 10800, Currency: "USD"}, money)
+	money, err = converter.Convert(models.Money{Amount: 10000, Currency: "EUR"}, "USD", day("2020-06-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money{Amount: 10827, Currency: "USD"}, money)
+
+	// Inverse rates: 100.00 USD / 1.0827 = 92.3616...
+	money, err = converter.Convert(models.Money{Amount: 10000, Currency: "USD"}, "EUR", day("2020-05-12"))
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money{Amount: 9236, Currency: "EUR"}, money)
+
+	// Triangulated through EUR: 100.00 USD / 1.0827 * 0.8785 = 81.1397...
+	money, err = converter.Convert(models.Money{Amount: 10000, Currency: "USD"}, "GBP", day("2020-05-12"))
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money{Amount: 8114, Currency: "GBP"}, money)
+
+	// Missing rates are reported
+	_, err = converter.Convert(models.Money{Amount: 10000, Currency: "USD"}, "JPY", day("2020-05-12"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "No exchange rate from USD to JPY on 2020-05-12", err.Error())
+	_, err = converter.Convert(models.Money{Amount: 10000, Currency: "EUR"}, "USD", day("2020-01-01"))
+	assert.NotNil(t, err)
+
+	// Rates are cached per day
+	fetches := rates.fetches
+	_, err = converter.Convert(models.Money{Amount: 1, Currency: "EUR"}, "USD", day("2020-05-11"))
+	assert.Nil(t, err)
+	assert.Equal(t, fetches, rates.fetches)
+}
+
+// brokenRates is a RateFetcher whose database is unreachable.
+type brokenRates struct{}
+
+func (brokenRates) FetchRate(base string, quote string, on time.Time) (*models.ExchangeRate, error) {
+	return nil, errors.New("dial tcp: connection refused")
+}
+
+func TestConverterErrors(t *testing.T) {
+	// Failures to look up rates aren't mistaken for a missing rate
+	_, err := NewConverter(brokenRates{}, 0).Convert(models.Money{Amount: 10000, Currency: "EUR"}, "USD", day("2020-05-12"))
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrNoRate))
+	assert.Equal(t, "dial tcp: connection refused", err.Error())
+
+	// Whereas missing rates are
+	_, err = NewConverter(&fakeRates{}, 0).Convert(models.Money{Amount: 10000, Currency: "EUR"}, "USD", day("2020-05-12"))
+	assert.True(t, errors.Is(err, ErrNoRate))
+}
+
+func TestConverterMaxAge(t *testing.T) {
+	rates := &fakeRates{rates: []*models.ExchangeRate{
+		rate("2020-05-01", "EUR", "USD", "1.08"),
+		rate("2020-05-01", "EUR", "GBP", "0.87"),
+		rate("2020-05-12", "EUR", "GBP", "0.8785"),
+	}}
+	converter := NewConverter(rates, 7*24*time.Hour)
+
+	// Rates up to the max age are used, including inverted
+	money, err := converter.Convert(models.Money{Amount: 10000, Currency: "EUR"}, "USD", day("2020-05-08"))
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money{Amount: 10800, Currency: "USD"}, money)
+	money, err = converter.Convert(models.Money{Amount: 10800, Currency: "USD"}, "EUR", day("2020-05-08"))
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money{Amount: 10000, Currency: "EUR"}, money)
+
+	// Older rates are treated as missing, directly & when triangulating
+	_, err = converter.Convert(models.Money{Amount: 10000, Currency: "EUR"}, "USD", day("2020-05-09"))
+	assert.True(t, errors.Is(err, ErrNoRate))
+	assert.Equal(t, "No exchange rate from EUR to USD on 2020-05-09", err.Error())
+	_, err = converter.Convert(models.Money{Amount: 10000, Currency: "USD"}, "GBP", day("2020-05-12"))
+	assert.True(t, errors.Is(err, ErrNoRate))
+
+	// Unless there's a newer one
+	money, err = converter.Convert(models.Money{Amount: 10000, Currency: "EUR"}, "GBP", day("2020-05-19"))
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money{Amount: 8785, Currency: "GBP"}, money)
+
+	// Rates of any age are used without a max age
+	money, err = NewConverter(rates, 0).Convert(models.Money{Amount: 10000, Currency: "EUR"}, "USD", day("2021-05-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money{Amount: 10800, Currency: "USD"}, money)
+}
+
+func TestRoundHalfAwayFromZero(t *testing.T) {
+	assert.Equal(t, int64(2), roundHalfAwayFromZero(big.NewRat(3, 2)))
+	assert.Equal(t, int64(1), roundHalfAwayFromZero(big.NewRat(149, 100)))
+	assert.Equal(t, int64(-2), roundHalfAwayFromZero(big.NewRat(-3, 2)))
+	assert.Equal(t, int64(0), roundHalfAwayFromZero(big.NewRat(0, 1)))
+}
@@ -0,0 +1,96 @@
+package exchange
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"github.com/beanpay/api/database/models"
+	"io"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// newExchangeRate validates the raw values of a rate from an import file.
+func newExchangeRate(date string, base string, quote string, rate string) (*models.ExchangeRate, error) {
+	effectiveDate, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid date: %v", date)
+	}
+	if !currencyPattern.MatchString(base) || !currencyPattern.MatchString(quote) {
+		return nil, fmt.Errorf("Invalid currency pair: %v/%v", base, quote)
+	}
+	parsedRate, ok := new(big.Rat).SetString(rate)
+	if !ok || parsedRate.Sign() <= 0 {
+		return nil, fmt.Errorf("Invalid rate: %v", rate)
+	}
+	return &models.ExchangeRate{
+		Base:          base,
+		Quote:         quote,
+		EffectiveDate: effectiveDate,
+		Rate:          parsedRate,
+	}, nil
+}
+
+// ParseCSV reads rates from a CSV file with a header row followed by rows
+// of the form: date,base,quote,rate (e.g. 2020-05-12,EUR,USD,1.0827).
+func ParseCSV(r io.Reader) ([]*models.ExchangeRate, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("The CSV file is empty")
+	}
+	rates := make([]*models.ExchangeRate, 0, len(records)-1)
+	for i, record := range records[1:] {
+		rate, err := newExchangeRate(record[0], strings.ToUpper(record[1]), strings.ToUpper(record[2]), record[3])
+		if err != nil {
+			return nil, fmt.Errorf("Line %v: %v", i+2, err)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+// ecbEnvelope is the structure of the reference rate files published by the
+// European Central Bank (eurofxref-daily.xml, eurofxref-hist.xml, ...).
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// ParseECB reads rates from a European Central Bank reference rate XML file.
+// Every rate in these files has EUR as its base.
+func ParseECB(r io.Reader) ([]*models.ExchangeRate, error) {
+	envelope := ecbEnvelope{}
+	err := xml.NewDecoder(r).Decode(&envelope)
+	if err != nil {
+		return nil, err
+	}
+	rates := make([]*models.ExchangeRate, 0)
+	for _, day := range envelope.Days {
+		for _, ecbRate := range day.Rates {
+			rate, err := newExchangeRate(day.Time, "EUR", ecbRate.Currency, ecbRate.Rate)
+			if err != nil {
+				return nil, err
+			}
+			rates = append(rates, rate)
+		}
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("No rates were found in the ECB file")
+	}
+	return rates, nil
+}
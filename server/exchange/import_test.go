@@ -0,0 +1,67 @@
+package exchange
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const ecbDaily = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<gesmes:Sender>
+		<gesmes:name>European Central Bank</gesmes:name>
+	</gesmes:Sender>
+	<Cube>
+		<Cube time='2020-05-12'>
+			<Cube currency='USD' rate='1.0827'/>
+			<Cube currency='JPY' rate='115.99'/>
+		</Cube>
+		<Cube time='2020-05-11'>
+			<Cube currency='USD' rate='1.0800'/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func TestParseCSV(t *testing.T) {
+	// Parse a valid file
+	rates, err := ParseCSV(strings.NewReader("date,base,quote,rate\n2020-05-12,EUR,USD,1.0827\n2020-05-12, usd, cad, 1.40\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rates))
+	assert.Equal(t, "EUR", rates[0].Base)
+	assert.Equal(t, "USD", rates[0].Quote)
+	assert.Equal(t, "2020-05-12", rates[0].EffectiveDate.Format("2006-01-02"))
+	assert.Equal(t, "1.0827", rates[0].Rate.FloatString(4))
+	assert.Equal(t, "USD", rates[1].Base)
+	assert.Equal(t, "CAD", rates[1].Quote)
+
+	// Invalid rows report the line they're on
+	_, err = ParseCSV(strings.NewReader("date,base,quote,rate\n2020-05-12,EUR,USD,1.0827\n2020-05-12,EUR,USD,-1\n"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "Line 3: Invalid rate: -1", err.Error())
+	_, err = ParseCSV(strings.NewReader("date,base,quote,rate\n12/05/2020,EUR,USD,1.0827\n"))
+	assert.NotNil(t, err)
+	_, err = ParseCSV(strings.NewReader("date,base,quote,rate\n2020-05-12,EURO,USD,1.0827\n"))
+	assert.NotNil(t, err)
+	_, err = ParseCSV(strings.NewReader("date,base,quote\n2020-05-12,EUR,USD\n"))
+	assert.NotNil(t, err)
+	_, err = ParseCSV(strings.NewReader(""))
+	assert.NotNil(t, err)
+}
+
+func TestParseECB(t *testing.T) {
+	// Parse a valid file
+	rates, err := ParseECB(strings.NewReader(ecbDaily))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(rates))
+	assert.Equal(t, "EUR", rates[1].Base)
+	assert.Equal(t, "JPY", rates[1].Quote)
+	assert.Equal(t, "115.99", rates[1].Rate.FloatString(2))
+	assert.Equal(t, "2020-05-11", rates[2].EffectiveDate.Format("2006-01-02"))
+
+	// Files without rates, or that aren't XML, are rejected
+	_, err = ParseECB(strings.NewReader(`<Envelope><Cube></Cube></Envelope>`))
+	assert.NotNil(t, err)
+	_, err = ParseECB(strings.NewReader("date,base,quote,rate"))
+	assert.NotNil(t, err)
+}
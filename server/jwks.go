@@ -0,0 +1,22 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// jwksMaxAge is how long other services may cache our public keys. It must
+// stay well below the time a new key is published before it becomes active.
+const jwksMaxAge = "max-age=300"
+
+// fetchJwks publishes the public keys our tokens can be verified with, so
+// other services can verify them without being able to sign them. Unlike
+// our other endpoints, this responds with a bare JWK Set (RFC 7517) rather
+// than our response envelope, as that's what JWT libraries expect.
+func (s *Server) fetchJwks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", jwksMaxAge)
+		json.NewEncoder(w).Encode(s.JwtSignatory.Keyring.JWKS())
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/beanpay/api/server/jwt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchJwks(t *testing.T) {
+	// Without a keyring there's nothing to publish
+	server := &Server{JwtSignatory: &jwt.JwtSignatory{SigningKey: []byte("some-key")}}
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	server.fetchJwks()(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "{\"keys\":[]}\n", recorder.Body.String())
+
+	// Publish the public half of an Ed25519 key
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "ed25519.pem")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	assert.Nil(t, err)
+	server.JwtSignatory.Keyring, err = jwt.ParseKeyring("ed-1:EdDSA="+path+",hmac-1=some-secret", "")
+	assert.Nil(t, err)
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	server.fetchJwks()(recorder, req)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "max-age=300", recorder.Header().Get("Cache-Control"))
+	jwks := jwt.JSONWebKeySet{}
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&jwks))
+	assert.Equal(t, server.JwtSignatory.Keyring.JWKS(), jwks)
+	assert.Equal(t, 1, len(jwks.Keys))
+}
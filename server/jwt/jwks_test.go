@@ -0,0 +1,118 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePEM writes a PEM block to a file in dir, returning its path.
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	assert.Nil(t, err)
+	return path
+}
+
+func TestAsymmetricKeyring(t *testing.T) {
+	dir := t.TempDir()
+
+	// Generate an RSA & an Ed25519 key
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	rsaPath := writePEM(t, dir, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	edPublicKey, edPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edPrivateKey)
+	assert.Nil(t, err)
+	edPath := writePEM(t, dir, "ed25519.pem", "PRIVATE KEY", edDER)
+	edPublicDER, err := x509.MarshalPKIXPublicKey(edPublicKey)
+	assert.Nil(t, err)
+	edPublicPath := writePEM(t, dir, "ed25519.pub.pem", "PUBLIC KEY", edPublicDER)
+
+	// Sign tokens with each of them
+	tokens := map[string]string{}
+	for keyId, keys := range map[string]string{
+		"rsa-1": "rsa-1:RS256=" + rsaPath,
+		"ed-1":  "ed-1:EdDSA=" + edPath,
+	} {
+		keyring, err := ParseKeyring(keys, "")
+		assert.Nil(t, err)
+		signatory := &JwtSignatory{Keyring: keyring}
+		tokens[keyId], err = signatory.GenerateSignedToken("some-user-id", AllScopes, time.Now().Add(time.Minute))
+		assert.Nil(t, err)
+	}
+
+	// A service that only has the public keys can verify, but not sign
+	keyring, err := ParseKeyring("hmac-1=some-secret,rsa-1:RS256="+rsaPath+",ed-1:EdDSA="+edPublicPath, "hmac-1")
+	assert.Nil(t, err)
+	verifier := &JwtSignatory{Keyring: keyring}
+	for _, token := range tokens {
+		claims, err := verifier.ParseToken(token)
+		assert.Nil(t, err)
+		assert.Equal(t, "some-user-id", claims.UserID)
+	}
+	_, err = ParseKeyring("ed-1:EdDSA="+edPublicPath, "")
+	assert.Equal(t, "The active key ed-1 has no private key to sign with", err.Error())
+
+	// The published keys match, & leave out the HMAC secret
+	jwks := keyring.JWKS()
+	assert.Equal(t, 2, len(jwks.Keys))
+	assert.Equal(t,
+		JSONWebKey{Kty: "OKP", Kid: "ed-1", Use: "sig", Alg: "EdDSA", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(edPublicKey)},
+		jwks.Keys[0],
+	)
+	assert.Equal(t, "RSA", jwks.Keys[1].Kty)
+	assert.Equal(t, "rsa-1", jwks.Keys[1].Kid)
+	assert.Equal(t, "RS256", jwks.Keys[1].Alg)
+	assert.Equal(t, "AQAB", jwks.Keys[1].E)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()), jwks.Keys[1].N)
+	assert.Equal(t, 0, len((*Keyring)(nil).JWKS().Keys))
+
+	// Keys must suit their algorithm
+	_, err = ParseKeyring("rsa-1:EdDSA="+rsaPath, "")
+	assert.Equal(t, "Key rsa-1: an RSA key can't be used for EdDSA", err.Error())
+	_, err = ParseKeyring("ed-1:RS256="+edPath, "")
+	assert.Equal(t, "Key ed-1: an Ed25519 key can't be used for RS256", err.Error())
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	smallPath := writePEM(t, dir, "small.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(smallKey))
+	_, err = ParseKeyring("rsa-2:RS256="+smallPath, "")
+	assert.Equal(t, "Key rsa-2: RSA keys must be at least 2048 bits", err.Error())
+}
+
+func TestAlgorithmConfusion(t *testing.T) {
+	dir := t.TempDir()
+	_, edPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edPrivateKey)
+	assert.Nil(t, err)
+	edPath := writePEM(t, dir, "ed25519.pem", "PRIVATE KEY", edDER)
+	keyring, err := ParseKeyring("ed-1:EdDSA="+edPath, "")
+	assert.Nil(t, err)
+	signatory := &JwtSignatory{Keyring: keyring}
+
+	// A token signed with HS256 under the kid of an EdDSA key is rejected,
+	// even though it was signed with the bytes of the public key.
+	forger := &JwtSignatory{Keyring: &Keyring{
+		ActiveKeyId: "ed-1",
+		Keys: map[string]*Key{"ed-1": {
+			Id:         "ed-1",
+			Method:     jwt.SigningMethodHS256,
+			PrivateKey: []byte(keyring.Keys["ed-1"].PublicKey.(ed25519.PublicKey)),
+		}},
+	}}
+	token, err := forger.GenerateSignedToken("some-user-id", AllScopes, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	_, err = signatory.ParseToken(token)
+	assert.Equal(t, "Unexpected signing method: HS256", err.Error())
+}
@@ -0,0 +1,154 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"os"
+	"strings"
+)
+
+// minRSAKeyBits is the smallest RSA key we accept.
+const minRSAKeyBits = 2048
+
+// Key is a key that tokens are signed & verified with. HMAC keys use the
+// same secret for both. Asymmetric keys that were loaded from a public key
+// have no PrivateKey, & can only verify tokens.
+type Key struct {
+	Id         string
+	Method     jwt.SigningMethod
+	PrivateKey interface{}
+	PublicKey  interface{}
+}
+
+// Keyring holds the keys tokens are signed with, by their key ID (kid).
+// New tokens are signed with the active key, & any key in the Keyring is
+// accepted when verifying a token. Rotating keys is a matter of adding a
+// new key, making it active once every instance has it, & removing the old
+// key once the tokens signed with it have expired.
+type Keyring struct {
+	ActiveKeyId string
+	Keys        map[string]*Key
+}
+
+// ParseKeyring parses keys separated by commas or new lines, as found in an
+// environment variable or a keys file. Blank lines & lines starting with #
+// are ignored. When activeKeyId is empty, the first key is the active one.
+// Keys are of the form:
+//
+//	kid=secret                  an HS256 secret
+//	kid:RS256=/path/to/key.pem  an RSA key
+//	kid:EdDSA=/path/to/key.pem  an Ed25519 key
+//
+// PEM files may hold a private key, or just the public key of a key that's
+// only used to verify tokens.
+func ParseKeyring(text string, activeKeyId string) (*Keyring, error) {
+	keyring := &Keyring{Keys: map[string]*Key{}}
+	firstKeyId := ""
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, errors.New("Keys must be of the form kid=secret or kid:alg=path")
+		}
+		keyId, alg, value := strings.TrimSpace(parts[0]), "", strings.TrimSpace(parts[1])
+		if i := strings.Index(keyId, ":"); i != -1 {
+			keyId, alg = keyId[:i], keyId[i+1:]
+		}
+		key := &Key{Id: keyId, Method: jwt.SigningMethodHS256, PrivateKey: []byte(value), PublicKey: []byte(value)}
+		if alg != "" {
+			var err error
+			key, err = loadPEMKey(keyId, alg, value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := keyring.Keys[keyId]; ok {
+			return nil, fmt.Errorf("Duplicate key ID: %v", keyId)
+		}
+		keyring.Keys[keyId] = key
+		if firstKeyId == "" {
+			firstKeyId = keyId
+		}
+	}
+	if len(keyring.Keys) == 0 {
+		return nil, errors.New("No keys were found")
+	}
+
+	keyring.ActiveKeyId = activeKeyId
+	if keyring.ActiveKeyId == "" {
+		keyring.ActiveKeyId = firstKeyId
+	}
+	activeKey, ok := keyring.Keys[keyring.ActiveKeyId]
+	if !ok {
+		return nil, fmt.Errorf("The active key %v is not in the keyring", keyring.ActiveKeyId)
+	}
+	if activeKey.PrivateKey == nil {
+		return nil, fmt.Errorf("The active key %v has no private key to sign with", keyring.ActiveKeyId)
+	}
+	return keyring, nil
+}
+
+// loadPEMKey loads an asymmetric key for the algorithm from a PEM file.
+func loadPEMKey(keyId string, alg string, path string) (*Key, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("Key %v: %v is not a PEM file", keyId, path)
+	}
+
+	// Parse the key, deriving the public key from a private key
+	var privateKey, publicKey interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("Key %v: unsupported PEM block %v", keyId, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Key %v: %v", keyId, err)
+	}
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		publicKey = &k.PublicKey
+	case ed25519.PrivateKey:
+		publicKey = k.Public()
+	}
+
+	// Ensure the key suits the algorithm
+	key := &Key{Id: keyId, PrivateKey: privateKey, PublicKey: publicKey}
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return nil, fmt.Errorf("Key %v: an RSA key can't be used for %v", keyId, alg)
+		}
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("Key %v: RSA keys must be at least %v bits", keyId, minRSAKeyBits)
+		}
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return nil, fmt.Errorf("Key %v: an Ed25519 key can't be used for %v", keyId, alg)
+		}
+		key.Method = SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("Key %v: unsupported key type %T", keyId, publicKey)
+	}
+	return key, nil
+}
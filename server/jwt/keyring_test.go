@@ -0,0 +1,42 @@
+package jwt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseKeyring(t *testing.T) {
+	// Keys from an environment variable, the first of which is active
+	keyring, err := ParseKeyring("2020-06=secret-two, 2020-05=secret-one", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "2020-06", keyring.ActiveKeyId)
+	assert.Equal(t, []byte("secret-two"), keyring.Keys["2020-06"].PrivateKey)
+	assert.Equal(t, []byte("secret-one"), keyring.Keys["2020-05"].PrivateKey)
+
+	// Keys from a file, with the active key picked out
+	keyring, err = ParseKeyring("# Retire once June's tokens have expired\n2020-05=secret-one\n\n2020-06=secret=two\n", "2020-06")
+	assert.Nil(t, err)
+	assert.Equal(t, "2020-06", keyring.ActiveKeyId)
+	assert.Equal(t, []byte("secret=two"), keyring.Keys["2020-06"].PrivateKey)
+	assert.Equal(t, 2, len(keyring.Keys))
+
+	// Invalid keyrings
+	for text, expected := range map[string]string{
+		"":                            "No keys were found",
+		"# nothing here":              "No keys were found",
+		"just-a-secret":               "Keys must be of the form kid=secret or kid:alg=path",
+		"kid=":                        "Keys must be of the form kid=secret or kid:alg=path",
+		"=secret":                     "Keys must be of the form kid=secret or kid:alg=path",
+		"2020-05=one,2020-05=two":     "Duplicate key ID: 2020-05",
+		"2020-05=one\n2020-06=two\n ": "",
+	} {
+		_, err := ParseKeyring(text, "")
+		if expected == "" {
+			assert.Nil(t, err)
+			continue
+		}
+		assert.Equal(t, expected, err.Error())
+	}
+	_, err = ParseKeyring("2020-05=secret-one", "2020-06")
+	assert.Equal(t, "The active key 2020-06 is not in the keyring", err.Error())
+}
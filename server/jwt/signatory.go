@@ -1,48 +1,186 @@
 package jwt
 
 import (
+	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/satori/go.uuid"
+	"strings"
 	"time"
 )
 
+// The scopes an access token can be granted. Read-only tokens only have
+// the ScopeRead scope.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// AllScopes are the scopes of the access tokens users get when they login.
+var AllScopes = []string{ScopeRead, ScopeWrite}
+
+// Claims of the tokens we sign. Access tokens have no Purpose. Any other
+// token, such as the challenge of a two-factor login, has a Purpose so that
+// it can't be used in place of an access token. The Scope is a space
+// separated list of the scopes an access token was granted.
+//
+// ApiKeyId is never part of a token. It's set on the Claims of requests
+// that were authenticated with an API key instead.
 type Claims struct {
-	UserID string `json:"user_id"`
+	UserID   string `json:"user_id"`
+	Purpose  string `json:"purpose,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	ApiKeyId string `json:"-"`
 	jwt.StandardClaims
 }
 
+// HasScopes reports whether the token was granted every one of the scopes.
+func (c *Claims) HasScopes(scopes ...string) bool {
+	granted := strings.Fields(c.Scope)
+	for _, scope := range scopes {
+		found := false
+		for _, g := range granted {
+			if g == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// JwtSignatory signs & verifies our tokens. Tokens are signed with the
+// active key of the Keyring, using its algorithm (HS256, RS256 or EdDSA),
+// & carry its ID in their kid header. Without a
+// Keyring, tokens are signed with the SigningKey & have no kid. Tokens
+// without a kid are verified with the SigningKey, so that the tokens signed
+// before a Keyring was configured stay valid while it's still set.
+//
+// Tokens are issued by the Issuer, for the Audience, & are only accepted
+// back from them when they're set. Leeway allows for clocks that have
+// drifted when checking the times a token is valid between.
 type JwtSignatory struct {
 	SigningKey []byte
+	Keyring    *Keyring
+	Issuer     string
+	Audience   string
+	Leeway     time.Duration
+}
+
+// GenerateSignedToken signs an access token granted the scopes.
+func (s *JwtSignatory) GenerateSignedToken(userID string, scopes []string, expiration time.Time) (string, error) {
+	return s.generateSignedToken(userID, "", strings.Join(scopes, " "), expiration)
 }
 
-func (s *JwtSignatory) GenerateSignedToken(userID string, expiration time.Time) (string, error) {
-	token := jwt.NewWithClaims(
-		jwt.SigningMethodHS256,
-		&Claims{
-			UserID: userID,
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: expiration.Unix(),
-			},
+// GenerateSignedPurposeToken signs a token that can only be parsed with
+// ParsePurposeToken for the same purpose.
+func (s *JwtSignatory) GenerateSignedPurposeToken(userID string, purpose string, expiration time.Time) (string, error) {
+	return s.generateSignedToken(userID, purpose, "", expiration)
+}
+
+func (s *JwtSignatory) generateSignedToken(userID string, purpose string, scope string, expiration time.Time) (string, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID:  userID,
+		Purpose: purpose,
+		Scope:   scope,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewV4().String(),
+			Issuer:    s.Issuer,
+			Audience:  s.Audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: expiration.Unix(),
 		},
-	)
-	return token.SignedString(s.SigningKey)
+	}
+	if s.Keyring != nil {
+		key := s.Keyring.Keys[s.Keyring.ActiveKeyId]
+		token := jwt.NewWithClaims(key.Method, claims)
+		token.Header["kid"] = key.Id
+		return token.SignedString(key.PrivateKey)
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.SigningKey)
 }
 
+// verificationKey returns the key to verify a token with, by its kid. The
+// token has to have been signed with the algorithm of that key, so that a
+// public key can't be passed off as an HMAC secret.
+func (s *JwtSignatory) verificationKey(jwtToken *jwt.Token) (interface{}, error) {
+	keyId, ok := jwtToken.Header["kid"].(string)
+	if !ok {
+		if jwtToken.Method.Alg() != "HS256" {
+			return nil, fmt.Errorf("Unexpected signing method: %v", jwtToken.Header["alg"])
+		}
+		if len(s.SigningKey) == 0 {
+			return nil, errors.New("The token has no kid")
+		}
+		return s.SigningKey, nil
+	}
+	if s.Keyring == nil {
+		return nil, fmt.Errorf("Unknown signing key: %v", keyId)
+	}
+	key, ok := s.Keyring.Keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing key: %v", keyId)
+	}
+	if jwtToken.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method: %v", jwtToken.Header["alg"])
+	}
+	return key.PublicKey, nil
+}
+
+// ParseToken parses & validates an access token.
 func (s *JwtSignatory) ParseToken(token string) (*Claims, error) {
+	return s.ParsePurposeToken(token, "")
+}
+
+// ParsePurposeToken parses & validates a token that was signed for the
+// purpose.
+func (s *JwtSignatory) ParsePurposeToken(token string, purpose string) (*Claims, error) {
 	claims := &Claims{}
-	parsedToken, err := jwt.ParseWithClaims(
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	parsedToken, err := parser.ParseWithClaims(
 		token,
 		claims,
-		func(jwtToken *jwt.Token) (interface{}, error) {
-			if jwtToken.Method.Alg() != "HS256" {
-				msg := fmt.Errorf("Unexpected signing method: %v", jwtToken.Header["alg"])
-				return nil, msg
-			}
-			return s.SigningKey, nil
-		},
+		s.verificationKey,
 	)
 	if err != nil || !parsedToken.Valid {
 		return nil, err
 	}
+	err = s.validateClaims(claims, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, errors.New("Unexpected token purpose")
+	}
 	return claims, nil
 }
+
+// validateClaims checks the token is valid at the time, give or take our
+// Leeway, & that it was issued by & for us.
+func (s *JwtSignatory) validateClaims(claims *Claims, now time.Time) error {
+	leeway := int64(s.Leeway.Seconds())
+	if claims.ExpiresAt == 0 {
+		return errors.New("token has no expiry")
+	}
+	if now.Unix() > claims.ExpiresAt+leeway {
+		return fmt.Errorf("token is expired by %v", time.Duration(now.Unix()-claims.ExpiresAt)*time.Second)
+	}
+	if now.Unix() < claims.IssuedAt-leeway {
+		return errors.New("Token used before issued")
+	}
+	if now.Unix() < claims.NotBefore-leeway {
+		return errors.New("token is not valid yet")
+	}
+	if s.Issuer != "" && claims.Issuer != s.Issuer {
+		return fmt.Errorf("Unexpected issuer: %v", claims.Issuer)
+	}
+	if s.Audience != "" && claims.Audience != s.Audience {
+		return fmt.Errorf("Unexpected audience: %v", claims.Audience)
+	}
+	return nil
+}
@@ -13,7 +13,7 @@ func TestJwtSignatory(t *testing.T) {
 	}
 
 	// Generate a Signed Token
-	jwt, err := signatoryOne.GenerateSignedToken("some-user-id", time.Now().Add(time.Millisecond*10))
+	jwt, err := signatoryOne.GenerateSignedToken("some-user-id", AllScopes, time.Now().Add(time.Millisecond*10))
 	assert.Nil(t, err)
 
 	// Parse & Validated the generated token
@@ -39,7 +39,7 @@ func TestJwtSignatory(t *testing.T) {
 	}
 
 	// Sign a JWT
-	jwt, err = signatoryTwo.GenerateSignedToken("some-user-id", time.Now().Add(time.Millisecond*10))
+	jwt, err = signatoryTwo.GenerateSignedToken("some-user-id", AllScopes, time.Now().Add(time.Millisecond*10))
 	assert.Nil(t, err)
 	assert.NotEqual(t, "", jwt)
 
@@ -50,3 +50,147 @@ func TestJwtSignatory(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Equal(t, "signature is invalid", err.Error())
 }
+
+func TestJwtSignatoryPurpose(t *testing.T) {
+	signatory := &JwtSignatory{
+		SigningKey: []byte("sig-one"),
+	}
+
+	// Sign a token for a purpose
+	jwt, err := signatory.GenerateSignedPurposeToken("some-user-id", "mfa_challenge", time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+
+	// It can be parsed for its purpose
+	claims, err := signatory.ParsePurposeToken(jwt, "mfa_challenge")
+	assert.Nil(t, err)
+	assert.Equal(t, "some-user-id", claims.UserID)
+	assert.Equal(t, "mfa_challenge", claims.Purpose)
+
+	// But can't be used as an access token, or for another purpose
+	claims, err = signatory.ParseToken(jwt)
+	assert.Nil(t, claims)
+	assert.Equal(t, "Unexpected token purpose", err.Error())
+	_, err = signatory.ParsePurposeToken(jwt, "something_else")
+	assert.NotNil(t, err)
+
+	// Nor can access tokens be used for a purpose
+	jwt, err = signatory.GenerateSignedToken("some-user-id", AllScopes, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	_, err = signatory.ParsePurposeToken(jwt, "mfa_challenge")
+	assert.NotNil(t, err)
+}
+
+func TestJwtSignatoryKeyring(t *testing.T) {
+	// Sign a token with the legacy key, before keys were rotated
+	legacySignatory := &JwtSignatory{
+		SigningKey: []byte("legacy-key"),
+	}
+	legacyToken, err := legacySignatory.GenerateSignedToken("some-user-id", AllScopes, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+
+	// Sign a token with the first key of a keyring
+	keyring, err := ParseKeyring("2020-05=secret-one", "")
+	assert.Nil(t, err)
+	signatory := &JwtSignatory{
+		SigningKey: []byte("legacy-key"),
+		Keyring:    keyring,
+	}
+	firstToken, err := signatory.GenerateSignedToken("some-user-id", AllScopes, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	claims, err := signatory.ParseToken(firstToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "some-user-id", claims.UserID)
+
+	// Rotate to a second key, while the first is still accepted
+	signatory.Keyring, err = ParseKeyring("2020-05=secret-one,2020-06=secret-two", "2020-06")
+	assert.Nil(t, err)
+	secondToken, err := signatory.GenerateSignedToken("some-user-id", AllScopes, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	for _, token := range []string{legacyToken, firstToken, secondToken} {
+		_, err = signatory.ParseToken(token)
+		assert.Nil(t, err)
+	}
+
+	// Retire the first & legacy keys
+	signatory.SigningKey = nil
+	signatory.Keyring, err = ParseKeyring("2020-06=secret-two", "")
+	assert.Nil(t, err)
+	_, err = signatory.ParseToken(secondToken)
+	assert.Nil(t, err)
+	_, err = signatory.ParseToken(firstToken)
+	assert.Equal(t, "Unknown signing key: 2020-05", err.Error())
+	_, err = signatory.ParseToken(legacyToken)
+	assert.Equal(t, "The token has no kid", err.Error())
+
+	// A key can't be swapped out from under its kid
+	signatory.Keyring, err = ParseKeyring("2020-06=another-secret", "")
+	assert.Nil(t, err)
+	_, err = signatory.ParseToken(secondToken)
+	assert.Equal(t, "signature is invalid", err.Error())
+}
+
+func TestJwtSignatoryClaims(t *testing.T) {
+	signatory := &JwtSignatory{
+		SigningKey: []byte("sig-one"),
+		Issuer:     "https://api.example.com",
+		Audience:   "https://app.example.com",
+	}
+
+	// Sign a read-only token
+	token, err := signatory.GenerateSignedToken("some-user-id", []string{ScopeRead}, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	claims, err := signatory.ParseToken(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://api.example.com", claims.Issuer)
+	assert.Equal(t, "https://app.example.com", claims.Audience)
+	assert.NotEqual(t, "", claims.Id)
+	assert.NotEqual(t, int64(0), claims.IssuedAt)
+	assert.Equal(t, claims.IssuedAt, claims.NotBefore)
+	assert.True(t, claims.HasScopes(ScopeRead))
+	assert.False(t, claims.HasScopes(ScopeWrite))
+	assert.False(t, claims.HasScopes(ScopeRead, ScopeWrite))
+
+	// Every token has its own ID
+	otherToken, err := signatory.GenerateSignedToken("some-user-id", []string{ScopeRead}, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	otherClaims, err := signatory.ParseToken(otherToken)
+	assert.Nil(t, err)
+	assert.NotEqual(t, claims.Id, otherClaims.Id)
+
+	// Tokens issued by, or for, anyone else are rejected
+	for _, other := range []*JwtSignatory{
+		{SigningKey: []byte("sig-one"), Issuer: "https://evil.example.com", Audience: signatory.Audience},
+		{SigningKey: []byte("sig-one"), Issuer: signatory.Issuer, Audience: "https://evil.example.com"},
+	} {
+		token, err = other.GenerateSignedToken("some-user-id", AllScopes, time.Now().Add(time.Minute))
+		assert.Nil(t, err)
+		_, err = signatory.ParseToken(token)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestJwtSignatoryLeeway(t *testing.T) {
+	signatory := &JwtSignatory{
+		SigningKey: []byte("sig-one"),
+	}
+	now := time.Now()
+
+	// A token that expired a few seconds ago is rejected
+	claims := &Claims{}
+	claims.ExpiresAt = now.Add(-5 * time.Second).Unix()
+	assert.Equal(t, "token is expired by 5s", signatory.validateClaims(claims, now).Error())
+
+	// Unless it's within the leeway, which also allows for tokens issued by
+	// a clock that's slightly ahead
+	signatory.Leeway = 10 * time.Second
+	assert.Nil(t, signatory.validateClaims(claims, now))
+	claims.ExpiresAt = now.Add(time.Minute).Unix()
+	claims.IssuedAt = now.Add(5 * time.Second).Unix()
+	claims.NotBefore = claims.IssuedAt
+	assert.Nil(t, signatory.validateClaims(claims, now))
+	claims.IssuedAt = now.Add(time.Minute).Unix()
+	assert.Equal(t, "Token used before issued", signatory.validateClaims(claims, now).Error())
+
+	// Tokens always have to expire
+	assert.Equal(t, "token has no expiry", signatory.validateClaims(&Claims{}, now).Error())
+}
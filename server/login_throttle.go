@@ -0,0 +1,82 @@
+package server
+
+import (
+	"github.com/beanpay/api/server/middleware"
+	"github.com/generalledger/response"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loginThrottleKeys returns the keys a login is throttled on, which are the
+// account that's being logged in to & the client that's doing it.
+func loginThrottleKeys(r *http.Request, email string) (accountKey string, clientKey string) {
+	return "account:" + strings.ToLower(email), "client:" + middleware.ClientIP(r)
+}
+
+// attemptLogin counts a login to the account from the client as failed
+// before it's made, unless either is being throttled, in which case it
+// returns how long until they can try again. The client is tried first, so
+// that a client that's being throttled doesn't count against the account.
+func (s *Server) attemptLogin(r *http.Request, email string) (time.Duration, error) {
+	accountKey, clientKey := loginThrottleKeys(r, email)
+	if s.ClientLoginThrottle != nil {
+		retryAfter, err := s.ClientLoginThrottle.Attempt(clientKey)
+		if err != nil || retryAfter > 0 {
+			return retryAfter, err
+		}
+	}
+	if s.AccountLoginThrottle != nil {
+		retryAfter, err := s.AccountLoginThrottle.Attempt(accountKey)
+		if err != nil || retryAfter > 0 {
+			if s.ClientLoginThrottle != nil {
+				s.ClientLoginThrottle.Release(clientKey)
+			}
+			return retryAfter, err
+		}
+	}
+	return 0, nil
+}
+
+// releaseLogin takes back the failure that attemptLogin counted, for logins
+// with the right password that didn't go on to start a session, such as
+// those that still have to answer a second factor.
+func (s *Server) releaseLogin(r *http.Request, email string) error {
+	accountKey, clientKey := loginThrottleKeys(r, email)
+	if s.AccountLoginThrottle != nil {
+		err := s.AccountLoginThrottle.Release(accountKey)
+		if err != nil {
+			return err
+		}
+	}
+	if s.ClientLoginThrottle != nil {
+		return s.ClientLoginThrottle.Release(clientKey)
+	}
+	return nil
+}
+
+// succeedLogin forgets the failed logins to the account. Only the attempt
+// that succeeded is taken back from the client, so that logging in to one
+// account doesn't let it carry on guessing the passwords of others.
+func (s *Server) succeedLogin(r *http.Request, email string) error {
+	accountKey, clientKey := loginThrottleKeys(r, email)
+	if s.AccountLoginThrottle != nil {
+		err := s.AccountLoginThrottle.Reset(accountKey)
+		if err != nil {
+			return err
+		}
+	}
+	if s.ClientLoginThrottle != nil {
+		return s.ClientLoginThrottle.Release(clientKey)
+	}
+	return nil
+}
+
+// tooManyLoginAttempts responds with Too Many Requests, & when to retry.
+func tooManyLoginAttempts(w http.ResponseWriter, resp *response.Response, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	resp.SetResult(http.StatusTooManyRequests, nil).
+		WithErrorDetails("Too many failed login attempts. Please try again later.")
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"github.com/beanpay/api/server/middleware"
+	"github.com/beanpay/api/server/throttle"
+	"github.com/generalledger/response"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// loginStatus attempts a login from the client, returning the response.
+func loginStatus(server *TestServer, email string, password string, remoteAddr string) *http.Response {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: email, Password: password})
+	req.RemoteAddr = remoteAddr
+	server.login()(recorder, req)
+	return recorder.Result()
+}
+
+func TestLoginThrottle(t *testing.T) {
+	// Prepare the Server, throttling accounts after 2 failures & clients
+	// after 3
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	seedLoggedInUser(t, server, realUserEmail, realUserPassword)
+	store := throttle.NewMemoryStore()
+	server.AccountLoginThrottle = &throttle.Throttle{Store: store, Allowed: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	server.ClientLoginThrottle = &throttle.Throttle{Store: store, Allowed: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+
+	// Fail twice, after which the account is throttled, even with the
+	// right password
+	for i := 0; i < 2; i++ {
+		result := loginStatus(server, realUserEmail, "not-the-password", "192.0.2.1:1234")
+		assert.Equal(t, http.StatusUnauthorized, response.Parse(result.Body).StatusCode)
+	}
+	result := loginStatus(server, realUserEmail, realUserPassword, "198.51.100.7:1234")
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusTooManyRequests,
+			StatusText:   http.StatusText(http.StatusTooManyRequests),
+			ErrorDetails: &[]string{"Too many failed login attempts. Please try again later."},
+			Result:       nil,
+		},
+		response.Parse(result.Body),
+	)
+	assert.Equal(t, "60", result.Header.Get("Retry-After"))
+
+	// Once a client has failed too many times, it's throttled on every
+	// account, including ones that don't exist
+	result = loginStatus(server, "unknown@example.com", "some-password", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(result.Body).StatusCode)
+	result = loginStatus(server, "other@example.com", "some-password", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, response.Parse(result.Body).StatusCode)
+
+	// Once the account's lockout is over, a successful login resets its
+	// failures, but only takes back its own attempt from the client
+	assert.Nil(t, server.AccountLoginThrottle.Reset("account:"+realUserEmail))
+	result = loginStatus(server, realUserEmail, "not-the-password", "198.51.100.7:1234")
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(result.Body).StatusCode)
+	result = loginStatus(server, realUserEmail, realUserPassword, "198.51.100.7:1234")
+	assert.Equal(t, http.StatusOK, response.Parse(result.Body).StatusCode)
+	_, err = store.FetchByKey("account:" + realUserEmail)
+	assert.NotNil(t, err)
+	_, err = store.FetchByKey("client:192.0.2.1")
+	assert.Nil(t, err)
+}
+
+func TestLoginThrottleConcurrentAttempts(t *testing.T) {
+	// Prepare the Server, throttling accounts after 3 failures
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	seedLoggedInUser(t, server, realUserEmail, realUserPassword)
+	server.AccountLoginThrottle = &throttle.Throttle{Store: throttle.NewMemoryStore(), Allowed: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+
+	// Fire a burst of bad logins at once. Only the ones that got past the
+	// throttle reach the password check & are Unauthorized, the rest are
+	// turned away.
+	var wg sync.WaitGroup
+	statusCodes := make(chan int, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := loginStatus(server, realUserEmail, "not-the-password", "192.0.2.1:1234")
+			statusCodes <- response.Parse(result.Body).StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statusCodes)
+	counts := map[int]int{}
+	for statusCode := range statusCodes {
+		counts[statusCode]++
+	}
+	assert.Equal(t, map[int]int{http.StatusUnauthorized: 3, http.StatusTooManyRequests: 17}, counts)
+}
+
+func TestLoginThrottleBehindProxy(t *testing.T) {
+	// Prepare the Server behind a proxy, throttling clients after a failure
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	seedLoggedInUser(t, server, realUserEmail, realUserPassword)
+	server.ClientLoginThrottle = &throttle.Throttle{Store: throttle.NewMemoryStore(), Allowed: 1, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	trustedProxies, err := middleware.ParseTrustedProxies("10.0.0.0/8")
+	assert.Nil(t, err)
+	handler := middleware.GetTrustedProxiesMiddleware(trustedProxies)(server.login())
+	proxiedLoginStatus := func(forwardedFor string) int {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: "unknown@example.com", Password: "some-password"})
+		req.RemoteAddr = "10.0.0.2:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		handler.ServeHTTP(recorder, req)
+		return response.Parse(recorder.Result().Body).StatusCode
+	}
+
+	// Clients are throttled on their own address, not the proxy's
+	assert.Equal(t, http.StatusUnauthorized, proxiedLoginStatus("192.0.2.1"))
+	assert.Equal(t, http.StatusTooManyRequests, proxiedLoginStatus("192.0.2.1"))
+	assert.Equal(t, http.StatusUnauthorized, proxiedLoginStatus("198.51.100.7"))
+}
@@ -0,0 +1,62 @@
+// Package mail sends the transactional emails of the API, such as the
+// links that confirm a change of email address.
+package mail
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers Messages.
+type Mailer interface {
+	Send(message Message) error
+}
+
+// LogMailer writes Messages to a Writer instead of delivering them, which
+// is handy in development when there's no mail server to talk to.
+type LogMailer struct {
+	Writer io.Writer
+}
+
+func (l *LogMailer) Send(message Message) error {
+	_, err := fmt.Fprintf(l.Writer, "To: %v\nSubject: %v\n\n%v\n\n", message.To, message.Subject, message.Body)
+	return err
+}
+
+// Outbox keeps every Message it's sent in memory, so tests can inspect them.
+type Outbox struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (o *Outbox) Send(message Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, message)
+	return nil
+}
+
+// Messages returns every Message sent so far, oldest first.
+func (o *Outbox) Messages() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Message{}, o.messages...)
+}
+
+// Last returns the most recently sent Message.
+func (o *Outbox) Last() (Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.messages) == 0 {
+		return Message{}, false
+	}
+	return o.messages[len(o.messages)-1], true
+}
@@ -0,0 +1,124 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogMailer(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	mailer := &LogMailer{Writer: buffer}
+	err := mailer.Send(Message{To: "user@example.com", Subject: "Hello", Body: "World"})
+	assert.Nil(t, err)
+	assert.Equal(t, "To: user@example.com\nSubject: Hello\n\nWorld\n\n", buffer.String())
+}
+
+func TestOutbox(t *testing.T) {
+	outbox := &Outbox{}
+	_, ok := outbox.Last()
+	assert.False(t, ok)
+
+	outbox.Send(Message{To: "first@example.com"})
+	outbox.Send(Message{To: "second@example.com"})
+	last, ok := outbox.Last()
+	assert.True(t, ok)
+	assert.Equal(t, "second@example.com", last.To)
+	assert.Equal(t, 2, len(outbox.Messages()))
+}
+
+func TestSMTPMailerFormat(t *testing.T) {
+	mailer, err := NewSMTPMailer("localhost:25", "", "", "BeanPay <no-reply@beanpay.app>")
+	assert.Nil(t, err)
+	date := time.Date(2020, 5, 12, 9, 30, 0, 0, time.UTC)
+	assert.Equal(t,
+		"From: BeanPay <no-reply@beanpay.app>\r\n"+
+			"To: user@example.com\r\n"+
+			"Subject: Hello\r\n"+
+			"Date: Tue, 12 May 2020 09:30:00 +0000\r\n"+
+			"MIME-Version: 1.0\r\n"+
+			"Content-Type: text/plain; charset=utf-8\r\n"+
+			"\r\n"+
+			"First line\r\nSecond line\r\n",
+		string(mailer.format(Message{To: "user@example.com", Subject: "Hello", Body: "First line\nSecond line"}, date)),
+	)
+
+	// Subjects that aren't plain ASCII are encoded
+	formatted := string(mailer.format(Message{To: "user@example.com", Subject: "Café"}, date))
+	assert.Contains(t, formatted, "Subject: =?utf-8?q?Caf=C3=A9?=\r\n")
+}
+
+// fakeSMTPServer accepts a single message, & returns the commands that the
+// client sent it.
+func fakeSMTPServer(t *testing.T) (string, <-chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	commands := make(chan []string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			commands <- nil
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		received := make([]string, 0)
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			received = append(received, line)
+			switch {
+			case strings.HasPrefix(line, "DATA"):
+				conn.Write([]byte("354 Go ahead\r\n"))
+				for line != "." {
+					line, _ = reader.ReadString('\n')
+					line = strings.TrimRight(line, "\r\n")
+				}
+				conn.Write([]byte("250 OK\r\n"))
+			case strings.HasPrefix(line, "QUIT"):
+				conn.Write([]byte("221 Bye\r\n"))
+				commands <- received
+				return
+			default:
+				conn.Write([]byte("250 OK\r\n"))
+			}
+		}
+		commands <- received
+	}()
+	return listener.Addr().String(), commands
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	addr, commands := fakeSMTPServer(t)
+	mailer, err := NewSMTPMailer(addr, "", "", "BeanPay <no-reply@beanpay.app>")
+	assert.Nil(t, err)
+	err = mailer.Send(Message{To: "user@example.com", Subject: "Hello", Body: "World"})
+	assert.Nil(t, err)
+
+	// The envelope only has the address, without the display name
+	received := <-commands
+	assert.Contains(t, received, "MAIL FROM:<no-reply@beanpay.app>")
+	assert.Contains(t, received, "RCPT TO:<user@example.com>")
+}
+
+func TestNewSMTPMailer(t *testing.T) {
+	// Bare addresses are used as is
+	mailer, err := NewSMTPMailer("localhost:25", "", "", "no-reply@beanpay.app")
+	assert.Nil(t, err)
+	assert.Equal(t, "no-reply@beanpay.app", mailer.sender)
+
+	// Invalid addresses are rejected up front
+	for _, from := range []string{"", "BeanPay", "BeanPay <no-reply>"} {
+		_, err = NewSMTPMailer("localhost:25", "", "", from)
+		assert.NotNil(t, err, from)
+	}
+}
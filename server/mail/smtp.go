@@ -0,0 +1,68 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPMailer delivers Messages through an SMTP server. Username & Password
+// are optional, as a local relay usually doesn't require authentication.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+
+	// sender is the bare address of From, which is what the SMTP envelope
+	// takes. From may also include a display name, for the From: header.
+	sender string
+}
+
+// NewSMTPMailer creates an SMTPMailer that sends from the address 'from',
+// such as "BeanPay <no-reply@beanpay.app>".
+func NewSMTPMailer(addr string, username string, password string, from string) (*SMTPMailer, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid from address %q: %v", from, err)
+	}
+	return &SMTPMailer{
+		Addr:     addr,
+		Username: username,
+		Password: password,
+		From:     from,
+		sender:   sender.Address,
+	}, nil
+}
+
+func (s *SMTPMailer) Send(message Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, s.sender, []string{message.To}, s.format(message, time.Now()))
+}
+
+// format builds the RFC 5322 representation of a Message.
+func (s *SMTPMailer) format(message Message, date time.Time) []byte {
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "From: %v\r\n", s.From)
+	fmt.Fprintf(buffer, "To: %v\r\n", message.To)
+	fmt.Fprintf(buffer, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(buffer, "Date: %v\r\n", date.Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("\r\n")
+	buffer.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	buffer.WriteString("\r\n")
+	return buffer.Bytes()
+}
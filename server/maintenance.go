@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/maintenance"
+	"github.com/beanpay/api/server/throttle"
+	"time"
+)
+
+// maintenanceLockId is the Postgres advisory lock that the replicas of the
+// server take turns running maintenance with. Any number will do, as long
+// as every replica uses the same one & nothing else does.
+const maintenanceLockId = 4_271_905_133
+
+// maintenanceTasks are the housekeeping jobs run in the background.
+func (s *Server) maintenanceTasks() []maintenance.Task {
+	refreshTokenRepo := models.RefreshTokenRepository{DB: s.DB}
+	loginFailureRepo := models.LoginFailureRepository{DB: s.DB}
+	tasks := []maintenance.Task{
+		{
+			// Sessions that expired without being refreshed or signed out
+			// of would otherwise be kept forever
+			Name: "purge expired sessions",
+			Run: func(ctx context.Context) error {
+				_, err := refreshTokenRepo.DeleteAllStaleChains(time.Now().Add(-refreshTokenDuration))
+				return err
+			},
+		},
+	}
+
+	// Failed logins are forgotten once they're older than the longest
+	// window that logins are throttled over
+	window := time.Duration(0)
+	for _, t := range []*throttle.Throttle{s.AccountLoginThrottle, s.ClientLoginThrottle} {
+		if t != nil && t.Window > window {
+			window = t.Window
+		}
+	}
+	if window > 0 {
+		tasks = append(tasks, maintenance.Task{
+			Name: "purge stale login failures",
+			Run: func(ctx context.Context) error {
+				_, err := loginFailureRepo.DeleteAllStale(time.Now().Add(-window))
+				return err
+			},
+		})
+	}
+	return tasks
+}
+
+// startMaintenance runs the maintenanceTasks every MaintenanceInterval in
+// the background, unless it's zero.
+func (s *Server) startMaintenance(ctx context.Context) {
+	if s.MaintenanceInterval <= 0 {
+		return
+	}
+	runner := &maintenance.Runner{
+		DB:       s.DB,
+		LockId:   maintenanceLockId,
+		Interval: s.MaintenanceInterval,
+		Tasks:    s.maintenanceTasks(),
+	}
+	go runner.Start(ctx)
+}
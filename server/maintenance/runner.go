@@ -0,0 +1,77 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Task is a housekeeping job that's run on a schedule, such as purging rows
+// that have expired.
+type Task struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Runner runs its Tasks every Interval, in the background of the server.
+// Every replica of the server runs one, so they take turns through a
+// transaction level Postgres advisory lock on LockId. Whichever replica
+// holds the lock runs the Tasks, & the others skip that round.
+type Runner struct {
+	DB       *sql.DB
+	LockId   int64
+	Interval time.Duration
+	Tasks    []Task
+}
+
+// RunOnce runs every Task, if no other Runner is running them already,
+// returning whether they were run. A Task that fails doesn't stop the rest
+// from running, & the errors of all of them are returned together.
+func (r *Runner) RunOnce(ctx context.Context) (bool, error) {
+	// The lock is taken within a transaction, which holds it until the
+	// transaction ends, so it's always let go of once the Tasks are done,
+	// even if the connection is lost or handed back to the pool
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	locked := false
+	err = tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1);", r.LockId).Scan(&locked)
+	if err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, nil
+	}
+
+	// Run the Tasks
+	var errs []error
+	for _, task := range r.Tasks {
+		err := task.Run(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", task.Name, err))
+		}
+	}
+	return true, errors.Join(append(errs, tx.Commit())...)
+}
+
+// Start runs the Tasks straight away, & then every Interval until the
+// context is done. Failures are printed, & tried again next time.
+func (r *Runner) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		_, err := r.RunOnce(ctx)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Maintenance failed: %v", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
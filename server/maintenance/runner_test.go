@@ -0,0 +1,63 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"github.com/beanpay/api/database"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRunner(t *testing.T) {
+	// Create a Database for testing
+	ephemeralDatabase, err := database.NewTestEphemeralDatabase(
+		database.Config{
+			MigrationsDir: "../../database/migrations",
+		},
+	)
+	assert.Nil(t, err)
+	defer ephemeralDatabase.Terminate()
+	db := ephemeralDatabase.Connection()
+
+	// Prepare a Runner with a Task that fails, & one that counts its runs
+	runs := 0
+	runner := &Runner{
+		DB:       db,
+		LockId:   42,
+		Interval: time.Hour,
+		Tasks: []Task{
+			{Name: "failing", Run: func(ctx context.Context) error { return errors.New("Something went wrong") }},
+			{Name: "counting", Run: func(ctx context.Context) error { runs++; return nil }},
+		},
+	}
+
+	// Every Task is run, even after one fails
+	ran, err := runner.RunOnce(context.Background())
+	assert.True(t, ran)
+	assert.Equal(t, "failing: Something went wrong", err.Error())
+	assert.Equal(t, 1, runs)
+
+	// After which the lock has been let go of, so another replica can take it
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	assert.Nil(t, err)
+	defer conn.Close()
+	locked := false
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(42);").Scan(&locked)
+	assert.Nil(t, err)
+	assert.True(t, locked)
+
+	// While another replica holds the lock, the Tasks are skipped
+	ran, err = runner.RunOnce(ctx)
+	assert.False(t, ran)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, runs)
+
+	// Until it lets go of it
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_unlock(42);")
+	assert.Nil(t, err)
+	ran, _ = runner.RunOnce(ctx)
+	assert.True(t, ran)
+	assert.Equal(t, 2, runs)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/throttle"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceTasks(t *testing.T) {
+	// Prepare the Server & a user with a session that expired long ago
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	userId, refreshToken := seedLoggedInUser(t, server, realUserEmail, realUserPassword)
+	_, err = server.DB.Exec(
+		"UPDATE refresh_tokens SET created_at = $1 WHERE id = $2;",
+		time.Now().Add(-refreshTokenDuration-time.Hour),
+		refreshToken,
+	)
+	assert.Nil(t, err)
+	activeToken := loginFrom(t, server, "Safari on iOS", "198.51.100.7:443")
+
+	// Login failures are only purged while logins are throttled
+	assert.Equal(t, 1, len(server.maintenanceTasks()))
+	server.AccountLoginThrottle = &throttle.Throttle{Window: time.Hour}
+	loginFailureRepo := models.LoginFailureRepository{DB: server.DB}
+	_, err = loginFailureRepo.ReserveAttempt("client:192.0.2.1", time.Now().Add(-2*time.Hour), time.Now().Add(-3*time.Hour), func(*models.LoginFailure) bool { return true })
+	assert.Nil(t, err)
+
+	// Run the tasks, which purges the expired session & stale failures
+	for _, task := range server.maintenanceTasks() {
+		assert.Nil(t, task.Run(context.Background()), task.Name)
+	}
+	refreshTokenRepo := models.RefreshTokenRepository{DB: server.DB}
+	_, err = refreshTokenRepo.FetchByID(refreshToken)
+	assert.NotNil(t, err)
+	_, err = refreshTokenRepo.FetchByID(activeToken)
+	assert.Nil(t, err)
+	sessions, err := refreshTokenRepo.FetchAllUserSessions(userId, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(sessions))
+	_, err = loginFailureRepo.FetchByKey("client:192.0.2.1")
+	assert.NotNil(t, err)
+}
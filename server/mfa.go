@@ -0,0 +1,551 @@
+package server
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/mail"
+	"github.com/beanpay/api/server/totp"
+	"github.com/generalledger/response"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// mfaTokenPurpose is the Purpose of the tokens that login hands out to
+	// users with two-factor authentication, in place of an AccessToken.
+	mfaTokenPurpose = "mfa_challenge"
+
+	// mfaTokenDuration is how long a user has to enter their code.
+	mfaTokenDuration = 5 * time.Minute
+
+	// totpIssuer is the name authenticator apps list our codes under.
+	totpIssuer = "BeanPay"
+
+	// recoveryCodeCount is how many recovery codes a user is given at once.
+	recoveryCodeCount = 10
+)
+
+type mfaChallengeResponseBody struct {
+	MfaRequired        bool      `json:"mfa_required"`
+	MfaToken           string    `json:"mfa_token"`
+	MfaTokenExpiration time.Time `json:"mfa_token_expiration"`
+}
+
+type totpEnrollmentResponseBody struct {
+	Secret string `json:"secret"`
+
+	// ProvisioningURI is the otpauth:// URI to render as a QR code.
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type recoveryCodesResponseBody struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes generates a set of recovery codes of the form
+// abcde-fghij, along with the hashes of them that we store in their place.
+func newRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		_, err = rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b)[:10])
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns the hash that's stored for a recovery code,
+// ignoring case, spaces & dashes as people type them in however they like.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashSecretToken(code)
+}
+
+// verifySecondFactor checks either a TOTP code or a recovery code of the
+// User. TOTP codes can only be used once, & recovery codes are consumed.
+func (s *Server) verifySecondFactor(user *models.User, code string, recoveryCode string) (bool, error) {
+	userRepo := models.UserRepository{DB: s.DB}
+	recoveryCodeRepo := models.RecoveryCodeRepository{DB: s.DB}
+	if recoveryCode != "" {
+		err := recoveryCodeRepo.Consume(user.Id, hashRecoveryCode(recoveryCode))
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	step, ok := totp.Validate(user.TotpSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	err := userRepo.AdvanceTotpStep(user, step)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Server) loginMfa() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	type RequestBody struct {
+		MfaToken             string `json:"mfa_token" validate:"required"`
+		Code                 string `json:"code"`
+		RecoveryCode         string `json:"recovery_code"`
+		RefreshTokenDelivery string `json:"refresh_token_delivery" validate:"omitempty,oneof=cookie body"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+		if requestBody.Code == "" && requestBody.RecoveryCode == "" {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Either a code or a recovery code is required.")
+			return
+		}
+
+		// Validate the challenge & fetch the User it was issued to
+		claims, err := s.JwtSignatory.ParsePurposeToken(requestBody.MfaToken, mfaTokenPurpose)
+		if err != nil {
+			resp.SetResult(http.StatusUnauthorized, nil)
+			return
+		}
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil || !user.IsTotpEnabled() {
+			resp.SetResult(http.StatusUnauthorized, nil)
+			return
+		}
+
+		// Codes are guessed against the same throttles as passwords
+		retryAfter, err := s.attemptLogin(r, user.Email)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if retryAfter > 0 {
+			tooManyLoginAttempts(w, resp, retryAfter)
+			return
+		}
+
+		// Verify the code
+		ok, err := s.verifySecondFactor(user, requestBody.Code, requestBody.RecoveryCode)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if !ok {
+			resp.SetResult(http.StatusUnauthorized, nil).
+				WithErrorDetails("The code is incorrect.")
+			return
+		}
+
+		// OK
+		s.succeedLogin(r, user.Email)
+		body, err := s.startSession(w, r, user.Id, requestBody.RefreshTokenDelivery)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		resp.SetResult(http.StatusOK, body)
+	}
+}
+
+func (s *Server) enrollTotp() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	type RequestBody struct {
+		Password string `json:"password" validate:"required"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := sessionClaims(resp, r)
+		if !ok {
+			return
+		}
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Fetch the User & re-verify their Password
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusNotFound, nil)
+			return
+		}
+
+		// Passwords are guessed against the same throttles as logins
+		retryAfter, err := s.attemptLogin(r, user.Email)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if retryAfter > 0 {
+			tooManyLoginAttempts(w, resp, retryAfter)
+			return
+		}
+		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(requestBody.Password))
+		if err != nil {
+			resp.SetResult(http.StatusForbidden, nil).
+				WithErrorDetails("The password is incorrect.")
+			return
+		}
+		s.succeedLogin(r, user.Email)
+		if user.IsTotpEnabled() {
+			resp.SetResult(http.StatusConflict, nil).
+				WithErrorDetails("Two-factor authentication is already enabled.")
+			return
+		}
+
+		// Store a new secret, which only takes effect once it's confirmed
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		user.TotpSecret = secret
+		err = userRepo.UpdateTotp(user)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, totpEnrollmentResponseBody{
+			Secret:          secret,
+			ProvisioningURI: totp.ProvisioningURI(secret, totpIssuer, user.Email),
+		})
+	}
+}
+
+func (s *Server) confirmTotp() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	recoveryCodeRepo := models.RecoveryCodeRepository{DB: s.DB}
+	type RequestBody struct {
+		Code string `json:"code" validate:"required"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := sessionClaims(resp, r)
+		if !ok {
+			return
+		}
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Fetch the User, who must have started to enroll
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusNotFound, nil)
+			return
+		}
+		if user.IsTotpEnabled() {
+			resp.SetResult(http.StatusConflict, nil).
+				WithErrorDetails("Two-factor authentication is already enabled.")
+			return
+		}
+		if user.TotpSecret == "" {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Two-factor authentication has not been set up yet.")
+			return
+		}
+
+		// Codes are guessed against the same throttles as logins
+		retryAfter, err := s.attemptLogin(r, user.Email)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if retryAfter > 0 {
+			tooManyLoginAttempts(w, resp, retryAfter)
+			return
+		}
+
+		// Verify the code, which proves their authenticator is set up
+		step, ok := totp.Validate(user.TotpSecret, requestBody.Code, time.Now())
+		if !ok {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("The code is incorrect.")
+			return
+		}
+		s.succeedLogin(r, user.Email)
+
+		// Issue the recovery codes & enable two-factor authentication
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = recoveryCodeRepo.ReplaceAllUserCodes(user.Id, hashes)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		enabledAt := time.Now()
+		user.TotpEnabledAt = &enabledAt
+		user.TotpLastUsedStep = step
+		err = userRepo.UpdateTotp(user)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// Let the user know, in case it wasn't them
+		s.Mailer.Send(mail.Message{
+			To:      user.Email,
+			Subject: "Two-factor authentication was enabled",
+			Body:    "Two-factor authentication was just enabled for your BeanPay account. You'll be asked for a code from your authenticator app whenever you login.",
+		})
+
+		// OK
+		resp.SetResult(http.StatusOK, recoveryCodesResponseBody{
+			RecoveryCodes: codes,
+		})
+	}
+}
+
+func (s *Server) disableTotp() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	recoveryCodeRepo := models.RecoveryCodeRepository{DB: s.DB}
+	type RequestBody struct {
+		Password     string `json:"password" validate:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := sessionClaims(resp, r)
+		if !ok {
+			return
+		}
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+		if requestBody.Code == "" && requestBody.RecoveryCode == "" {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Either a code or a recovery code is required.")
+			return
+		}
+
+		// Fetch the User & re-verify both of their factors
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusNotFound, nil)
+			return
+		}
+		if !user.IsTotpEnabled() {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Two-factor authentication is not enabled.")
+			return
+		}
+
+		// Codes & passwords are guessed against the same throttles as logins
+		retryAfter, err := s.attemptLogin(r, user.Email)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if retryAfter > 0 {
+			tooManyLoginAttempts(w, resp, retryAfter)
+			return
+		}
+		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(requestBody.Password))
+		if err != nil {
+			resp.SetResult(http.StatusForbidden, nil).
+				WithErrorDetails("The password is incorrect.")
+			return
+		}
+		ok, err = s.verifySecondFactor(user, requestBody.Code, requestBody.RecoveryCode)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if !ok {
+			resp.SetResult(http.StatusForbidden, nil).
+				WithErrorDetails("The code is incorrect.")
+			return
+		}
+		s.succeedLogin(r, user.Email)
+
+		// Disable two-factor authentication
+		user.TotpSecret = ""
+		user.TotpEnabledAt = nil
+		user.TotpLastUsedStep = 0
+		err = userRepo.UpdateTotp(user)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = recoveryCodeRepo.DeleteAllUserCodes(user.Id)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// Let the user know, in case it wasn't them
+		s.Mailer.Send(mail.Message{
+			To:      user.Email,
+			Subject: "Two-factor authentication was disabled",
+			Body:    "Two-factor authentication was just disabled for your BeanPay account.\n\nIf this wasn't you, reset your password right away.",
+		})
+
+		// OK
+		resp.SetResult(http.StatusOK, nil)
+	}
+}
+
+func (s *Server) regenerateRecoveryCodes() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	recoveryCodeRepo := models.RecoveryCodeRepository{DB: s.DB}
+	type RequestBody struct {
+		Code string `json:"code" validate:"required"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := sessionClaims(resp, r)
+		if !ok {
+			return
+		}
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Fetch the User & verify their TOTP code
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusNotFound, nil)
+			return
+		}
+		if !user.IsTotpEnabled() {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Two-factor authentication is not enabled.")
+			return
+		}
+
+		// Codes are guessed against the same throttles as logins
+		retryAfter, err := s.attemptLogin(r, user.Email)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if retryAfter > 0 {
+			tooManyLoginAttempts(w, resp, retryAfter)
+			return
+		}
+		ok, err = s.verifySecondFactor(user, requestBody.Code, "")
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if !ok {
+			resp.SetResult(http.StatusForbidden, nil).
+				WithErrorDetails("The code is incorrect.")
+			return
+		}
+		s.succeedLogin(r, user.Email)
+
+		// Replace their recovery codes
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = recoveryCodeRepo.ReplaceAllUserCodes(user.Id, hashes)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, recoveryCodesResponseBody{
+			RecoveryCodes: codes,
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/jwt"
+	"github.com/beanpay/api/server/middleware"
+	"github.com/beanpay/api/server/throttle"
+	"github.com/beanpay/api/server/totp"
+	"github.com/generalledger/response"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	assert.Nil(t, err)
+	assert.Equal(t, recoveryCodeCount, len(codes))
+	assert.Equal(t, recoveryCodeCount, len(hashes))
+	for i, code := range codes {
+		assert.Regexp(t, "^[a-z2-7]{5}-[a-z2-7]{5}$", code)
+		assert.Equal(t, hashes[i], hashRecoveryCode(code))
+	}
+
+	// Codes are matched however they're typed in
+	assert.Equal(t, hashRecoveryCode("abcde-fghij"), hashRecoveryCode("ABCDE FGHIJ"))
+	assert.Equal(t, hashRecoveryCode("abcde-fghij"), hashRecoveryCode("abcdefghij"))
+}
+
+// mfaLogin logs in with a password, & answers the challenge with a code or
+// recovery code, returning the response to the challenge.
+func mfaLogin(t *testing.T, server *TestServer, code string, recoveryCode string) response.Response {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: realUserEmail, Password: realUserPassword})
+	server.login()(recorder, req)
+	resp := response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, true, resp.Result.(map[string]interface{})["mfa_required"])
+	assert.Nil(t, resp.Result.(map[string]interface{})["access_token"])
+	mfaToken := resp.Result.(map[string]interface{})["mfa_token"].(string)
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login/mfa", UpdateUserBody{
+		"mfa_token":     mfaToken,
+		"code":          code,
+		"recovery_code": recoveryCode,
+	})
+	server.loginMfa()(recorder, req)
+	return response.Parse(recorder.Result().Body)
+}
+
+func TestTotp(t *testing.T) {
+	// Prepare the Server & a logged in user
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	userId, _ := seedLoggedInUser(t, server, realUserEmail, realUserPassword)
+	userRepo := models.UserRepository{DB: server.DB}
+
+	// Enrolling requires the password
+	recorder := httptest.NewRecorder()
+	req := server.NewAuthenticatedRequest(http.MethodPost, "/users/me/mfa/totp", userId, UpdateUserBody{"password": "not-the-password"})
+	server.enrollTotp()(recorder, req)
+	assert.Equal(t, http.StatusForbidden, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Enroll, which returns the secret to add to an authenticator app
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/mfa/totp", userId, UpdateUserBody{"password": realUserPassword})
+	server.enrollTotp()(recorder, req)
+	resp := response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	secret := resp.Result.(map[string]interface{})["secret"].(string)
+	assert.Equal(t,
+		totp.ProvisioningURI(secret, "BeanPay", realUserEmail),
+		resp.Result.(map[string]interface{})["provisioning_uri"],
+	)
+
+	// Until it's confirmed, logins don't need a code
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: realUserEmail, Password: realUserPassword})
+	server.login()(recorder, req)
+	assert.NotNil(t, response.Parse(recorder.Result().Body).Result.(map[string]interface{})["access_token"])
+
+	// Confirming requires a valid code
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/mfa/totp/confirm", userId, UpdateUserBody{"code": "000000"})
+	server.confirmTotp()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusBadRequest,
+			StatusText:   http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{"The code is incorrect."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Confirm, which returns the recovery codes
+	code, err := totp.Code(secret, time.Now())
+	assert.Nil(t, err)
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/mfa/totp/confirm", userId, UpdateUserBody{"code": code})
+	server.confirmTotp()(recorder, req)
+	resp = response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	recoveryCodes := resp.Result.(map[string]interface{})["recovery_codes"].([]interface{})
+	assert.Equal(t, recoveryCodeCount, len(recoveryCodes))
+	user, err := userRepo.FetchByID(userId)
+	assert.Nil(t, err)
+	assert.True(t, user.IsTotpEnabled())
+	message, _ := server.Outbox().Last()
+	assert.Equal(t, "Two-factor authentication was enabled", message.Subject)
+
+	// Ensure logins are challenged, & that the challenge can't be used as
+	// an access token
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: realUserEmail, Password: realUserPassword})
+	server.login()(recorder, req)
+	mfaToken := response.Parse(recorder.Result().Body).Result.(map[string]interface{})["mfa_token"].(string)
+	_, err = server.JwtSignatory.ParseToken(mfaToken)
+	assert.NotNil(t, err)
+
+	// Ensure the challenge must be answered with a valid, unused code
+	assert.Equal(t, http.StatusUnauthorized, mfaLogin(t, server, "000000", "").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, mfaLogin(t, server, code, "").StatusCode)
+	assert.Equal(t,
+		&[]string{"Either a code or a recovery code is required."},
+		mfaLogin(t, server, "", "").ErrorDetails,
+	)
+
+	// Login with the next code
+	nextCode, _ := totp.Code(secret, time.Now().Add(totp.Period))
+	resp = mfaLogin(t, server, nextCode, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotNil(t, resp.Result.(map[string]interface{})["access_token"])
+
+	// Login with a recovery code, which can only be used once
+	recoveryCode := strings.ToUpper(recoveryCodes[0].(string))
+	assert.Equal(t, http.StatusOK, mfaLogin(t, server, "", recoveryCode).StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, mfaLogin(t, server, "", recoveryCode).StatusCode)
+
+	// Regenerate the recovery codes, which replaces the old ones
+	user, _ = userRepo.FetchByID(userId)
+	user.TotpLastUsedStep = 0
+	assert.Nil(t, userRepo.UpdateTotp(user))
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/mfa/recovery-codes", userId, UpdateUserBody{"code": code})
+	server.regenerateRecoveryCodes()(recorder, req)
+	resp = response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	newRecoveryCodes := resp.Result.(map[string]interface{})["recovery_codes"].([]interface{})
+	assert.Equal(t, http.StatusUnauthorized, mfaLogin(t, server, "", recoveryCodes[1].(string)).StatusCode)
+
+	// Disabling requires the password & a code
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodDelete, "/users/me/mfa/totp", userId, UpdateUserBody{
+		"password":      "not-the-password",
+		"recovery_code": newRecoveryCodes[0],
+	})
+	server.disableTotp()(recorder, req)
+	assert.Equal(t, http.StatusForbidden, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodDelete, "/users/me/mfa/totp", userId, UpdateUserBody{
+		"password":      realUserPassword,
+		"recovery_code": "not-a-code",
+	})
+	server.disableTotp()(recorder, req)
+	assert.Equal(t, http.StatusForbidden, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Disable it
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodDelete, "/users/me/mfa/totp", userId, UpdateUserBody{
+		"password":      realUserPassword,
+		"recovery_code": newRecoveryCodes[0],
+	})
+	server.disableTotp()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	user, _ = userRepo.FetchByID(userId)
+	assert.False(t, user.IsTotpEnabled())
+	assert.Equal(t, "", user.TotpSecret)
+
+	// Ensure logins aren't challenged anymore
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: realUserEmail, Password: realUserPassword})
+	server.login()(recorder, req)
+	assert.NotNil(t, response.Parse(recorder.Result().Body).Result.(map[string]interface{})["access_token"])
+}
+
+// mfaStatus makes an authenticated request of an MFA handler, returning
+// the status code.
+func mfaStatus(server *TestServer, handler http.HandlerFunc, method string, userId string, body UpdateUserBody) int {
+	recorder := httptest.NewRecorder()
+	req := server.NewAuthenticatedRequest(method, "/users/me/mfa", userId, body)
+	handler(recorder, req)
+	return response.Parse(recorder.Result().Body).StatusCode
+}
+
+func TestTotpThrottle(t *testing.T) {
+	// Prepare the Server & a logged in user, throttling accounts after 2
+	// failures
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	userId, _ := seedLoggedInUser(t, server, realUserEmail, realUserPassword)
+	server.AccountLoginThrottle = &throttle.Throttle{Store: throttle.NewMemoryStore(), Allowed: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	accountKey := "account:" + realUserEmail
+
+	// API keys can't be used to manage two-factor authentication
+	apiKey := seedApiKey(t, server, userId)
+	requireAuth := middleware.GetRequireAuthMiddleware(server.JwtSignatory, server.authenticateApiKey)
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/users/me/mfa/totp", UpdateUserBody{"password": realUserPassword})
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	requireAuth(server.enrollTotp(), jwt.ScopeWrite)(recorder, req)
+	assert.Equal(t, http.StatusForbidden, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Guessing the password to enroll is throttled
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusForbidden, mfaStatus(server, server.enrollTotp(), http.MethodPost, userId, UpdateUserBody{"password": "not-the-password"}))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, mfaStatus(server, server.enrollTotp(), http.MethodPost, userId, UpdateUserBody{"password": realUserPassword}))
+	assert.Nil(t, server.AccountLoginThrottle.Reset(accountKey))
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/mfa/totp", userId, UpdateUserBody{"password": realUserPassword})
+	server.enrollTotp()(recorder, req)
+	secret := response.Parse(recorder.Result().Body).Result.(map[string]interface{})["secret"].(string)
+
+	// As is guessing the code to confirm it
+	code, err := totp.Code(secret, time.Now())
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusBadRequest, mfaStatus(server, server.confirmTotp(), http.MethodPost, userId, UpdateUserBody{"code": "000000"}))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, mfaStatus(server, server.confirmTotp(), http.MethodPost, userId, UpdateUserBody{"code": code}))
+	assert.Nil(t, server.AccountLoginThrottle.Reset(accountKey))
+	assert.Equal(t, http.StatusOK, mfaStatus(server, server.confirmTotp(), http.MethodPost, userId, UpdateUserBody{"code": code}))
+
+	// Or the code to regenerate the recovery codes
+	nextCode, _ := totp.Code(secret, time.Now().Add(totp.Period))
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusForbidden, mfaStatus(server, server.regenerateRecoveryCodes(), http.MethodPost, userId, UpdateUserBody{"code": "000000"}))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, mfaStatus(server, server.regenerateRecoveryCodes(), http.MethodPost, userId, UpdateUserBody{"code": nextCode}))
+
+	// Or the factors to disable it
+	assert.Nil(t, server.AccountLoginThrottle.Reset(accountKey))
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusForbidden, mfaStatus(server, server.disableTotp(), http.MethodDelete, userId, UpdateUserBody{"password": realUserPassword, "code": "000000"}))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, mfaStatus(server, server.disableTotp(), http.MethodDelete, userId, UpdateUserBody{"password": realUserPassword, "code": nextCode}))
+	userRepo := models.UserRepository{DB: server.DB}
+	user, err := userRepo.FetchByID(userId)
+	assert.Nil(t, err)
+	assert.True(t, user.IsTotpEnabled())
+}
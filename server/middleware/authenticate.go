@@ -8,23 +8,41 @@ import (
 	"strings"
 )
 
+// ApiKeyAuthenticator returns the Claims of the user an API key belongs to.
+type ApiKeyAuthenticator func(key string) (*jwt.Claims, error)
+
 // GetRequireAuthMiddleware returns the middleware function for Authenticate.
 // The reason why we have split this out is because there is an
 // external dependency (jwt.JwtSignatory) that we don't want to
 // have to pass in every time we use this middleware.
-func GetRequireAuthMiddleware(jwtSignatory *jwt.JwtSignatory) func(http.HandlerFunc) http.HandlerFunc {
-	return func(next http.HandlerFunc) http.HandlerFunc {
+//
+// Bearer tokens that aren't access tokens are tried as API keys with the
+// apiKeyAuthenticator, unless it's nil. Either way, the token must have been
+// granted every one of the scopes, otherwise the request is Forbidden.
+func GetRequireAuthMiddleware(jwtSignatory *jwt.JwtSignatory, apiKeyAuthenticator ApiKeyAuthenticator) func(http.HandlerFunc, ...string) http.HandlerFunc {
+	return func(next http.HandlerFunc, scopes ...string) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			resp := response.New(w)
 
 			// Parse & validate the token
 			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 			claims, err := jwtSignatory.ParseToken(token)
+			if err != nil && apiKeyAuthenticator != nil {
+				claims, err = apiKeyAuthenticator(token)
+			}
 			if err != nil {
 				resp.SetResult(http.StatusUnauthorized, nil).Output()
 				return
 			}
 
+			// Ensure the token was granted the scopes
+			if !claims.HasScopes(scopes...) {
+				resp.SetResult(http.StatusForbidden, nil).
+					WithErrorDetails("The token hasn't been granted the " + strings.Join(scopes, " & ") + " scope.").
+					Output()
+				return
+			}
+
 			// Serve next with Claims context
 			ctx := context.WithValue(r.Context(), "jwtClaims", *claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
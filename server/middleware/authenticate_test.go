@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"github.com/beanpay/api/server/jwt"
 	"github.com/generalledger/response"
 	"github.com/stretchr/testify/assert"
@@ -16,7 +17,7 @@ var (
 	jwtSignatory = &jwt.JwtSignatory{
 		SigningKey: []byte("some-key"),
 	}
-	requireAuth = GetRequireAuthMiddleware(jwtSignatory)
+	requireAuth = GetRequireAuthMiddleware(jwtSignatory, nil)
 )
 
 // A simple http.HandlerFunc which is wrapped in our Authenticate
@@ -80,7 +81,7 @@ func TestAuthenticateMiddlewareIncorrectAuth(t *testing.T) {
 // the request chain appropriately.
 func TestAuthenticateMiddlewareSuccess(t *testing.T) {
 	// Generate a JWT Token signed by the same signatory that our Authenticate middleware uses
-	jwtToken, err := jwtSignatory.GenerateSignedToken("some-user-id", time.Now().Add(time.Second*10))
+	jwtToken, err := jwtSignatory.GenerateSignedToken("some-user-id", jwt.AllScopes, time.Now().Add(time.Second*10))
 	assert.Nil(t, err)
 
 	// Verify request is OK
@@ -107,7 +108,7 @@ func TestAuthenticateMiddlewareIncorrectlySignedToken(t *testing.T) {
 	var nefariousJwtSignatory = &jwt.JwtSignatory{
 		SigningKey: []byte("incorrect-key"),
 	}
-	jwtToken, err := nefariousJwtSignatory.GenerateSignedToken("some-user-id", time.Now().Add(time.Second*10))
+	jwtToken, err := nefariousJwtSignatory.GenerateSignedToken("some-user-id", jwt.AllScopes, time.Now().Add(time.Second*10))
 	assert.Nil(t, err)
 
 	// Verify request is OK
@@ -125,3 +126,76 @@ func TestAuthenticateMiddlewareIncorrectlySignedToken(t *testing.T) {
 		response.Parse(recorder.Result().Body),
 	)
 }
+
+// TestAuthenticateMiddlewareScopes tests that the middleware rejects tokens
+// that haven't been granted the scopes a route requires.
+func TestAuthenticateMiddlewareScopes(t *testing.T) {
+	handler := requireAuth(
+		func(w http.ResponseWriter, r *http.Request) {
+			response.New(w).SetResult(http.StatusOK, nil).Output()
+		},
+		jwt.ScopeWrite,
+	)
+
+	// A read-only token is Forbidden
+	jwtToken, err := jwtSignatory.GenerateSignedToken("some-user-id", []string{jwt.ScopeRead}, time.Now().Add(time.Second*10))
+	assert.Nil(t, err)
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+jwtToken)
+	handler(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusForbidden,
+			StatusText:   http.StatusText(http.StatusForbidden),
+			ErrorDetails: &[]string{"The token hasn't been granted the write scope."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// A token with both scopes is OK
+	jwtToken, err = jwtSignatory.GenerateSignedToken("some-user-id", jwt.AllScopes, time.Now().Add(time.Second*10))
+	assert.Nil(t, err)
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+jwtToken)
+	handler(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+}
+
+// TestAuthenticateMiddlewareApiKey tests that bearer tokens which aren't
+// access tokens are authenticated as API keys.
+func TestAuthenticateMiddlewareApiKey(t *testing.T) {
+	handler := GetRequireAuthMiddleware(jwtSignatory, func(key string) (*jwt.Claims, error) {
+		if key != "some-api-key" {
+			return nil, errors.New("Unknown API key")
+		}
+		return &jwt.Claims{UserID: "some-user-id", Scope: jwt.ScopeRead, ApiKeyId: "some-api-key-id"}, nil
+	})
+	for _, test := range []struct {
+		token      string
+		scope      string
+		statusCode int
+	}{
+		{token: "some-api-key", scope: jwt.ScopeRead, statusCode: http.StatusOK},
+		{token: "some-api-key", scope: jwt.ScopeWrite, statusCode: http.StatusForbidden},
+		{token: "unknown-api-key", scope: jwt.ScopeRead, statusCode: http.StatusUnauthorized},
+	} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		handler(
+			func(w http.ResponseWriter, r *http.Request) {
+				claims := r.Context().Value("jwtClaims").(jwt.Claims)
+				response.New(w).SetResult(http.StatusOK, claims.ApiKeyId).Output()
+			},
+			test.scope,
+		)(recorder, req)
+		resp := response.Parse(recorder.Result().Body)
+		assert.Equal(t, test.statusCode, resp.StatusCode)
+		if test.statusCode == http.StatusOK {
+			assert.Equal(t, "some-api-key-id", resp.Result)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the IP address the request was made from. Behind a
+// reverse proxy, that's only the client's once the request has been through
+// the middleware of GetTrustedProxiesMiddleware, otherwise every client
+// shares the proxy's.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ParseTrustedProxies parses a comma separated list of the IP addresses &
+// CIDR ranges of the reverse proxies in front of the server, e.g.
+// 10.0.0.0/8,192.0.2.1.
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	trustedProxies := make([]*net.IPNet, 0)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid trusted proxy: %v", entry)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			trustedProxies = append(trustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy: %v", entry)
+		}
+		trustedProxies = append(trustedProxies, ipNet)
+	}
+	return trustedProxies, nil
+}
+
+// isTrustedProxy returns whether the ip is one of the trustedProxies.
+func isTrustedProxy(trustedProxies []*net.IPNet, ip net.IP) bool {
+	for _, trustedProxy := range trustedProxies {
+		if trustedProxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTrustedProxiesMiddleware returns the middleware that sets the
+// RemoteAddr of requests that came through one of the trustedProxies to
+// the client they were forwarded for. That's the last address in
+// X-Forwarded-For that isn't a trusted proxy itself, as a client can put
+// whatever it likes in front of it.
+//
+// Requests from anywhere else are left alone, so X-Forwarded-For is ignored
+// altogether when there are no trustedProxies, which is what a server
+// that's exposed directly to clients needs.
+func GetTrustedProxiesMiddleware(trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			remoteIP := net.ParseIP(ClientIP(r))
+			if remoteIP == nil || !isTrustedProxy(trustedProxies, remoteIP) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Walk back through the proxies the request was forwarded by
+			forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+			clientIP := remoteIP
+			for i := len(forwardedFor) - 1; i >= 0; i-- {
+				ip := net.ParseIP(strings.TrimSpace(forwardedFor[i]))
+				if ip == nil {
+					break
+				}
+				clientIP = ip
+				if !isTrustedProxy(trustedProxies, ip) {
+					break
+				}
+			}
+			r = r.Clone(r.Context())
+			r.RemoteAddr = clientIP.String()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies(" 10.0.0.0/8, 192.0.2.1,2001:db8::1 ")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(trustedProxies))
+	assert.Equal(t, "10.0.0.0/8", trustedProxies[0].String())
+	assert.Equal(t, "192.0.2.1/32", trustedProxies[1].String())
+	assert.Equal(t, "2001:db8::1/128", trustedProxies[2].String())
+
+	// Nothing is trusted by default
+	trustedProxies, err = ParseTrustedProxies("")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(trustedProxies))
+
+	// Ensure invalid entries are rejected
+	for _, list := range []string{"not-an-ip", "10.0.0.0/33", "10.0.0.1,nope"} {
+		_, err = ParseTrustedProxies(list)
+		assert.NotNil(t, err, list)
+	}
+}
+
+// forwardedClientIP returns the ClientIP that a request from remoteAddr,
+// forwarded for forwardedFor, reaches the handler with.
+func forwardedClientIP(trustedProxies string, remoteAddr string, forwardedFor ...string) string {
+	parsed, _ := ParseTrustedProxies(trustedProxies)
+	clientIP := ""
+	handler := GetTrustedProxiesMiddleware(parsed)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP = ClientIP(r)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	for _, value := range forwardedFor {
+		req.Header.Add("X-Forwarded-For", value)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	return clientIP
+}
+
+func TestTrustedProxiesMiddleware(t *testing.T) {
+	// Without trusted proxies, X-Forwarded-For is ignored
+	assert.Equal(t, "192.0.2.1", forwardedClientIP("", "192.0.2.1:1234", "198.51.100.7"))
+
+	// As it is from clients that aren't trusted proxies
+	assert.Equal(t, "192.0.2.1", forwardedClientIP("10.0.0.0/8", "192.0.2.1:1234", "198.51.100.7"))
+
+	// Requests through a trusted proxy are from the client it forwarded for
+	assert.Equal(t, "198.51.100.7", forwardedClientIP("10.0.0.0/8", "10.0.0.2:1234", "198.51.100.7"))
+	assert.Equal(t, "2001:db8::7", forwardedClientIP("10.0.0.0/8", "10.0.0.2:1234", "2001:db8::7"))
+
+	// Which is the last address that isn't a trusted proxy, as clients can
+	// send addresses of their own in front of it
+	assert.Equal(t, "198.51.100.7", forwardedClientIP("10.0.0.0/8", "10.0.0.2:1234", "203.0.113.9, 198.51.100.7, 10.0.0.3"))
+	assert.Equal(t, "198.51.100.7", forwardedClientIP("10.0.0.0/8", "10.0.0.2:1234", "203.0.113.9", "198.51.100.7, 10.0.0.3"))
+
+	// Garbage stops the walk at the last address that could be parsed
+	assert.Equal(t, "10.0.0.3", forwardedClientIP("10.0.0.0/8", "10.0.0.2:1234", "198.51.100.7, not-an-ip, 10.0.0.3"))
+
+	// Without X-Forwarded-For, the request is from the proxy itself
+	assert.Equal(t, "10.0.0.2", forwardedClientIP("10.0.0.0/8", "10.0.0.2:1234"))
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCorsPreflight tests that preflight requests are answered for every
+// method the API is routed on, without reaching the handler.
+func TestCorsPreflight(t *testing.T) {
+	reached := false
+	handler := Cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodOptions, "/users/me", nil)
+	req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.False(t, reached)
+	allowedMethods := strings.Split(recorder.Header().Get("Access-Control-Allow-Methods"), ", ")
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		assert.Contains(t, allowedMethods, method)
+	}
+	assert.Contains(t, recorder.Header().Get("Access-Control-Allow-Headers"), CSRFHeader)
+}
+
+// TestCorsRequest tests that other requests reach the handler.
+func TestCorsRequest(t *testing.T) {
+	reached := false
+	handler := Cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPatch, "/users/me", nil)
+	handler.ServeHTTP(recorder, req)
+	assert.True(t, reached)
+	assert.Equal(t, "true", recorder.Header().Get("Access-Control-Allow-Credentials"))
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"github.com/generalledger/response"
+	"net/http"
+)
+
+// CSRFHeader must be sent with every request that's authenticated by the
+// refresh_token cookie. A cross-origin form can't set a header, & a
+// cross-origin script can only set one after a CORS preflight, which only
+// APP_URL passes.
+const CSRFHeader = "X-CSRF-Protection"
+
+// GetRequireCSRFMiddleware returns the middleware that protects routes that
+// are authenticated by the refresh_token cookie from cross-site request
+// forgery. Requests with the cookie have to send the CSRFHeader, & when
+// they have an Origin, it has to be the allowedOrigin.
+//
+// Requests without the cookie, such as those of native clients that send
+// their refresh token in the body, can't be forged by another site, so they
+// are let through.
+func GetRequireCSRFMiddleware(allowedOrigin string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			_, err := r.Cookie("refresh_token")
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Ensure the request came from our app
+			origin := r.Header.Get("Origin")
+			if origin != "" && origin != allowedOrigin {
+				response.New(w).
+					SetResult(http.StatusForbidden, nil).
+					WithErrorDetails("Cross-origin requests are not allowed.").
+					Output()
+				return
+			}
+			if r.Header.Get(CSRFHeader) == "" {
+				response.New(w).
+					SetResult(http.StatusForbidden, nil).
+					WithErrorDetails("The " + CSRFHeader + " header is required.").
+					Output()
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"github.com/generalledger/response"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A simple http.HandlerFunc which is wrapped in our CSRF middleware, which
+// allows requests from https://app.example.com.
+func csrfProtectedHandler() http.HandlerFunc {
+	return GetRequireCSRFMiddleware("https://app.example.com")(
+		func(w http.ResponseWriter, r *http.Request) {
+			response.New(w).SetResult(http.StatusOK, nil).Output()
+		},
+	)
+}
+
+// TestCSRFMiddlewareFormPost tests that a form on another site, posting
+// with the user's cookie, is rejected.
+func TestCSRFMiddlewareFormPost(t *testing.T) {
+	for _, origin := range []string{"https://evil.example.com", "null", ""} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/auth/logout", strings.NewReader("a=b"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "some-refresh-token"})
+		csrfProtectedHandler()(recorder, req)
+		assert.Equal(t, http.StatusForbidden, response.Parse(recorder.Result().Body).StatusCode, origin)
+	}
+}
+
+// TestCSRFMiddlewareCrossOriginHeader tests that the header alone isn't
+// enough when the request came from another site.
+func TestCSRFMiddlewareCrossOriginHeader(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set(CSRFHeader, "1")
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "some-refresh-token"})
+	csrfProtectedHandler()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusForbidden,
+			StatusText:   http.StatusText(http.StatusForbidden),
+			ErrorDetails: &[]string{"Cross-origin requests are not allowed."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+}
+
+// TestCSRFMiddlewareSuccess tests that our app, & clients that don't use
+// the cookie, are let through.
+func TestCSRFMiddlewareSuccess(t *testing.T) {
+	// Our app, which sends the header
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set(CSRFHeader, "1")
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "some-refresh-token"})
+	csrfProtectedHandler()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+
+	// A native client, which sends its refresh token in the body
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/refresh", strings.NewReader(`{"refresh_token":"some-refresh-token"}`))
+	csrfProtectedHandler()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// bufferedResponseWriter holds on to everything written to it, so the
+// response can be inspected before it is sent to the client.
+type bufferedResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponseWriter) WriteHeader(statusCode int) {
+	b.statusCode = statusCode
+}
+
+// ETag tags successful responses with a strong ETag derived from the
+// response body, and replies with 304 Not Modified when the client
+// already holds the same representation (via If-None-Match).
+func ETag(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buffered := &bufferedResponseWriter{
+			header:     w.Header(),
+			statusCode: http.StatusOK,
+		}
+		next.ServeHTTP(buffered, r)
+
+		// Only tag successful responses
+		if buffered.statusCode != http.StatusOK {
+			w.WriteHeader(buffered.statusCode)
+			w.Write(buffered.body.Bytes())
+			return
+		}
+
+		// Compare against what the client already has
+		sum := sha256.Sum256(buffered.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if r.Header.Get("If-None-Match") == etag {
+			w.Header().Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(buffered.body.Bytes())
+	}
+}
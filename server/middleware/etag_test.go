@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"github.com/generalledger/response"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A simple http.HandlerFunc which is wrapped in our ETag middleware,
+// that responds with whatever status is passed in the query.
+func etaggedHandler() http.HandlerFunc {
+	return ETag(
+		func(w http.ResponseWriter, r *http.Request) {
+			resp := response.New(w)
+			defer resp.Output()
+			if r.URL.Query().Get("fail") != "" {
+				resp.SetResult(http.StatusBadRequest, nil)
+				return
+			}
+			resp.SetResult(http.StatusOK, "some-result")
+		},
+	)
+}
+
+// TestETagMiddleware tests that successful responses are tagged, and that
+// a matching If-None-Match header results in a 304 without a body.
+func TestETagMiddleware(t *testing.T) {
+	// Fetch the resource & capture its ETag
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	etaggedHandler()(recorder, req)
+	etag := recorder.Result().Header.Get("ETag")
+	assert.NotEqual(t, "", etag)
+	assert.Equal(t, "private, no-cache", recorder.Result().Header.Get("Cache-Control"))
+	assert.Equal(t,
+		response.Response{
+			StatusCode: http.StatusOK,
+			StatusText: http.StatusText(http.StatusOK),
+			Result:     "some-result",
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Fetching the resource again yields the same ETag
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	etaggedHandler()(recorder, req)
+	assert.Equal(t, etag, recorder.Result().Header.Get("ETag"))
+
+	// Fetching with a matching If-None-Match returns a 304
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	etaggedHandler()(recorder, req)
+	assert.Equal(t, http.StatusNotModified, recorder.Result().StatusCode)
+	assert.Equal(t, 0, recorder.Body.Len())
+
+	// Fetching with a stale If-None-Match returns the full response
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	etaggedHandler()(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	assert.NotEqual(t, 0, recorder.Body.Len())
+
+	// Unsuccessful responses are passed through untagged
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/?fail=true", nil)
+	etaggedHandler()(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Result().StatusCode)
+	assert.Equal(t, "", recorder.Result().Header.Get("ETag"))
+}
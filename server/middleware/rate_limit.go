@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"github.com/beanpay/api/server/jwt"
+	"github.com/generalledger/response"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitPruneInterval is how many requests are let through between
+// sweeps of the buckets that have refilled.
+const rateLimitPruneInterval = 1000
+
+// bucket holds the tokens a key has left, as of when it was last updated.
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// RateLimiter is a token bucket for each user, or for each client IP
+// address on routes that don't require auth. Behind a reverse proxy, the
+// ClientIP is only the client's when the proxy is one of the server's
+// TrustedProxies. A bucket holds up to Limit
+// tokens, each request takes one, & an empty bucket refills over Period.
+//
+// Every route gets its own RateLimiter, so that its limit is declared right
+// where the route is registered.
+type RateLimiter struct {
+	Limit  int
+	Period time.Duration
+
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	requests int
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows limit requests per period.
+func NewRateLimiter(limit int, period time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Limit:   limit,
+		Period:  period,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// rateLimitKey returns the key of the bucket a request takes from, which is
+// the user's when the request was authenticated.
+func rateLimitKey(r *http.Request) string {
+	claims, ok := r.Context().Value("jwtClaims").(jwt.Claims)
+	if ok {
+		return "user:" + claims.UserID
+	}
+	return "ip:" + ClientIP(r)
+}
+
+// take takes a token from the key's bucket, returning whether there was one,
+// how many are left, & how long until the bucket is full again. When there
+// wasn't one, retryAfter is how long until there is.
+func (l *RateLimiter) take(key string) (ok bool, remaining int, reset time.Duration, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	rate := float64(l.Limit) / l.Period.Seconds()
+
+	// Forget the buckets that have refilled every so often, so that they
+	// don't pile up
+	l.requests++
+	if l.requests%rateLimitPruneInterval == 0 {
+		for k, b := range l.buckets {
+			if now.Sub(b.updatedAt) >= l.Period {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	// Refill the bucket for the time that's passed, & take a token from it
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(l.Limit), updatedAt: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.Limit), b.tokens+now.Sub(b.updatedAt).Seconds()*rate)
+	b.updatedAt = now
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	} else {
+		retryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	reset = time.Duration((float64(l.Limit) - b.tokens) / rate * float64(time.Second))
+	return ok, int(b.tokens), reset, retryAfter
+}
+
+// seconds rounds a duration up to whole seconds, for a header.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// RateLimit is the middleware, which responds with Too Many Requests once a
+// bucket is empty. Every response carries the RateLimit-Limit,
+// RateLimit-Remaining & RateLimit-Reset headers, so that clients can slow
+// down before they're limited.
+func (l *RateLimiter) RateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, remaining, reset, retryAfter := l.take(rateLimitKey(r))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(l.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", seconds(reset))
+		if !ok {
+			w.Header().Set("Retry-After", seconds(retryAfter))
+			response.New(w).
+				SetResult(http.StatusTooManyRequests, nil).
+				WithErrorDetails("Too many requests. Please try again later.").
+				Output()
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"github.com/beanpay/api/server/jwt"
+	"github.com/generalledger/response"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	now := time.Date(2020, 5, 12, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(3, time.Minute)
+	limiter.now = func() time.Time { return now }
+	handler := limiter.RateLimit(func(w http.ResponseWriter, r *http.Request) {
+		response.New(w).SetResult(http.StatusOK, nil).Output()
+	})
+	request := func(remoteAddr string, userId string) *http.Response {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/bills", nil)
+		req.RemoteAddr = remoteAddr
+		if userId != "" {
+			req = req.WithContext(context.WithValue(req.Context(), "jwtClaims", jwt.Claims{UserID: userId}))
+		}
+		handler(recorder, req)
+		return recorder.Result()
+	}
+
+	// Use up the bucket of a client
+	for remaining := 2; remaining >= 0; remaining-- {
+		result := request("192.0.2.1:1234", "")
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "3", result.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, strconv.Itoa(remaining), result.Header.Get("RateLimit-Remaining"))
+	}
+	result := request("192.0.2.1:1234", "")
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusTooManyRequests,
+			StatusText:   http.StatusText(http.StatusTooManyRequests),
+			ErrorDetails: &[]string{"Too many requests. Please try again later."},
+			Result:       nil,
+		},
+		response.Parse(result.Body),
+	)
+	assert.Equal(t, "0", result.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", result.Header.Get("RateLimit-Reset"))
+	assert.Equal(t, "20", result.Header.Get("Retry-After"))
+
+	// Other clients, & users, have their own buckets
+	assert.Equal(t, http.StatusOK, request("198.51.100.7:1234", "").StatusCode)
+	assert.Equal(t, http.StatusOK, request("192.0.2.1:1234", "some-user-id").StatusCode)
+
+	// The bucket refills a token at a time
+	now = now.Add(20 * time.Second)
+	result = request("192.0.2.1:1234", "")
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "0", result.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, http.StatusTooManyRequests, request("192.0.2.1:1234", "").StatusCode)
+
+	// Until it's full
+	now = now.Add(time.Hour)
+	result = request("192.0.2.1:1234", "")
+	assert.Equal(t, "2", result.Header.Get("RateLimit-Remaining"))
+}
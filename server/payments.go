@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"github.com/beanpay/api/database/models"
 	"github.com/beanpay/api/server/jwt"
+	"github.com/beanpay/api/server/validator"
 	"github.com/generalledger/response"
 	"github.com/lib/pq"
 	"net/http"
@@ -14,8 +15,9 @@ import (
 func (s *Server) fetchPayments() http.HandlerFunc {
 	paymentRepo := models.PaymentRepository{DB: s.DB}
 	type RequestParams struct {
-		From string `json:"from" validate:"required,datetime=2006-01-02"`
-		To   string `json:"to" validate:"required,datetime=2006-01-02"`
+		From     string `json:"from" validate:"required,datetime=2006-01-02"`
+		To       string `json:"to" validate:"required,datetime=2006-01-02"`
+		FilterBy string `json:"filter_by" validate:"omitempty,oneof=due_date paid_at"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		resp := response.New(w)
@@ -39,6 +41,10 @@ func (s *Server) fetchPayments() http.HandlerFunc {
 		if ok && (len(to) > 0) {
 			requestParams.To = to[0]
 		}
+		filterBy, ok := queryParams["filter_by"]
+		if ok && (len(filterBy) > 0) {
+			requestParams.FilterBy = filterBy[0]
+		}
 		messages, err := s.Validator.Validate(requestParams)
 		if err != nil {
 			resp.SetResult(http.StatusBadRequest, nil).
@@ -54,8 +60,13 @@ func (s *Server) fetchPayments() http.HandlerFunc {
 			return
 		}
 
-		// Fetch the Payments
-		payments, err := paymentRepo.FetchAllUserPayments(claims.UserID, fromDate, toDate)
+		// Fetch the Payments, by due date unless asked otherwise
+		var payments []*models.Payment
+		if requestParams.FilterBy == "paid_at" {
+			payments, err = paymentRepo.FetchAllUserPaymentsByPaidAt(claims.UserID, fromDate, toDate)
+		} else {
+			payments, err = paymentRepo.FetchAllUserPayments(claims.UserID, fromDate, toDate)
+		}
 		if err != nil {
 			resp.SetResult(http.StatusInternalServerError, nil)
 			return
@@ -112,12 +123,18 @@ func (s *Server) deletePayment() http.HandlerFunc {
 }
 
 func (s *Server) createPayment() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
 	billRepo := models.BillRepository{DB: s.DB}
 	paymentRepo := models.PaymentRepository{DB: s.DB}
 	type RequestBody struct {
-		BillId    string  `json:"bill_id" validate:"required"`
-		DueDate   string  `json:"due_date" validate:"required,datetime=2006-01-02"`
-		TotalPaid float64 `json:"total_paid" validate:"gte=0"`
+		BillId              string      `json:"bill_id" validate:"required"`
+		DueDate             string      `json:"due_date" validate:"required,datetime=2006-01-02"`
+		TotalPaid           json.Number `json:"total_paid" validate:"required,money"`
+		PaidAt              string      `json:"paid_at" validate:"omitempty,datetime=2006-01-02"`
+		PaymentMethod       string      `json:"payment_method" validate:"omitempty,oneof=bank_transfer card cash check direct_debit other"`
+		PaymentMethodDetail string      `json:"payment_method_detail" validate:"max=100"`
+		ConfirmationNumber  string      `json:"confirmation_number" validate:"max=100"`
+		Notes               string      `json:"notes" validate:"max=1000"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		resp := response.New(w)
@@ -151,6 +168,22 @@ func (s *Server) createPayment() http.HandlerFunc {
 			resp.SetResult(http.StatusInternalServerError, nil)
 			return
 		}
+		var paidAt time.Time
+		if requestBody.PaidAt != "" {
+			paidAt, err = time.Parse("2006-01-02", requestBody.PaidAt)
+			if err != nil {
+				resp.SetResult(http.StatusInternalServerError, nil)
+				return
+			}
+		} else {
+			// Payments are made today, in the user's time zone, by default
+			user, err := userRepo.FetchByID(claims.UserID)
+			if err != nil {
+				resp.SetResult(http.StatusInternalServerError, nil)
+				return
+			}
+			paidAt = localDate(time.Now(), user.Location())
+		}
 
 		// Fetch the associated bill to & verify that the user owns it
 		bill, err := billRepo.FetchByID(requestBody.BillId)
@@ -164,19 +197,33 @@ func (s *Server) createPayment() http.HandlerFunc {
 			return
 		}
 
+		// Payments are always made in the currency of the bill
+		totalPaid, err := models.ParseMoney(requestBody.TotalPaid.String(), bill.EstimatedTotalDue.Currency)
+		if err != nil {
+			// We just validated this would be in the right format, so if this
+			// error happens something is wrong with our Validator internals.
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
 		// Create a new Payment Record
 		newPayment := &models.Payment{
-			BillId:    bill.Id,
-			DueDate:   dueDate,
-			TotalPaid: requestBody.TotalPaid,
+			BillId:              bill.Id,
+			DueDate:             dueDate,
+			TotalPaid:           totalPaid,
+			PaidAt:              paidAt,
+			PaymentMethod:       requestBody.PaymentMethod,
+			PaymentMethodDetail: requestBody.PaymentMethodDetail,
+			ConfirmationNumber:  requestBody.ConfirmationNumber,
+			Notes:               requestBody.Notes,
 		}
 		err = paymentRepo.Insert(newPayment)
 		if err != nil {
 			pqErr, ok := err.(*pq.Error)
 			if ok {
-				if pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "payments_bill_id_due_date_key" {
+				if pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "payments_bill_id_confirmation_number_key" {
 					resp.SetResult(http.StatusConflict, nil).
-						WithErrorDetails("The bill has already been paid for the specified due date.")
+						WithErrorDetails("The confirmation number has already been used for another payment of this bill.")
 					return
 				}
 			}
@@ -188,3 +235,121 @@ func (s *Server) createPayment() http.HandlerFunc {
 		resp.SetResult(http.StatusOK, newPayment)
 	}
 }
+
+func (s *Server) updatePayment() http.HandlerFunc {
+	paymentRepo := models.PaymentRepository{DB: s.DB}
+	billRepo := models.BillRepository{DB: s.DB}
+	type RequestBody struct {
+		DueDate             string                     `json:"due_date" validate:"omitempty,datetime=2006-01-02"`
+		TotalPaid           json.Number                `json:"total_paid" validate:"omitempty,money"`
+		PaidAt              string                     `json:"paid_at" validate:"omitempty,datetime=2006-01-02"`
+		PaymentMethod       string                     `json:"payment_method" validate:"omitempty,oneof=bank_transfer card cash check direct_debit other"`
+		PaymentMethodDetail validator.Nullable[string] `json:"payment_method_detail" validate:"max=100"`
+		ConfirmationNumber  validator.Nullable[string] `json:"confirmation_number" validate:"max=100"`
+		Notes               validator.Nullable[string] `json:"notes" validate:"max=1000"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := r.Context().Value("jwtClaims").(jwt.Claims)
+		if !ok {
+			resp.SetResult(http.StatusUnauthorized, nil)
+			return
+		}
+
+		// Fetch the Payment
+		paymentId := strings.Split(r.URL.Path, "/")[2]
+		payment, err := paymentRepo.FetchByID(paymentId)
+		if err != nil {
+			resp.SetResult(http.StatusNotFound, nil)
+			return
+		}
+
+		// Fetch the associated bill to & verify that the user owns it
+		bill, err := billRepo.FetchByID(payment.BillId)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if bill.UserId != claims.UserID {
+			resp.SetResult(http.StatusForbidden, nil)
+			return
+		}
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err = json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Update the Payment
+		if requestBody.DueDate != "" {
+			dueDate, err := time.Parse("2006-01-02", requestBody.DueDate)
+			if err != nil {
+				// We just validated this would be in the right format, so if this
+				// error happens something is wrong with our Validator internals.
+				resp.SetResult(http.StatusInternalServerError, nil)
+				return
+			}
+			payment.DueDate = dueDate
+		}
+		if requestBody.TotalPaid != "" {
+			totalPaid, err := models.ParseMoney(requestBody.TotalPaid.String(), payment.TotalPaid.Currency)
+			if err != nil {
+				// We just validated this would be in the right format, so if this
+				// error happens something is wrong with our Validator internals.
+				resp.SetResult(http.StatusInternalServerError, nil)
+				return
+			}
+			payment.TotalPaid = totalPaid
+		}
+		if requestBody.PaidAt != "" {
+			paidAt, err := time.Parse("2006-01-02", requestBody.PaidAt)
+			if err != nil {
+				resp.SetResult(http.StatusInternalServerError, nil)
+				return
+			}
+			payment.PaidAt = paidAt
+		}
+		if requestBody.PaymentMethod != "" {
+			payment.PaymentMethod = requestBody.PaymentMethod
+		}
+		// The free text fields are cleared when they're sent as null or ""
+		if requestBody.PaymentMethodDetail.Set {
+			payment.PaymentMethodDetail = requestBody.PaymentMethodDetail.Value
+		}
+		if requestBody.ConfirmationNumber.Set {
+			payment.ConfirmationNumber = requestBody.ConfirmationNumber.Value
+		}
+		if requestBody.Notes.Set {
+			payment.Notes = requestBody.Notes.Value
+		}
+		err = paymentRepo.Update(payment)
+		if err != nil {
+			pqErr, ok := err.(*pq.Error)
+			if ok {
+				if pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "payments_bill_id_confirmation_number_key" {
+					resp.SetResult(http.StatusConflict, nil).
+						WithErrorDetails("The confirmation number has already been used for another payment of this bill.")
+					return
+				}
+			}
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, payment)
+	}
+}
@@ -9,12 +9,18 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 type PaymentRequestBody struct {
-	BillId    string  `json:"bill_id"`
-	DueDate   string  `json:"due_date"`
-	TotalPaid float64 `json:"total_paid"`
+	BillId              string  `json:"bill_id"`
+	DueDate             string  `json:"due_date"`
+	TotalPaid           float64 `json:"total_paid"`
+	PaidAt              string  `json:"paid_at,omitempty"`
+	PaymentMethod       string  `json:"payment_method,omitempty"`
+	PaymentMethodDetail string  `json:"payment_method_detail,omitempty"`
+	ConfirmationNumber  string  `json:"confirmation_number,omitempty"`
+	Notes               string  `json:"notes,omitempty"`
 }
 
 func (r *PaymentRequestBody) Read(p []byte) (n int, err error) {
@@ -92,6 +98,36 @@ func TestPaymentFetch(t *testing.T) {
 		},
 		response.Parse(recorder.Result().Body),
 	)
+
+	// Ensure that we can filter by the date the payments were made. The
+	// seeded payments are due in 10 days, but were paid today.
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().Add(time.Hour * 24).Format("2006-01-02")
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/payments?filter_by=paid_at&from="+today+"&to="+tomorrow, user1["id"].(string), nil)
+	server.fetchPayments()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusOK,
+			StatusText:   http.StatusText(http.StatusOK),
+			ErrorDetails: nil,
+			Result:       []interface{}{user1Payment},
+		},
+		response.Parse(recorder.Result().Body),
+	)
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/payments?filter_by=due_date&from="+today+"&to="+tomorrow, user1["id"].(string), nil)
+	server.fetchPayments()(recorder, req)
+	assert.Equal(t, []interface{}{}, response.Parse(recorder.Result().Body).Result)
+
+	// Ensure that we can only filter by supported dates
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/payments?filter_by=created_at&from="+today+"&to="+tomorrow, user1["id"].(string), nil)
+	server.fetchPayments()(recorder, req)
+	assert.Equal(t,
+		&[]string{"FilterBy must be one of [due_date paid_at]"},
+		response.Parse(recorder.Result().Body).ErrorDetails,
+	)
 }
 
 func TestPaymentDelete(t *testing.T) {
@@ -219,6 +255,43 @@ func TestPaymentCreate(t *testing.T) {
 		response.Parse(recorder.Result().Body),
 	)
 
+	// Test that we are rejecting amounts with fractions of a cent
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/payments", user1["id"].(string), &PaymentRequestBody{
+		BillId:    user1Bill["id"].(string),
+		DueDate:   "2006-01-02",
+		TotalPaid: 19.999,
+	})
+	server.createPayment()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusBadRequest,
+			StatusText:   http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{"TotalPaid must be an amount with at most two decimal places"},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Test that we only accept supported payment methods
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/payments", user1["id"].(string), &PaymentRequestBody{
+		BillId:        user1Bill["id"].(string),
+		DueDate:       "2006-01-02",
+		TotalPaid:     19.99,
+		PaymentMethod: "barter",
+	})
+	server.createPayment()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusBadRequest,
+			StatusText:   http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{"PaymentMethod must be one of [bank_transfer card cash check direct_debit other]"},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
 	// Test that we need a valid Bill ID to create the Payment
 	recorder = httptest.NewRecorder()
 	req = server.NewAuthenticatedRequest(http.MethodPost, "/payments", user1["id"].(string), &PaymentRequestBody{
@@ -265,15 +338,249 @@ func TestPaymentCreate(t *testing.T) {
 	server.createPayment()(recorder, req)
 	resp := response.Parse(recorder.Result().Body)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t,
+		map[string]interface{}{"amount": float64(1999), "currency": "USD"},
+		resp.Result.(map[string]interface{})["total_paid"],
+	)
 
-	// Ensure that users cannot pay the same bill for the same date twice
+	// Ensure that users can pay the same bill for the same date in installments
 	recorder = httptest.NewRecorder()
 	req = server.NewAuthenticatedRequest(http.MethodPost, "/payments", user1["id"].(string), &PaymentRequestBody{
 		BillId:    user1Bill["id"].(string),
 		DueDate:   "2006-01-02",
-		TotalPaid: 19.99,
+		TotalPaid: 5.01,
 	})
 	server.createPayment()(recorder, req)
 	resp = response.Parse(recorder.Result().Body)
-	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t,
+		map[string]interface{}{"amount": float64(501), "currency": "USD"},
+		resp.Result.(map[string]interface{})["total_paid"],
+	)
+
+	// Test that users can record how & when the payment was made
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/payments", user1["id"].(string), &PaymentRequestBody{
+		BillId:              user1Bill["id"].(string),
+		DueDate:             "2006-01-02",
+		TotalPaid:           10,
+		PaidAt:              "2006-01-01",
+		PaymentMethod:       "card",
+		PaymentMethodDetail: "Visa ending in 4242",
+		ConfirmationNumber:  "ABC-123",
+		Notes:               "Paid from the joint account",
+	})
+	server.createPayment()(recorder, req)
+	resp = response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result := resp.Result.(map[string]interface{})
+	assert.Equal(t, "2006-01-01T00:00:00Z", result["paid_at"])
+	assert.Equal(t, "card", result["payment_method"])
+	assert.Equal(t, "Visa ending in 4242", result["payment_method_detail"])
+	assert.Equal(t, "ABC-123", result["confirmation_number"])
+	assert.Equal(t, "Paid from the joint account", result["notes"])
+
+	// Ensure that a confirmation number cannot be reused for the same bill
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/payments", user1["id"].(string), &PaymentRequestBody{
+		BillId:             user1Bill["id"].(string),
+		DueDate:            "2006-01-02",
+		TotalPaid:          10,
+		ConfirmationNumber: "ABC-123",
+	})
+	server.createPayment()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusConflict,
+			StatusText:   http.StatusText(http.StatusConflict),
+			ErrorDetails: &[]string{"The confirmation number has already been used for another payment of this bill."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+}
+
+func TestPaymentUpdate(t *testing.T) {
+	// Prepare the Server & seed some data
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	user1 := server.SeedUser()
+	user1Bill := server.SeedBill(user1["id"].(string))
+	user1Payment := server.SeedPayment(user1Bill["id"].(string))
+	user2 := server.SeedUser()
+	user2Bill := server.SeedBill(user2["id"].(string))
+	user2Payment := server.SeedPayment(user2Bill["id"].(string))
+
+	// Validate auth is required
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/payments/"+user1Payment["id"].(string), nil)
+	server.updatePayment()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusUnauthorized,
+			StatusText:   http.StatusText(http.StatusUnauthorized),
+			ErrorDetails: nil,
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Try to update a payment that doesn't exist
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/payments/invalid-id", user1["id"].(string), nil)
+	server.updatePayment()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusNotFound,
+			StatusText:   http.StatusText(http.StatusNotFound),
+			ErrorDetails: nil,
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Try to update another users payment
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/payments/"+user2Payment["id"].(string), user1["id"].(string), nil)
+	server.updatePayment()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusForbidden,
+			StatusText:   http.StatusText(http.StatusForbidden),
+			ErrorDetails: nil,
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Test that we cannot send a request with a misformated request body
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/payments/"+user1Payment["id"].(string), user1["id"].(string), strings.NewReader("-"))
+	server.updatePayment()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusBadRequest,
+			StatusText:   http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{"Failed to parse the request body."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Test that we are performing field level validation checks
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/payments/"+user1Payment["id"].(string), user1["id"].(string), &PaymentRequestBody{
+		DueDate:   "invalid-date",
+		TotalPaid: 19.999,
+	})
+	server.updatePayment()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode: http.StatusBadRequest,
+			StatusText: http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{
+				"DueDate does not match the 2006-01-02 format",
+				"TotalPaid must be an amount with at most two decimal places",
+			},
+			Result: nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Test that we can successfully update our payment, keeping its history
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/payments/"+user1Payment["id"].(string), user1["id"].(string), &PaymentRequestBody{
+		DueDate:   "2020-01-01",
+		TotalPaid: 21.5,
+	})
+	server.updatePayment()(recorder, req)
+	resp := response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result := resp.Result.(map[string]interface{})
+	assert.Equal(t, user1Payment["id"], result["id"])
+	assert.Equal(t, user1Payment["created_at"], result["created_at"])
+	assert.Equal(t, "2020-01-01T00:00:00Z", result["due_date"])
+	assert.Equal(t,
+		map[string]interface{}{"amount": float64(2150), "currency": "USD"},
+		result["total_paid"],
+	)
+
+	// Ensure that the confirmation number cannot collide with another
+	// payment of the bill
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/payments", user1["id"].(string), &PaymentRequestBody{
+		BillId:             user1Bill["id"].(string),
+		DueDate:            "2020-01-01",
+		TotalPaid:          1,
+		ConfirmationNumber: "ABC-123",
+	})
+	server.createPayment()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/payments/"+user1Payment["id"].(string), user1["id"].(string), &PaymentRequestBody{
+		TotalPaid:          21.5,
+		ConfirmationNumber: "ABC-123",
+	})
+	server.updatePayment()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusConflict,
+			StatusText:   http.StatusText(http.StatusConflict),
+			ErrorDetails: &[]string{"The confirmation number has already been used for another payment of this bill."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// But a payment can keep its own confirmation number
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/payments/"+user1Payment["id"].(string), user1["id"].(string), &PaymentRequestBody{
+		ConfirmationNumber: "XYZ-789",
+	})
+	server.updatePayment()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/payments/"+user1Payment["id"].(string), user1["id"].(string), &PaymentRequestBody{
+		ConfirmationNumber: "XYZ-789",
+		Notes:              "Paid at the counter",
+	})
+	server.updatePayment()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Fields that are left out are kept, while null or "" clears them
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/payments/"+user1Payment["id"].(string), user1["id"].(string), UpdateUserBody{
+		"payment_method_detail": "Visa ending in 4242",
+	})
+	server.updatePayment()(recorder, req)
+	result = response.Parse(recorder.Result().Body).Result.(map[string]interface{})
+	assert.Equal(t, "Visa ending in 4242", result["payment_method_detail"])
+	assert.Equal(t, "XYZ-789", result["confirmation_number"])
+	assert.Equal(t, "Paid at the counter", result["notes"])
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/payments/"+user1Payment["id"].(string), user1["id"].(string), UpdateUserBody{
+		"payment_method_detail": nil,
+		"confirmation_number":   "",
+		"notes":                 nil,
+	})
+	server.updatePayment()(recorder, req)
+	resp = response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result = resp.Result.(map[string]interface{})
+	assert.Equal(t, "", result["payment_method_detail"])
+	assert.Equal(t, "", result["confirmation_number"])
+	assert.Equal(t, "", result["notes"])
+	assert.Equal(t, "2020-01-01T00:00:00Z", result["due_date"])
+
+	// Ensure the values are still validated
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPut, "/payments/"+user1Payment["id"].(string), user1["id"].(string), UpdateUserBody{
+		"notes": strings.Repeat("a", 1001),
+	})
+	server.updatePayment()(recorder, req)
+	assert.Equal(t,
+		&[]string{"Notes must be a maximum of 1,000 characters in length"},
+		response.Parse(recorder.Result().Body).ErrorDetails,
+	)
 }
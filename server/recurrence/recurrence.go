@@ -0,0 +1,190 @@
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The Units an Interval can be measured in.
+const (
+	Day   = "day"
+	Week  = "week"
+	Month = "month"
+	Year  = "year"
+)
+
+// The Frequencies a Bill can be created with. Every Frequency other than
+// Custom is a shorthand for a fixed Interval & Unit.
+const (
+	Weekly     = "weekly"
+	Biweekly   = "biweekly"
+	Monthly    = "monthly"
+	Quarterly  = "quarterly"
+	Biannually = "biannually"
+	Annually   = "annually"
+	Custom     = "custom"
+)
+
+type interval struct {
+	count int
+	unit  string
+}
+
+// frequencyIntervals maps every non-custom Frequency to its Interval.
+var frequencyIntervals = map[string]interval{
+	Weekly:     {1, Week},
+	Biweekly:   {2, Week},
+	Monthly:    {1, Month},
+	Quarterly:  {3, Month},
+	Biannually: {6, Month},
+	Annually:   {1, Year},
+}
+
+// FrequencyInterval returns the Interval & Unit a Frequency is shorthand
+// for. ok is false for Custom & any unknown Frequency.
+func FrequencyInterval(frequency string) (count int, unit string, ok bool) {
+	i, ok := frequencyIntervals[frequency]
+	return i.count, i.unit, ok
+}
+
+// Schedule describes when something (generally a Bill) is due: once every
+// Interval Units, starting on the FirstDueDate.
+//
+// Every occurrence is calculated from the FirstDueDate rather than from
+// the previous occurrence, so a monthly Schedule starting on Jan 31 is due
+// on Feb 28 (or Feb 29) and then returns to Mar 31 instead of drifting to
+// the 28th of every following month.
+//
+// A Schedule repeats forever, unless it is bounded by an EndDate (the last
+// date an occurrence may fall on) and/or an OccurrenceLimit.
+type Schedule struct {
+	FirstDueDate    time.Time
+	Interval        int
+	Unit            string
+	EndDate         *time.Time
+	OccurrenceLimit *int
+}
+
+// Validate ensures the Schedule is something we know how to project.
+func (s Schedule) Validate() error {
+	switch s.Unit {
+	case Day, Week, Month, Year:
+	default:
+		return fmt.Errorf("Unsupported recurrence unit: %v", s.Unit)
+	}
+	if s.Interval < 1 {
+		return errors.New("The recurrence interval must be at least 1.")
+	}
+	if s.EndDate != nil && toDate(*s.EndDate).Before(toDate(s.FirstDueDate)) {
+		return errors.New("The end date cannot be before the first due date.")
+	}
+	if s.OccurrenceLimit != nil && *s.OccurrenceLimit < 1 {
+		return errors.New("The occurrence limit must be at least 1.")
+	}
+	return nil
+}
+
+// Occurrence returns the n-th (zero based) due date of the Schedule,
+// ignoring any EndDate or OccurrenceLimit. All due dates are returned as
+// midnight UTC, as they represent a calendar date rather than an instant.
+func (s Schedule) Occurrence(n int) time.Time {
+	first := toDate(s.FirstDueDate)
+	switch s.Unit {
+	case Day:
+		return first.AddDate(0, 0, n*s.Interval)
+	case Week:
+		return first.AddDate(0, 0, n*s.Interval*7)
+	case Year:
+		return addMonthsClamped(first, n*s.Interval*12)
+	default:
+		return addMonthsClamped(first, n*s.Interval)
+	}
+}
+
+// Between returns every due date of the Schedule between the dates
+// 'from' (inclusive) and 'to' (exclusive).
+func (s Schedule) Between(from time.Time, to time.Time) ([]time.Time, error) {
+	err := s.Validate()
+	if err != nil {
+		return nil, err
+	}
+	from, to = toDate(from), toDate(to)
+	occurrences := make([]time.Time, 0)
+
+	// Jump close to 'from' rather than walking every occurrence since the
+	// FirstDueDate. We intentionally land one step early, as clamping
+	// means the estimate can overshoot by a single occurrence.
+	n := 0
+	if skipped := s.stepsApart(toDate(s.FirstDueDate), from) - 1; skipped > 0 {
+		n = skipped
+	}
+	for ; s.OccurrenceLimit == nil || n < *s.OccurrenceLimit; n++ {
+		occurrence := s.Occurrence(n)
+		if !occurrence.Before(to) {
+			break
+		}
+		if s.EndDate != nil && occurrence.After(toDate(*s.EndDate)) {
+			break
+		}
+		if !occurrence.Before(from) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	return occurrences, nil
+}
+
+// stepsApart estimates how many Intervals fit between the dates a and b.
+func (s Schedule) stepsApart(a time.Time, b time.Time) int {
+	switch s.Unit {
+	case Day:
+		return daysApart(a, b) / s.Interval
+	case Week:
+		return daysApart(a, b) / (s.Interval * 7)
+	case Year:
+		return monthsApart(a, b) / (s.Interval * 12)
+	default:
+		return monthsApart(a, b) / s.Interval
+	}
+}
+
+// daysApart counts the whole days between the calendar dates of a and b.
+// This is done from the dates themselves rather than through a
+// time.Duration, which can only span about 292 years.
+func daysApart(a time.Time, b time.Time) int {
+	return int(toDate(b).Unix()/86400 - toDate(a).Unix()/86400)
+}
+
+// toDate truncates a time.Time down to the calendar date it falls on.
+func toDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// addMonthsClamped adds a number of months to a date, clamping the day
+// to the last day of the resulting month when it would otherwise roll
+// over into the next month (e.g. Jan 31 + 1 month = Feb 28).
+func addMonthsClamped(t time.Time, months int) time.Time {
+	monthIndex := int(t.Month()) - 1 + months
+	year := t.Year() + monthIndex/12
+	monthIndex = monthIndex % 12
+	if monthIndex < 0 {
+		monthIndex += 12
+		year--
+	}
+	month := time.Month(monthIndex + 1)
+	day := t.Day()
+	if lastDay := daysIn(year, month); day > lastDay {
+		day = lastDay
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// daysIn returns the number of days in the month of the specified year.
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// monthsApart returns the number of whole calendar months from a to b.
+func monthsApart(a time.Time, b time.Time) int {
+	return (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())
+}
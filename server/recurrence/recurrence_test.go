@@ -0,0 +1,233 @@
+package recurrence
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func date(value string) time.Time {
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func dates(values ...string) []time.Time {
+	result := make([]time.Time, 0)
+	for _, value := range values {
+		result = append(result, date(value))
+	}
+	return result
+}
+
+// schedule builds an unbounded Schedule from a non-custom Frequency.
+func schedule(firstDueDate string, frequency string) Schedule {
+	count, unit, ok := FrequencyInterval(frequency)
+	if !ok {
+		panic("Unknown frequency: " + frequency)
+	}
+	return Schedule{FirstDueDate: date(firstDueDate), Interval: count, Unit: unit}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func datePtr(value string) *time.Time {
+	d := date(value)
+	return &d
+}
+
+func TestFrequencyInterval(t *testing.T) {
+	count, unit, ok := FrequencyInterval(Biweekly)
+	assert.True(t, ok)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, Week, unit)
+
+	count, unit, ok = FrequencyInterval(Biannually)
+	assert.True(t, ok)
+	assert.Equal(t, 6, count)
+	assert.Equal(t, Month, unit)
+
+	// Custom frequencies don't map to a fixed interval
+	_, _, ok = FrequencyInterval(Custom)
+	assert.False(t, ok)
+	_, _, ok = FrequencyInterval("fortnightly")
+	assert.False(t, ok)
+}
+
+func TestScheduleValidate(t *testing.T) {
+	// Every supported frequency should pass validation
+	for _, frequency := range []string{Weekly, Biweekly, Monthly, Quarterly, Biannually, Annually} {
+		assert.Nil(t, schedule("2020-01-01", frequency).Validate())
+	}
+
+	// Unknown units should fail validation
+	err := Schedule{FirstDueDate: date("2020-01-01"), Interval: 1, Unit: "fortnight"}.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, "Unsupported recurrence unit: fortnight", err.Error())
+
+	// Intervals must be positive
+	err = Schedule{FirstDueDate: date("2020-01-01"), Interval: 0, Unit: Day}.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, "The recurrence interval must be at least 1.", err.Error())
+
+	// End dates cannot come before the first due date
+	err = Schedule{FirstDueDate: date("2020-01-01"), Interval: 1, Unit: Day, EndDate: datePtr("2019-12-31")}.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, "The end date cannot be before the first due date.", err.Error())
+
+	// Occurrence limits must be positive
+	err = Schedule{FirstDueDate: date("2020-01-01"), Interval: 1, Unit: Day, OccurrenceLimit: intPtr(0)}.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, "The occurrence limit must be at least 1.", err.Error())
+
+	// Between should surface the validation error
+	occurrences, err := Schedule{FirstDueDate: date("2020-01-01"), Interval: 1, Unit: "fortnight"}.
+		Between(date("2020-01-01"), date("2021-01-01"))
+	assert.NotNil(t, err)
+	assert.Nil(t, occurrences)
+}
+
+func TestScheduleBetween(t *testing.T) {
+	// Monthly, within a window that starts before the first due date
+	occurrences, err := schedule("2020-01-15", Monthly).
+		Between(date("2019-06-01"), date("2020-04-15"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-01-15", "2020-02-15", "2020-03-15"), occurrences)
+
+	// Quarterly, with 'from' being inclusive
+	occurrences, err = schedule("2020-01-15", Quarterly).
+		Between(date("2020-04-15"), date("2021-01-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-04-15", "2020-07-15", "2020-10-15"), occurrences)
+
+	// Biannually, many years after the first due date
+	occurrences, err = schedule("2000-03-01", Biannually).
+		Between(date("2030-01-01"), date("2031-01-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2030-03-01", "2030-09-01"), occurrences)
+
+	// Annually
+	occurrences, err = schedule("2018-07-04", Annually).
+		Between(date("2019-01-01"), date("2021-01-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2019-07-04", "2020-07-04"), occurrences)
+
+	// An empty window returns no occurrences
+	occurrences, err = schedule("2020-01-15", Monthly).
+		Between(date("2020-02-01"), date("2020-01-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Time{}, occurrences)
+}
+
+func TestScheduleMonthEndClamping(t *testing.T) {
+	// Jan 31 clamps to the end of shorter months, but returns to the 31st
+	// whenever the month is long enough (including leap years).
+	occurrences, err := schedule("2020-01-31", Monthly).
+		Between(date("2020-01-01"), date("2020-06-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-01-31", "2020-02-29", "2020-03-31", "2020-04-30", "2020-05-31"), occurrences)
+
+	occurrences, err = schedule("2021-01-31", Monthly).
+		Between(date("2021-02-01"), date("2021-03-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2021-02-28"), occurrences)
+
+	// Leap day bills fall on Feb 28 in non-leap years
+	occurrences, err = schedule("2020-02-29", Annually).
+		Between(date("2020-01-01"), date("2025-01-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-02-29", "2021-02-28", "2022-02-28", "2023-02-28", "2024-02-29"), occurrences)
+
+	// Quarterly from Nov 30 crosses the year boundary and clamps in Feb
+	occurrences, err = schedule("2019-11-30", Quarterly).
+		Between(date("2019-01-01"), date("2020-09-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2019-11-30", "2020-02-29", "2020-05-30", "2020-08-30"), occurrences)
+}
+
+func TestScheduleOccurrenceIgnoresTimeOfDay(t *testing.T) {
+	firstDueDate := time.Date(2020, time.March, 10, 23, 30, 0, 0, time.FixedZone("UTC-8", -8*60*60))
+	schedule := Schedule{FirstDueDate: firstDueDate, Interval: 1, Unit: Month}
+	assert.Equal(t, date("2020-03-10"), schedule.Occurrence(0))
+	assert.Equal(t, date("2020-04-10"), schedule.Occurrence(1))
+}
+
+func TestScheduleWeeksAndDays(t *testing.T) {
+	// Weekly, far enough from the first due date to skip ahead
+	occurrences, err := schedule("2020-01-06", Weekly).
+		Between(date("2021-03-01"), date("2021-03-22"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2021-03-01", "2021-03-08", "2021-03-15"), occurrences)
+
+	// Biweekly
+	occurrences, err = schedule("2020-01-03", Biweekly).
+		Between(date("2020-01-01"), date("2020-02-15"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-01-03", "2020-01-17", "2020-01-31", "2020-02-14"), occurrences)
+
+	// Every 10 days, across the end of February in a leap year
+	occurrences, err = Schedule{FirstDueDate: date("2020-02-20"), Interval: 10, Unit: Day}.
+		Between(date("2020-02-21"), date("2020-03-20"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-03-01", "2020-03-11"), occurrences)
+
+	// Weekly & daily, centuries after the first due date
+	occurrences, err = schedule("1601-01-01", Weekly).
+		Between(date("2020-03-01"), date("2020-03-15"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-03-02", "2020-03-09"), occurrences)
+	occurrences, err = Schedule{FirstDueDate: date("0001-01-01"), Interval: 1, Unit: Day}.
+		Between(date("2020-03-01"), date("2020-03-03"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-03-01", "2020-03-02"), occurrences)
+	assert.Equal(t, 21870, schedule("1601-01-01", Weekly).stepsApart(date("1601-01-01"), date("2020-03-01")))
+	assert.Equal(t, 737484, Schedule{Interval: 1, Unit: Day}.stepsApart(date("0001-01-01"), date("2020-03-01")))
+
+	// Every 2 months
+	occurrences, err = Schedule{FirstDueDate: date("2020-01-31"), Interval: 2, Unit: Month}.
+		Between(date("2020-01-01"), date("2020-07-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-01-31", "2020-03-31", "2020-05-31"), occurrences)
+
+	// Every 2 years
+	occurrences, err = Schedule{FirstDueDate: date("2020-02-29"), Interval: 2, Unit: Year}.
+		Between(date("2020-01-01"), date("2025-01-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-02-29", "2022-02-28", "2024-02-29"), occurrences)
+}
+
+func TestScheduleBounds(t *testing.T) {
+	// End dates are inclusive
+	bounded := schedule("2020-01-15", Monthly)
+	bounded.EndDate = datePtr("2020-03-15")
+	occurrences, err := bounded.Between(date("2020-01-01"), date("2021-01-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-01-15", "2020-02-15", "2020-03-15"), occurrences)
+
+	// Windows entirely after the end date are empty
+	occurrences, err = bounded.Between(date("2020-04-01"), date("2021-01-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Time{}, occurrences)
+
+	// Occurrence limits count from the first due date, not from the window
+	limited := schedule("2020-01-01", Weekly)
+	limited.OccurrenceLimit = intPtr(4)
+	occurrences, err = limited.Between(date("2020-01-10"), date("2021-01-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-01-15", "2020-01-22"), occurrences)
+
+	// Windows entirely after the last occurrence are empty
+	occurrences, err = limited.Between(date("2020-06-01"), date("2021-01-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Time{}, occurrences)
+
+	// Whichever bound comes first wins
+	limited.EndDate = datePtr("2020-01-08")
+	occurrences, err = limited.Between(date("2020-01-01"), date("2021-01-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, dates("2020-01-01", "2020-01-08"), occurrences)
+}
@@ -1,13 +1,18 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/beanpay/api/server/jwt"
+	"github.com/beanpay/api/server/mail"
 	"github.com/beanpay/api/server/middleware"
+	"github.com/beanpay/api/server/throttle"
 	"github.com/beanpay/api/server/validator"
 	"github.com/julienschmidt/httprouter"
+	"net"
 	"net/http"
+	"time"
 )
 
 // Server is a struct responsible for managing a *httprouter.Router.
@@ -20,37 +25,102 @@ type Server struct {
 	Validator    validator.Validator
 	JwtSignatory *jwt.JwtSignatory
 	DB           *sql.DB
+	Mailer       mail.Mailer
+
+	// AppURL is the base URL of the web app, used to build the links in
+	// the emails we send.
+	AppURL string
+
+	// EmailVerification is the policy for users that haven't verified
+	// their email address yet. It defaults to EmailVerificationOptional.
+	EmailVerification string
+
+	// TrustedProxies are the reverse proxies in front of the server, whose
+	// X-Forwarded-For is trusted for the client IP address that requests
+	// are throttled & rate limited on. When there are none, the server is
+	// assumed to be exposed to clients directly.
+	TrustedProxies []*net.IPNet
+
+	// AccountLoginThrottle & ClientLoginThrottle back off the failed logins
+	// of each account & each client IP address. Either is off when nil.
+	AccountLoginThrottle *throttle.Throttle
+	ClientLoginThrottle  *throttle.Throttle
+
+	// MaintenanceInterval is how often housekeeping, such as purging
+	// expired sessions, is run in the background. It's off when zero.
+	MaintenanceInterval time.Duration
+
+	// MaxExchangeRateAge is how old an exchange rate may be on the date
+	// it's used for. Rates of any age are used when it's zero.
+	MaxExchangeRateAge time.Duration
 }
 
 // registerRoutes is responsible for wiring up all of our HandlerFunc
-// to our server's router.
+// to our server's router. Routes that create things, or send mail, are rate
+// limited per user, or per client IP address when they don't require auth.
 func (s *Server) registerRoutes() {
-	requireAuth := middleware.GetRequireAuthMiddleware(s.JwtSignatory)
+	requireAuth := middleware.GetRequireAuthMiddleware(s.JwtSignatory, s.authenticateApiKey)
+	requireCSRF := middleware.GetRequireCSRFMiddleware(s.AppURL)
 	s.Router.HandlerFunc(http.MethodGet, "/ping", s.ping())
+	s.Router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", s.fetchJwks())
 
 	// Payments Endpoints
-	s.Router.HandlerFunc(http.MethodGet, "/payments", requireAuth(s.fetchPayments()))
-	s.Router.HandlerFunc(http.MethodPost, "/payments", requireAuth(s.createPayment()))
-	s.Router.HandlerFunc(http.MethodDelete, "/payments/:id", requireAuth(s.deletePayment()))
+	s.Router.HandlerFunc(http.MethodGet, "/payments", requireAuth(s.requireVerified(s.fetchPayments()), jwt.ScopeRead))
+	s.Router.HandlerFunc(http.MethodPost, "/payments", requireAuth(middleware.NewRateLimiter(60, time.Minute).RateLimit(s.requireVerified(s.createPayment())), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodPut, "/payments/:id", requireAuth(s.requireVerified(s.updatePayment()), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodDelete, "/payments/:id", requireAuth(s.requireVerified(s.deletePayment()), jwt.ScopeWrite))
 
 	// Bills Endpoints
-	s.Router.HandlerFunc(http.MethodGet, "/bills", requireAuth(s.fetchBills()))
-	s.Router.HandlerFunc(http.MethodPost, "/bills", requireAuth(s.createBill()))
-	s.Router.HandlerFunc(http.MethodPut, "/bills/:id", requireAuth(s.updateBill()))
-	s.Router.HandlerFunc(http.MethodDelete, "/bills/:id", requireAuth(s.deleteBill()))
+	s.Router.HandlerFunc(http.MethodGet, "/bills", requireAuth(s.requireVerified(s.fetchBills()), jwt.ScopeRead))
+	s.Router.HandlerFunc(http.MethodPost, "/bills", requireAuth(middleware.NewRateLimiter(60, time.Minute).RateLimit(s.requireVerified(s.createBill())), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodGet, "/bills/:id/occurrences", requireAuth(s.requireVerified(s.fetchBillOccurrences()), jwt.ScopeRead))
+	s.Router.HandlerFunc(http.MethodPut, "/bills/:id", requireAuth(s.requireVerified(s.updateBill()), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodDelete, "/bills/:id", requireAuth(s.requireVerified(s.deleteBill()), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodGet, "/overdue-bills", requireAuth(s.requireVerified(s.fetchOverdueBills()), jwt.ScopeRead))
+
+	// Agenda Endpoints
+	s.Router.HandlerFunc(http.MethodGet, "/agenda", requireAuth(s.requireVerified(middleware.ETag(s.fetchAgenda())), jwt.ScopeRead))
+
+	// User Endpoints
+	s.Router.HandlerFunc(http.MethodGet, "/users/me", requireAuth(s.fetchCurrentUser(), jwt.ScopeRead))
+	s.Router.HandlerFunc(http.MethodPatch, "/users/me", requireAuth(s.updateCurrentUser(), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodPost, "/users/me/password", requireAuth(s.changePassword(), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodPost, "/users/me/email", requireAuth(s.requestEmailChange(), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodPost, "/users/email/confirm", s.confirmEmailChange())
+	s.Router.HandlerFunc(http.MethodPost, "/users/me/mfa/totp", requireAuth(s.enrollTotp(), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodPost, "/users/me/mfa/totp/confirm", requireAuth(s.confirmTotp(), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodDelete, "/users/me/mfa/totp", requireAuth(s.disableTotp(), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodPost, "/users/me/mfa/recovery-codes", requireAuth(s.regenerateRecoveryCodes(), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodGet, "/users/me/api-keys", requireAuth(s.fetchApiKeys(), jwt.ScopeRead))
+	s.Router.HandlerFunc(http.MethodPost, "/users/me/api-keys", requireAuth(s.createApiKey(), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodDelete, "/users/me/api-keys/:id", requireAuth(s.deleteApiKey(), jwt.ScopeWrite))
 
 	// Auth Endpoints
-	s.Router.HandlerFunc(http.MethodPost, "/users", s.createUser())
+	s.Router.HandlerFunc(http.MethodPost, "/users", middleware.NewRateLimiter(10, time.Hour).RateLimit(s.createUser()))
 	s.Router.HandlerFunc(http.MethodPost, "/auth/login", s.login())
-	s.Router.HandlerFunc(http.MethodPost, "/auth/logout", s.logout())
-	s.Router.HandlerFunc(http.MethodPost, "/auth/refresh", s.authRefresh())
+	s.Router.HandlerFunc(http.MethodPost, "/auth/login/mfa", s.loginMfa())
+	s.Router.HandlerFunc(http.MethodPost, "/auth/logout", requireCSRF(s.logout()))
+	s.Router.HandlerFunc(http.MethodPost, "/auth/refresh", requireCSRF(s.authRefresh()))
+	s.Router.HandlerFunc(http.MethodGet, "/auth/sessions", requireAuth(s.fetchSessions(), jwt.ScopeRead))
+	s.Router.HandlerFunc(http.MethodDelete, "/auth/sessions/:chain_id", requireAuth(s.deleteSession(), jwt.ScopeWrite))
+	s.Router.HandlerFunc(http.MethodPost, "/auth/verify-email", s.verifyEmail())
+	s.Router.HandlerFunc(http.MethodPost, "/auth/verify-email/resend", middleware.NewRateLimiter(5, time.Hour).RateLimit(s.resendVerificationEmail()))
+	s.Router.HandlerFunc(http.MethodPost, "/auth/password-reset/request", middleware.NewRateLimiter(5, time.Hour).RateLimit(s.requestPasswordReset()))
+	s.Router.HandlerFunc(http.MethodPost, "/auth/password-reset/confirm", s.confirmPasswordReset())
 }
 
 // Start binds all routes to our router and then serves our
 // router to handle all requests on incoming connections.
 func (s *Server) Start() {
 	s.registerRoutes()
+	s.startMaintenance(context.Background())
 	fmt.Println(fmt.Sprintf("Starting server on :%v", s.Port))
-	handler := middleware.Cors(s.Router)
-	http.ListenAndServe(":"+s.Port, handler)
+	http.ListenAndServe(":"+s.Port, s.handler())
+}
+
+// handler wraps our router in the middleware every request goes through.
+// The client's address is settled first, so that the login throttle & the
+// rate limits of every route see the client's, rather than a proxy's.
+func (s *Server) handler() http.Handler {
+	return middleware.GetTrustedProxiesMiddleware(s.TrustedProxies)(middleware.Cors(s.Router))
 }
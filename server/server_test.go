@@ -0,0 +1,44 @@
+package server
+
+import (
+	"github.com/beanpay/api/server/jwt"
+	"github.com/beanpay/api/server/middleware"
+	"github.com/beanpay/api/server/validator"
+	"github.com/generalledger/response"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitBehindProxy(t *testing.T) {
+	// Prepare a Server behind a proxy. Nothing here reaches the database.
+	trustedProxies, err := middleware.ParseTrustedProxies("10.0.0.0/8")
+	assert.Nil(t, err)
+	server := &Server{
+		Router:         httprouter.New(),
+		Validator:      validator.New(),
+		JwtSignatory:   &jwt.JwtSignatory{SigningKey: []byte("test-signing-key")},
+		TrustedProxies: trustedProxies,
+	}
+	server.registerRoutes()
+	handler := server.handler()
+	request := func(forwardedFor string) int {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/auth/password-reset/request", UpdateUserBody{"email": "invalid-email"})
+		req.RemoteAddr = "10.0.0.2:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		handler.ServeHTTP(recorder, req)
+		return response.Parse(recorder.Result().Body).StatusCode
+	}
+
+	// Use up a client's requests to the route, which allows 5 an hour
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusBadRequest, request("192.0.2.1"))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, request("192.0.2.1"))
+
+	// Other clients behind the same proxy have their own
+	assert.Equal(t, http.StatusBadRequest, request("198.51.100.7"))
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/jwt"
+	"github.com/generalledger/response"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxUserAgentLength caps how much of a User-Agent header we store.
+const maxUserAgentLength = 512
+
+type sessionResponseBody struct {
+	*models.Session
+
+	// Current is set on the session the request was made from.
+	Current bool `json:"current"`
+}
+
+// userAgent returns the User-Agent of the request, truncated so that a
+// client can't make us store an arbitrarily long header.
+func userAgent(r *http.Request) string {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return userAgent
+}
+
+// currentChainId returns the chain of the refresh token cookie that was
+// sent with the request, if any.
+func currentChainId(r *http.Request, refreshTokenRepo models.RefreshTokenRepository) string {
+	refreshTokenCookie, err := r.Cookie("refresh_token")
+	if err != nil {
+		return ""
+	}
+	refreshToken, err := refreshTokenRepo.FetchByID(refreshTokenCookie.Value)
+	if err != nil {
+		return ""
+	}
+	return refreshToken.ChainId
+}
+
+func (s *Server) fetchSessions() http.HandlerFunc {
+	refreshTokenRepo := models.RefreshTokenRepository{DB: s.DB}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := r.Context().Value("jwtClaims").(jwt.Claims)
+		if !ok {
+			resp.SetResult(http.StatusUnauthorized, nil)
+			return
+		}
+
+		// Fetch every Session that hasn't expired yet
+		sessions, err := refreshTokenRepo.FetchAllUserSessions(claims.UserID, time.Now().Add(-refreshTokenDuration))
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// OK
+		currentChainId := currentChainId(r, refreshTokenRepo)
+		result := make([]sessionResponseBody, 0, len(sessions))
+		for _, session := range sessions {
+			result = append(result, sessionResponseBody{
+				Session: session,
+				Current: session.ChainId == currentChainId,
+			})
+		}
+		resp.SetResult(http.StatusOK, result)
+	}
+}
+
+func (s *Server) deleteSession() http.HandlerFunc {
+	refreshTokenRepo := models.RefreshTokenRepository{DB: s.DB}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := r.Context().Value("jwtClaims").(jwt.Claims)
+		if !ok {
+			resp.SetResult(http.StatusUnauthorized, nil)
+			return
+		}
+
+		// Revoke the Session, which has to be one of the User's
+		chainId := strings.Split(r.URL.Path, "/")[3]
+		isCurrent := chainId == currentChainId(r, refreshTokenRepo)
+		err := refreshTokenRepo.DeleteUserChain(claims.UserID, chainId)
+		if err != nil {
+			resp.SetResult(http.StatusNotFound, nil)
+			return
+		}
+
+		// If that's the session the request came from, sign it out here too
+		if isCurrent {
+			clearRefreshTokenCookie(w)
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, nil)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"github.com/generalledger/response"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// loginFrom logs a user in from a device, returning the refresh token.
+func loginFrom(t *testing.T, server *TestServer, userAgent string, remoteAddr string) string {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: realUserEmail, Password: realUserPassword})
+	req.Header.Set("User-Agent", userAgent)
+	req.RemoteAddr = remoteAddr
+	server.login()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == "refresh_token" {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func TestSessions(t *testing.T) {
+	// Prepare the Server & a user logged in on two devices
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	userId, _ := seedLoggedInUser(t, server, realUserEmail, realUserPassword)
+	otherUser := server.SeedUser()
+	server.DB.Exec("DELETE FROM refresh_tokens;")
+	laptopToken := loginFrom(t, server, "Firefox on Linux", "192.0.2.1:51234")
+	phoneToken := loginFrom(t, server, "Safari on iOS", "198.51.100.7:443")
+
+	// Validate auth is required
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/auth/sessions", nil)
+	server.fetchSessions()(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+
+	// List the sessions from the laptop
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/auth/sessions", userId, nil)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: laptopToken})
+	server.fetchSessions()(recorder, req)
+	resp := response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	sessions := resp.Result.([]interface{})
+	assert.Equal(t, 2, len(sessions))
+	phone := sessions[0].(map[string]interface{})
+	laptop := sessions[1].(map[string]interface{})
+	assert.Equal(t, "Safari on iOS", phone["user_agent"])
+	assert.Equal(t, "198.51.100.7", phone["ip_address"])
+	assert.Equal(t, false, phone["current"])
+	assert.Equal(t, "Firefox on Linux", laptop["user_agent"])
+	assert.Equal(t, "192.0.2.1", laptop["ip_address"])
+	assert.Equal(t, true, laptop["current"])
+
+	// Ensure other users can't sign the phone out
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodDelete, "/auth/sessions/"+phone["chain_id"].(string), otherUser["id"].(string), nil)
+	server.deleteSession()(recorder, req)
+	assert.Equal(t, http.StatusNotFound, response.Parse(recorder.Result().Body).StatusCode)
+	assert.Equal(t, http.StatusOK, refreshStatus(server, phoneToken))
+
+	// Sign the phone out from the laptop
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodDelete, "/auth/sessions/"+phone["chain_id"].(string), userId, nil)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: laptopToken})
+	server.deleteSession()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	assert.Equal(t, 0, len(recorder.Result().Cookies()))
+	assert.Equal(t, http.StatusUnauthorized, refreshStatus(server, phoneToken))
+
+	// Ensure it's gone from the list
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/auth/sessions", userId, nil)
+	server.fetchSessions()(recorder, req)
+	assert.Equal(t, 1, len(response.Parse(recorder.Result().Body).Result.([]interface{})))
+
+	// Signing out the current session clears its cookie
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodDelete, "/auth/sessions/"+laptop["chain_id"].(string), userId, nil)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: laptopToken})
+	server.deleteSession()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	assert.Equal(t, "", recorder.Result().Cookies()[0].Value)
+}
+
+func TestLogout(t *testing.T) {
+	// Prepare the Server & a logged in user
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	_, refreshToken := seedLoggedInUser(t, server, realUserEmail, realUserPassword)
+
+	// Logout, which clears the cookie
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: refreshToken})
+	server.logout()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	assert.Equal(t, "", recorder.Result().Cookies()[0].Value)
+
+	// Ensure the refresh token was revoked too
+	assert.Equal(t, http.StatusUnauthorized, refreshStatus(server, refreshToken))
+
+	// Logging out without a session still succeeds
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "not-a-token"})
+	server.logout()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+}
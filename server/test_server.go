@@ -6,6 +6,7 @@ import (
 	"github.com/beanpay/api/database"
 	"github.com/beanpay/api/database/models"
 	"github.com/beanpay/api/server/jwt"
+	"github.com/beanpay/api/server/mail"
 	"github.com/beanpay/api/server/validator"
 	"github.com/satori/go.uuid"
 	"io"
@@ -31,6 +32,8 @@ func NewTestServer() (*TestServer, error) {
 			JwtSignatory: &jwt.JwtSignatory{
 				SigningKey: []byte("test-signing-key"),
 			},
+			Mailer: &mail.Outbox{},
+			AppURL: "https://app.example.com",
 		},
 	}, nil
 }
@@ -49,7 +52,7 @@ type TestServer struct {
 // user with userId has actually gone through a proper authentication flow.
 func (t *TestServer) NewAuthenticatedRequest(method, target, userId string, body io.Reader) *http.Request {
 	// Generate a token valid for 1 second
-	token, err := t.JwtSignatory.GenerateSignedToken(userId, time.Now().Add(time.Second*1))
+	token, err := t.JwtSignatory.GenerateSignedToken(userId, jwt.AllScopes, time.Now().Add(time.Second*1))
 	if err != nil {
 		panic(err)
 	}
@@ -94,12 +97,14 @@ func (t *TestServer) SeedBill(userId string) map[string]interface{} {
 	// Create a Bill in our Database
 	billRepo := models.BillRepository{DB: t.EphemeralDatabase.Connection()}
 	bill := &models.Bill{
-		UserId:            userId,
-		Name:              uuid.NewV4().String(),
-		PaymentURL:        "https://" + uuid.NewV4().String() + ".com",
-		Frequency:         "monthly",
-		EstimatedTotalDue: 19.99,
-		FirstDueDate:      time.Now().Add(time.Hour * 24 * 10),
+		UserId:             userId,
+		Name:               uuid.NewV4().String(),
+		PaymentURL:         "https://" + uuid.NewV4().String() + ".com",
+		Frequency:          "monthly",
+		EstimatedTotalDue:  models.Money{Amount: 1999, Currency: "USD"},
+		FirstDueDate:       time.Now().Add(time.Hour * 24 * 10),
+		RecurrenceInterval: 1,
+		RecurrenceUnit:     "month",
 	}
 	err := billRepo.Insert(bill)
 	if err != nil {
@@ -122,7 +127,7 @@ func (t *TestServer) SeedPayment(billId string) map[string]interface{} {
 	payment := &models.Payment{
 		BillId:    billId,
 		DueDate:   time.Now().Add(time.Hour * 24 * 10),
-		TotalPaid: 19.99,
+		TotalPaid: models.Money{Amount: 1999, Currency: "USD"},
 	}
 	err := paymentRepo.Insert(payment)
 	if err != nil {
@@ -139,3 +144,8 @@ func (t *TestServer) SeedPayment(billId string) map[string]interface{} {
 func (t *TestServer) Shutdown() {
 	t.EphemeralDatabase.Terminate()
 }
+
+// Outbox returns the emails that have been sent by our TestServer.
+func (t *TestServer) Outbox() *mail.Outbox {
+	return t.Mailer.(*mail.Outbox)
+}
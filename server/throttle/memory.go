@@ -0,0 +1,89 @@
+package throttle
+
+import (
+	"database/sql"
+	"github.com/beanpay/api/database/models"
+	"sync"
+	"time"
+)
+
+// memoryStorePruneInterval is how many failures are recorded between
+// sweeps of the keys that have gone stale.
+const memoryStorePruneInterval = 1000
+
+// MemoryStore is a Store for a single server, which forgets everything
+// when it restarts.
+type MemoryStore struct {
+	mu       sync.Mutex
+	failures map[string]*models.LoginFailure
+	recorded int
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		failures: map[string]*models.LoginFailure{},
+	}
+}
+
+func (m *MemoryStore) FetchByKey(key string) (*models.LoginFailure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	loginFailure, ok := m.failures[key]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *loginFailure
+	return &copied, nil
+}
+
+// ReserveAttempt counts an attempt of the key at attemptedAt as a failure,
+// starting the count over if the last one was before resetBefore, but only
+// when allow accepts the key's current failures. Every so often, the keys
+// that haven't failed since resetBefore are pruned, so that a client can't
+// fill up memory by failing with lots of keys.
+func (m *MemoryStore) ReserveAttempt(key string, attemptedAt time.Time, resetBefore time.Time, allow func(*models.LoginFailure) bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	loginFailure, ok := m.failures[key]
+	if !ok {
+		loginFailure = &models.LoginFailure{Key: key}
+	}
+	copied := *loginFailure
+	if !allow(&copied) {
+		return false, nil
+	}
+
+	m.recorded++
+	if m.recorded%memoryStorePruneInterval == 0 {
+		for k, loginFailure := range m.failures {
+			if loginFailure.LastFailedAt.Before(resetBefore) {
+				delete(m.failures, k)
+			}
+		}
+	}
+	if loginFailure.LastFailedAt.Before(resetBefore) {
+		loginFailure = &models.LoginFailure{Key: key}
+	}
+	loginFailure.Failures++
+	loginFailure.LastFailedAt = attemptedAt
+	m.failures[key] = loginFailure
+	return true, nil
+}
+
+func (m *MemoryStore) ReleaseAttempt(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	loginFailure, ok := m.failures[key]
+	if ok && loginFailure.Failures > 0 {
+		loginFailure.Failures--
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteByKey(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, key)
+	return nil
+}
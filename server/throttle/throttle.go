@@ -0,0 +1,115 @@
+package throttle
+
+import (
+	"database/sql"
+	"github.com/beanpay/api/database/models"
+	"time"
+)
+
+// Store keeps count of the failures of each key. models.LoginFailureRepository
+// is a Store that's shared between servers, & MemoryStore is one that isn't.
+// ReserveAttempt has to decide & count in one step, so that concurrent
+// attempts can't all be allowed before any of them are counted.
+type Store interface {
+	FetchByKey(key string) (*models.LoginFailure, error)
+	ReserveAttempt(key string, attemptedAt time.Time, resetBefore time.Time, allow func(*models.LoginFailure) bool) (bool, error)
+	ReleaseAttempt(key string) error
+	DeleteByKey(key string) error
+}
+
+// Throttle backs off keys exponentially once they've failed Allowed times
+// in a row, by BaseDelay, then twice that, & so on up to MaxDelay, which is
+// effectively a temporary lockout. Failures are forgotten once a key goes
+// Window without one.
+type Throttle struct {
+	Store     Store
+	Allowed   int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Window    time.Duration
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+func (t *Throttle) currentTime() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// Delay returns how long a key has to wait after its last failure, once it
+// has failed that many times.
+func (t *Throttle) Delay(failures int) time.Duration {
+	if failures < t.Allowed {
+		return 0
+	}
+	delay := t.BaseDelay
+	for i := t.Allowed; i < failures; i++ {
+		delay *= 2
+		if delay >= t.MaxDelay {
+			return t.MaxDelay
+		}
+	}
+	if delay > t.MaxDelay {
+		return t.MaxDelay
+	}
+	return delay
+}
+
+// RetryAfter returns how long until the key can be tried again, which is
+// zero when it isn't being throttled.
+func (t *Throttle) RetryAfter(key string) (time.Duration, error) {
+	loginFailure, err := t.Store.FetchByKey(key)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return t.retryAfter(loginFailure, t.currentTime()), nil
+}
+
+// retryAfter returns how long after now the key of the LoginFailure can be
+// tried again.
+func (t *Throttle) retryAfter(loginFailure *models.LoginFailure, now time.Time) time.Duration {
+	if loginFailure.LastFailedAt.Before(now.Add(-t.Window)) {
+		return 0
+	}
+	retryAfter := loginFailure.LastFailedAt.Add(t.Delay(loginFailure.Failures)).Sub(now)
+	if retryAfter < 0 {
+		return 0
+	}
+	return retryAfter
+}
+
+// Attempt counts an attempt of the key as a failure up front, unless the key
+// is being throttled, in which case it returns how long until it can be
+// tried again. Counting the attempt before it's made means a burst of
+// concurrent attempts can't all get through before any of them fail. The
+// attempt stays counted unless it's Released or the key is Reset.
+func (t *Throttle) Attempt(key string) (time.Duration, error) {
+	now := t.currentTime()
+	retryAfter := time.Duration(0)
+	_, err := t.Store.ReserveAttempt(key, now, now.Add(-t.Window), func(loginFailure *models.LoginFailure) bool {
+		retryAfter = t.retryAfter(loginFailure, now)
+		return retryAfter == 0
+	})
+	if err != nil {
+		return 0, err
+	}
+	return retryAfter, nil
+}
+
+// Release takes back an Attempt that didn't fail after all, keeping the
+// key's other failures. The delay of a key that's being throttled still
+// counts from the Attempt.
+func (t *Throttle) Release(key string) error {
+	return t.Store.ReleaseAttempt(key)
+}
+
+// Reset forgets the failures of the key.
+func (t *Throttle) Reset(key string) error {
+	return t.Store.DeleteByKey(key)
+}
@@ -0,0 +1,142 @@
+package throttle
+
+import (
+	"github.com/beanpay/api/database/models"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	throttle := &Throttle{
+		Allowed:   3,
+		BaseDelay: time.Second,
+		MaxDelay:  time.Minute,
+	}
+	for failures, delay := range []time.Duration{
+		0, 0, 0,
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		16 * time.Second,
+		32 * time.Second,
+		time.Minute,
+		time.Minute,
+	} {
+		assert.Equal(t, delay, throttle.Delay(failures), "%v failures", failures)
+	}
+	assert.Equal(t, time.Minute, throttle.Delay(1000))
+}
+
+func TestThrottle(t *testing.T) {
+	now := time.Date(2020, 5, 12, 12, 0, 0, 0, time.UTC)
+	throttle := &Throttle{
+		Store:     NewMemoryStore(),
+		Allowed:   2,
+		BaseDelay: 10 * time.Second,
+		MaxDelay:  15 * time.Minute,
+		Window:    time.Hour,
+		now:       func() time.Time { return now },
+	}
+	retryAfter := func(key string) time.Duration {
+		d, err := throttle.RetryAfter(key)
+		assert.Nil(t, err)
+		return d
+	}
+
+	// Keys that haven't failed aren't throttled
+	assert.Equal(t, time.Duration(0), retryAfter("account:a@example.com"))
+
+	attempt := func(key string) time.Duration {
+		d, err := throttle.Attempt(key)
+		assert.Nil(t, err)
+		return d
+	}
+
+	// Nor are keys that have failed fewer than Allowed times
+	assert.Equal(t, time.Duration(0), attempt("account:a@example.com"))
+	assert.Equal(t, time.Duration(0), retryAfter("account:a@example.com"))
+
+	// After which they back off exponentially
+	assert.Equal(t, time.Duration(0), attempt("account:a@example.com"))
+	assert.Equal(t, 10*time.Second, retryAfter("account:a@example.com"))
+	now = now.Add(4 * time.Second)
+	assert.Equal(t, 6*time.Second, retryAfter("account:a@example.com"))
+	assert.Equal(t, time.Duration(0), retryAfter("account:b@example.com"))
+
+	// Attempts while they're throttled are turned away, without counting
+	assert.Equal(t, 6*time.Second, attempt("account:a@example.com"))
+	assert.Equal(t, 6*time.Second, retryAfter("account:a@example.com"))
+
+	// Until they've waited long enough
+	now = now.Add(6 * time.Second)
+	assert.Equal(t, time.Duration(0), attempt("account:a@example.com"))
+	assert.Equal(t, 20*time.Second, retryAfter("account:a@example.com"))
+
+	// Failures are forgotten once a key goes the Window without one
+	now = now.Add(2 * time.Hour)
+	assert.Equal(t, time.Duration(0), attempt("account:a@example.com"))
+	assert.Equal(t, time.Duration(0), retryAfter("account:a@example.com"))
+
+	// Attempts that didn't fail after all can be released
+	assert.Equal(t, time.Duration(0), attempt("account:a@example.com"))
+	assert.Equal(t, 10*time.Second, retryAfter("account:a@example.com"))
+	assert.Nil(t, throttle.Release("account:a@example.com"))
+	assert.Equal(t, time.Duration(0), retryAfter("account:a@example.com"))
+
+	// Or every failure can be reset
+	assert.Equal(t, time.Duration(0), attempt("account:a@example.com"))
+	assert.NotEqual(t, time.Duration(0), retryAfter("account:a@example.com"))
+	assert.Nil(t, throttle.Reset("account:a@example.com"))
+	assert.Equal(t, time.Duration(0), retryAfter("account:a@example.com"))
+}
+
+func TestThrottleConcurrentAttempts(t *testing.T) {
+	throttle := &Throttle{
+		Store:     NewMemoryStore(),
+		Allowed:   3,
+		BaseDelay: time.Minute,
+		MaxDelay:  time.Hour,
+		Window:    time.Hour,
+	}
+
+	// Of a burst of concurrent attempts, only the Allowed ones get through
+	var wg sync.WaitGroup
+	var allowed int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			retryAfter, err := throttle.Attempt("account:a@example.com")
+			assert.Nil(t, err)
+			if retryAfter == 0 {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), allowed)
+}
+
+func TestMemoryStorePrunes(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2020, 5, 12, 12, 0, 0, 0, time.UTC)
+	allow := func(*models.LoginFailure) bool { return true }
+	_, err := store.ReserveAttempt("stale", now, now.Add(-time.Hour), allow)
+	assert.Nil(t, err)
+
+	// Once enough failures have been recorded, stale keys are pruned
+	now = now.Add(2 * time.Hour)
+	for i := 1; i < memoryStorePruneInterval; i++ {
+		_, err = store.ReserveAttempt("active", now, now.Add(-time.Hour), allow)
+		assert.Nil(t, err)
+	}
+	_, err = store.FetchByKey("stale")
+	assert.NotNil(t, err)
+	loginFailure, err := store.FetchByKey("active")
+	assert.Nil(t, err)
+	assert.Equal(t, memoryStorePruneInterval-1, loginFailure.Failures)
+}
@@ -0,0 +1,66 @@
+package totp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA1 test vectors of RFC 6238, Appendix B.
+func TestCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for unix, expected := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		assert.Equal(t, expected, code(key, uint64(Step(time.Unix(unix, 0))), 8))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.Nil(t, err)
+	assert.Equal(t, 32, len(secret))
+	now := time.Unix(1589270400, 0)
+
+	// The current code is valid, as are those either side of it
+	for _, offset := range []time.Duration{-Period, 0, Period} {
+		candidate, err := Code(secret, now.Add(offset))
+		assert.Nil(t, err)
+		step, ok := Validate(secret, candidate, now)
+		assert.True(t, ok)
+		assert.Equal(t, Step(now.Add(offset)), step)
+	}
+
+	// But codes from further away aren't
+	for _, offset := range []time.Duration{-2 * Period, 2 * Period} {
+		candidate, _ := Code(secret, now.Add(offset))
+		_, ok := Validate(secret, candidate, now)
+		assert.False(t, ok)
+	}
+
+	// Nor are malformed codes, or secrets
+	for _, candidate := range []string{"", "12345", "1234567", "abcdef"} {
+		_, ok := Validate(secret, candidate, now)
+		assert.False(t, ok)
+	}
+	_, ok := Validate("not base32!", "123456", now)
+	assert.False(t, ok)
+
+	// Secrets are accepted however they were typed in
+	candidate, _ := Code(secret, now)
+	_, ok = Validate(strings.ToLower(secret[:16])+" "+secret[16:], candidate, now)
+	assert.True(t, ok)
+}
+
+func TestProvisioningURI(t *testing.T) {
+	assert.Equal(t,
+		"otpauth://totp/BeanPay:user@example.com?algorithm=SHA1&digits=6&issuer=BeanPay&period=30&secret=JBSWY3DPEHPK3PXP",
+		ProvisioningURI("JBSWY3DPEHPK3PXP", "BeanPay", "user@example.com"),
+	)
+}
@@ -1,19 +1,26 @@
 package server
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/jwt"
+	"github.com/beanpay/api/server/mail"
 	"github.com/generalledger/response"
 	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 	"net/http"
+	"time"
 )
 
 func (s *Server) createUser() http.HandlerFunc {
 	userRepo := models.UserRepository{DB: s.DB}
 	type RequestBody struct {
-		Email    string `json:"email" validate:"required,email"`
-		Password string `json:"password" validate:"required,min=8"`
+		Email        string `json:"email" validate:"required,email"`
+		Password     string `json:"password" validate:"required,min=8"`
+		HomeCurrency string `json:"home_currency" validate:"omitempty,currency"`
+		TimeZone     string `json:"time_zone" validate:"omitempty,timezone"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		resp := response.New(w)
@@ -42,10 +49,399 @@ func (s *Server) createUser() http.HandlerFunc {
 		}
 
 		// Create the user record
-		err = userRepo.Insert(&models.User{
-			Email:    requestBody.Email,
-			Password: string(pwBytes),
+		user := &models.User{
+			Email:        requestBody.Email,
+			Password:     string(pwBytes),
+			HomeCurrency: requestBody.HomeCurrency,
+			TimeZone:     requestBody.TimeZone,
+		}
+		err = userRepo.Insert(user)
+		if err != nil {
+			pqErr, ok := err.(*pq.Error)
+			if ok {
+				if pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "users_email_key" {
+					resp.SetResult(http.StatusConflict, nil).
+						WithErrorDetails("Email is already in use by another user")
+					return
+				}
+			}
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// Mail them a link to verify their address. The user can ask for
+		// another one if this fails, so it doesn't fail the signup.
+		s.sendVerificationEmail(user)
+
+		// OK
+		resp.SetResult(http.StatusOK, nil)
+	}
+}
+
+func (s *Server) fetchCurrentUser() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := r.Context().Value("jwtClaims").(jwt.Claims)
+		if !ok {
+			resp.SetResult(http.StatusUnauthorized, nil)
+			return
+		}
+
+		// Fetch the User
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusNotFound, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, user)
+	}
+}
+
+func (s *Server) updateCurrentUser() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	type RequestBody struct {
+		DisplayName        *string `json:"display_name" validate:"omitempty,max=100"`
+		TimeZone           *string `json:"time_zone" validate:"omitempty,timezone"`
+		HomeCurrency       *string `json:"home_currency" validate:"omitempty,currency"`
+		WeekStart          *string `json:"week_start" validate:"omitempty,oneof=monday tuesday wednesday thursday friday saturday sunday"`
+		RemindersEnabled   *bool   `json:"reminders_enabled"`
+		ReminderDaysBefore *int    `json:"reminder_days_before" validate:"omitempty,min=0,max=30"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := r.Context().Value("jwtClaims").(jwt.Claims)
+		if !ok {
+			resp.SetResult(http.StatusUnauthorized, nil)
+			return
+		}
+
+		// Fetch the User
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusNotFound, nil)
+			return
+		}
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err = json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Update the User, leaving out anything that wasn't sent
+		if requestBody.DisplayName != nil {
+			user.DisplayName = *requestBody.DisplayName
+		}
+		if requestBody.TimeZone != nil {
+			user.TimeZone = *requestBody.TimeZone
+		}
+		if requestBody.HomeCurrency != nil {
+			user.HomeCurrency = *requestBody.HomeCurrency
+		}
+		if requestBody.WeekStart != nil {
+			user.WeekStart = *requestBody.WeekStart
+		}
+		if requestBody.RemindersEnabled != nil {
+			user.RemindersEnabled = *requestBody.RemindersEnabled
+		}
+		if requestBody.ReminderDaysBefore != nil {
+			user.ReminderDaysBefore = *requestBody.ReminderDaysBefore
+		}
+		err = userRepo.Update(user)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, user)
+	}
+}
+
+// emailChangeTokenDuration is how long the link to confirm a new email
+// address stays valid.
+const emailChangeTokenDuration = 24 * time.Hour
+
+func (s *Server) changePassword() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	refreshTokenRepo := models.RefreshTokenRepository{DB: s.DB}
+	apiKeyRepo := models.ApiKeyRepository{DB: s.DB}
+	type RequestBody struct {
+		CurrentPassword string `json:"current_password" validate:"required"`
+		NewPassword     string `json:"new_password" validate:"required,min=8"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := sessionClaims(resp, r)
+		if !ok {
+			return
+		}
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Fetch the User & re-verify their current Password
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusNotFound, nil)
+			return
+		}
+
+		// Passwords are guessed against the same throttles as logins
+		retryAfter, err := s.attemptLogin(r, user.Email)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if retryAfter > 0 {
+			tooManyLoginAttempts(w, resp, retryAfter)
+			return
+		}
+		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(requestBody.CurrentPassword))
+		if err != nil {
+			resp.SetResult(http.StatusForbidden, nil).
+				WithErrorDetails("The current password is incorrect.")
+			return
+		}
+		s.succeedLogin(r, user.Email)
+
+		// Encrypt & store the new Password
+		pwBytes, err := bcrypt.GenerateFromPassword([]byte(requestBody.NewPassword), 14)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		user.Password = string(pwBytes)
+		err = userRepo.UpdatePassword(user)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// Sign the user out everywhere & revoke their API keys, as whoever
+		// knew the old password may have signed in with it.
+		err = refreshTokenRepo.DeleteAllUserChains(user.Id)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = apiKeyRepo.DeleteAllUserKeys(user.Id)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		clearRefreshTokenCookie(w)
+
+		// Let the user know, in case it wasn't them
+		s.Mailer.Send(mail.Message{
+			To:      user.Email,
+			Subject: "Your BeanPay password was changed",
+			Body:    "The password of your BeanPay account was just changed, and every device was signed out and every API key revoked.\n\nIf this wasn't you, reset your password right away.",
+		})
+
+		// OK
+		resp.SetResult(http.StatusOK, nil)
+	}
+}
+
+func (s *Server) requestEmailChange() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	userTokenRepo := models.UserTokenRepository{DB: s.DB}
+	type RequestBody struct {
+		Email    string `json:"email" validate:"required,email"`
+		Password string `json:"password" validate:"required"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		// Pull out our JWT Claims
+		claims, ok := sessionClaims(resp, r)
+		if !ok {
+			return
+		}
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Fetch the User & re-verify their Password
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err != nil {
+			resp.SetResult(http.StatusNotFound, nil)
+			return
+		}
+
+		// Passwords are guessed against the same throttles as logins
+		retryAfter, err := s.attemptLogin(r, user.Email)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		if retryAfter > 0 {
+			tooManyLoginAttempts(w, resp, retryAfter)
+			return
+		}
+		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(requestBody.Password))
+		if err != nil {
+			resp.SetResult(http.StatusForbidden, nil).
+				WithErrorDetails("The password is incorrect.")
+			return
+		}
+		s.succeedLogin(r, user.Email)
+
+		// Verify the new address is actually new, & free
+		if requestBody.Email == user.Email {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("The new email is the same as the current one.")
+			return
+		}
+		_, err = userRepo.FetchByEmail(requestBody.Email)
+		if err == nil {
+			resp.SetResult(http.StatusConflict, nil).
+				WithErrorDetails("Email is already in use by another user")
+			return
+		}
+
+		// Issue a token, replacing any that were issued before
+		token, tokenHash, err := newSecretToken()
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = userTokenRepo.DeleteAllUserTokens(user.Id, models.UserTokenChangeEmail)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = userTokenRepo.Insert(&models.UserToken{
+			UserId:    user.Id,
+			Purpose:   models.UserTokenChangeEmail,
+			Email:     requestBody.Email,
+			TokenHash: tokenHash,
+			ExpiresAt: time.Now().Add(emailChangeTokenDuration),
 		})
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// Mail the token to the new address to prove it's theirs
+		err = s.Mailer.Send(mail.Message{
+			To:      requestBody.Email,
+			Subject: "Confirm your new BeanPay email address",
+			Body: fmt.Sprintf(
+				"Follow this link within 24 hours to start using this address for your BeanPay account:\n\n%v/confirm-email?token=%v",
+				s.AppURL,
+				token,
+			),
+		})
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, nil)
+	}
+}
+
+func (s *Server) confirmEmailChange() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	userTokenRepo := models.UserTokenRepository{DB: s.DB}
+	refreshTokenRepo := models.RefreshTokenRepository{DB: s.DB}
+	apiKeyRepo := models.ApiKeyRepository{DB: s.DB}
+	type RequestBody struct {
+		Token string `json:"token" validate:"required"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Consume the Token before anything else, so that it's only ever
+		// used once, & fetch the User it was issued to
+		userToken, err := userTokenRepo.Consume(models.UserTokenChangeEmail, hashSecretToken(requestBody.Token))
+		if err == sql.ErrNoRows {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("The token is invalid or has expired.")
+			return
+		}
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		user, err := userRepo.FetchByID(userToken.UserId)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// Switch the User over to the new address, which the token proves
+		// they own.
+		previousEmail := user.Email
+		verifiedAt := time.Now()
+		user.Email = userToken.Email
+		user.VerifiedAt = &verifiedAt
+		err = userRepo.UpdateEmail(user)
 		if err != nil {
 			pqErr, ok := err.(*pq.Error)
 			if ok {
@@ -59,6 +455,32 @@ func (s *Server) createUser() http.HandlerFunc {
 			return
 		}
 
+		// Any other tokens are revoked, & every session signed in under the
+		// old address is signed out, along with its API keys.
+		err = userTokenRepo.DeleteAllUserTokens(user.Id, models.UserTokenChangeEmail)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = refreshTokenRepo.DeleteAllUserChains(user.Id)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		err = apiKeyRepo.DeleteAllUserKeys(user.Id)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		clearRefreshTokenCookie(w)
+
+		// Let the old address know, in case it wasn't them
+		s.Mailer.Send(mail.Message{
+			To:      previousEmail,
+			Subject: "Your BeanPay email address was changed",
+			Body:    fmt.Sprintf("The email address of your BeanPay account was just changed to %v, and every device was signed out and every API key revoked.", user.Email),
+		})
+
 		// OK
 		resp.SetResult(http.StatusOK, nil)
 	}
@@ -3,17 +3,24 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/jwt"
+	"github.com/beanpay/api/server/middleware"
+	"github.com/beanpay/api/server/throttle"
 	"github.com/generalledger/response"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 type CreateUserBody struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	HomeCurrency string `json:"home_currency,omitempty"`
+	TimeZone     string `json:"time_zone,omitempty"`
 }
 
 func (c *CreateUserBody) Read(p []byte) (n int, err error) {
@@ -104,6 +111,30 @@ func TestCreateUser(t *testing.T) {
 		response.Parse(recorder.Result().Body),
 	)
 
+	// Test that the home currency & time zone are validated
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/users",
+		&CreateUserBody{
+			Email:        "name@example.com",
+			Password:     "some-password",
+			HomeCurrency: "EURO",
+			TimeZone:     "Pacific/Standard",
+		},
+	)
+	server.createUser()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode: http.StatusBadRequest,
+			StatusText: http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{
+				"HomeCurrency must be a valid ISO-4217 currency code",
+				"TimeZone must be a valid IANA time zone, e.g. America/New_York",
+			},
+			Result: nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
 	// Test the Successful creation of a user
 	recorder = httptest.NewRecorder()
 	req = httptest.NewRequest(http.MethodPost, "/users",
@@ -144,3 +175,402 @@ func TestCreateUser(t *testing.T) {
 	)
 
 }
+
+func TestFetchCurrentUser(t *testing.T) {
+	// Prepare the Server & seed some data
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	user1 := server.SeedUser()
+	server.SeedUser()
+
+	// Validate auth is required
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+	server.fetchCurrentUser()(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Ensure that user1 sees their own profile
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/users/me", user1["id"].(string), nil)
+	server.fetchCurrentUser()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusOK,
+			StatusText:   http.StatusText(http.StatusOK),
+			ErrorDetails: nil,
+			Result:       user1,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+}
+
+type UpdateUserBody map[string]interface{}
+
+func (u UpdateUserBody) Read(p []byte) (n int, err error) {
+	b, _ := json.Marshal(u)
+	return bytes.NewReader(b).Read(p)
+}
+
+func TestUpdateCurrentUser(t *testing.T) {
+	// Prepare the Server & seed some data
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	user1 := server.SeedUser()
+
+	// Validate auth is required
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPatch, "/users/me", nil)
+	server.updateCurrentUser()(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Test that we cannot send a request with a misformated request body
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPatch, "/users/me", user1["id"].(string), strings.NewReader("-"))
+	server.updateCurrentUser()(recorder, req)
+	assert.Equal(t,
+		&[]string{"Failed to parse the request body."},
+		response.Parse(recorder.Result().Body).ErrorDetails,
+	)
+
+	// Test that we are performing field level validation checks
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPatch, "/users/me", user1["id"].(string), UpdateUserBody{
+		"time_zone":            "PST",
+		"home_currency":        "",
+		"week_start":           "someday",
+		"reminder_days_before": 31,
+	})
+	server.updateCurrentUser()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode: http.StatusBadRequest,
+			StatusText: http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{
+				"TimeZone must be a valid IANA time zone, e.g. America/New_York",
+				"HomeCurrency must be a valid ISO-4217 currency code",
+				"WeekStart must be one of [monday tuesday wednesday thursday friday saturday sunday]",
+				"ReminderDaysBefore must be 30 or less",
+			},
+			Result: nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Test that we can update some preferences, leaving the rest alone
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPatch, "/users/me", user1["id"].(string), UpdateUserBody{
+		"display_name":         "Sam",
+		"time_zone":            "Europe/Berlin",
+		"reminders_enabled":    false,
+		"reminder_days_before": 0,
+	})
+	server.updateCurrentUser()(recorder, req)
+	resp := response.Parse(recorder.Result().Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result := resp.Result.(map[string]interface{})
+	assert.Equal(t, "Sam", result["display_name"])
+	assert.Equal(t, "Europe/Berlin", result["time_zone"])
+	assert.Equal(t, false, result["reminders_enabled"])
+	assert.Equal(t, float64(0), result["reminder_days_before"])
+	assert.Equal(t, user1["home_currency"], result["home_currency"])
+	assert.Equal(t, user1["week_start"], result["week_start"])
+	assert.Equal(t, user1["email"], result["email"])
+
+	// Ensure the changes were stored
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/users/me", user1["id"].(string), nil)
+	server.fetchCurrentUser()(recorder, req)
+	assert.Equal(t, "Sam", response.Parse(recorder.Result().Body).Result.(map[string]interface{})["display_name"])
+}
+
+// seedLoggedInUser creates a user with a known password through the API &
+// logs them in, returning their id & refresh token.
+func seedLoggedInUser(t *testing.T, server *TestServer, email string, password string) (string, string) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/users", &CreateUserBody{Email: email, Password: password})
+	server.createUser()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: email, Password: password})
+	server.login()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	refreshToken := ""
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == "refresh_token" {
+			refreshToken = cookie.Value
+		}
+	}
+	userRepo := models.UserRepository{DB: server.DB}
+	user, err := userRepo.FetchByEmail(email)
+	assert.Nil(t, err)
+	return user.Id, refreshToken
+}
+
+// refreshStatus returns the status code of refreshing with a refresh token.
+func refreshStatus(server *TestServer, refreshToken string) int {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: refreshToken})
+	server.authRefresh()(recorder, req)
+	return response.Parse(recorder.Result().Body).StatusCode
+}
+
+func TestChangePassword(t *testing.T) {
+	// Prepare the Server & a logged in user
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	userId, refreshToken := seedLoggedInUser(t, server, "name@example.com", "some-password")
+	apiKey := seedApiKey(t, server, userId)
+
+	// Validate auth is required
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/users/me/password", nil)
+	server.changePassword()(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Test that we are validating our request body
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/password", userId, UpdateUserBody{
+		"new_password": "short",
+	})
+	server.changePassword()(recorder, req)
+	assert.Equal(t,
+		&[]string{
+			"CurrentPassword is a required field",
+			"NewPassword must be at least 8 characters in length",
+		},
+		response.Parse(recorder.Result().Body).ErrorDetails,
+	)
+
+	// Test that the current password must be correct
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/password", userId, UpdateUserBody{
+		"current_password": "not-the-password",
+		"new_password":     "a-brand-new-password",
+	})
+	server.changePassword()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusForbidden,
+			StatusText:   http.StatusText(http.StatusForbidden),
+			ErrorDetails: &[]string{"The current password is incorrect."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+	assert.Equal(t, http.StatusOK, refreshStatus(server, refreshToken))
+
+	// Change the password
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/password", userId, UpdateUserBody{
+		"current_password": "some-password",
+		"new_password":     "a-brand-new-password",
+	})
+	server.changePassword()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	message, ok := server.Outbox().Last()
+	assert.True(t, ok)
+	assert.Equal(t, "name@example.com", message.To)
+
+	// Ensure every session was signed out & every API key revoked
+	assert.Equal(t, http.StatusUnauthorized, refreshStatus(server, refreshToken))
+	assert.Equal(t, http.StatusUnauthorized, apiKeyStatus(server, apiKey))
+
+	// Ensure only the new password can be used to login
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: "name@example.com", Password: "some-password"})
+	server.login()(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: "name@example.com", Password: "a-brand-new-password"})
+	server.login()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+}
+
+func TestChangeEmail(t *testing.T) {
+	// Prepare the Server & a logged in user
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	userId, refreshToken := seedLoggedInUser(t, server, "name@example.com", "some-password")
+	apiKey := seedApiKey(t, server, userId)
+	otherUser := server.SeedUser()
+	sentMessages := len(server.Outbox().Messages())
+
+	// Validate auth is required
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/users/me/email", nil)
+	server.requestEmailChange()(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Test that we are validating our request body
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/email", userId, UpdateUserBody{
+		"email": "invalid-email",
+	})
+	server.requestEmailChange()(recorder, req)
+	assert.Equal(t,
+		&[]string{
+			"Email must be a valid email address",
+			"Password is a required field",
+		},
+		response.Parse(recorder.Result().Body).ErrorDetails,
+	)
+
+	// Test that the password must be correct
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/email", userId, UpdateUserBody{
+		"email":    "new-name@example.com",
+		"password": "not-the-password",
+	})
+	server.requestEmailChange()(recorder, req)
+	assert.Equal(t, http.StatusForbidden, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Test that the address cannot belong to another user
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/email", userId, UpdateUserBody{
+		"email":    otherUser["email"],
+		"password": "some-password",
+	})
+	server.requestEmailChange()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusConflict,
+			StatusText:   http.StatusText(http.StatusConflict),
+			ErrorDetails: &[]string{"Email is already in use by another user"},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+	assert.Equal(t, sentMessages, len(server.Outbox().Messages()))
+
+	// Request the change, which mails a token to the new address
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/email", userId, UpdateUserBody{
+		"email":    "new-name@example.com",
+		"password": "some-password",
+	})
+	server.requestEmailChange()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	message, ok := server.Outbox().Last()
+	assert.True(t, ok)
+	assert.Equal(t, "new-name@example.com", message.To)
+	assert.Contains(t, message.Body, "https://app.example.com/confirm-email?token=")
+	token := mailedToken(message.Body)
+
+	// Nothing changes until the change is confirmed
+	userRepo := models.UserRepository{DB: server.DB}
+	user, err := userRepo.FetchByID(userId)
+	assert.Nil(t, err)
+	assert.Equal(t, "name@example.com", user.Email)
+	assert.Equal(t, http.StatusOK, refreshStatus(server, refreshToken))
+	assert.Equal(t, http.StatusOK, apiKeyStatus(server, apiKey))
+
+	// Ensure invalid tokens are rejected
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/users/email/confirm", UpdateUserBody{"token": "invalid-token"})
+	server.confirmEmailChange()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusBadRequest,
+			StatusText:   http.StatusText(http.StatusBadRequest),
+			ErrorDetails: &[]string{"The token is invalid or has expired."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Confirm the change
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/users/email/confirm", UpdateUserBody{"token": token})
+	server.confirmEmailChange()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	user, err = userRepo.FetchByID(userId)
+	assert.Nil(t, err)
+	assert.Equal(t, "new-name@example.com", user.Email)
+	message, _ = server.Outbox().Last()
+	assert.Equal(t, "name@example.com", message.To)
+
+	// Ensure every session was signed out, every API key revoked, & the
+	// token can't be reused
+	assert.Equal(t, http.StatusUnauthorized, refreshStatus(server, refreshToken))
+	assert.Equal(t, http.StatusUnauthorized, apiKeyStatus(server, apiKey))
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/users/email/confirm", UpdateUserBody{"token": token})
+	server.confirmEmailChange()(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, response.Parse(recorder.Result().Body).StatusCode)
+}
+
+func TestCredentialChangeThrottle(t *testing.T) {
+	// Prepare the Server & a logged in user, throttling accounts after 2
+	// failures
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	userId, _ := seedLoggedInUser(t, server, "name@example.com", "some-password")
+	server.AccountLoginThrottle = &throttle.Throttle{Store: throttle.NewMemoryStore(), Allowed: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+
+	// API keys can't be used to change credentials
+	apiKey := seedApiKey(t, server, userId)
+	requireAuth := middleware.GetRequireAuthMiddleware(server.JwtSignatory, server.authenticateApiKey)
+	for _, handler := range []http.HandlerFunc{server.changePassword(), server.requestEmailChange()} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/users/me/password", UpdateUserBody{
+			"current_password": "some-password",
+			"new_password":     "a-brand-new-password",
+			"email":            "new-name@example.com",
+			"password":         "some-password",
+		})
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		requireAuth(handler, jwt.ScopeWrite)(recorder, req)
+		assert.Equal(t, http.StatusForbidden, response.Parse(recorder.Result().Body).StatusCode)
+	}
+
+	// Guessing the current password is throttled, even once it's right
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		req := server.NewAuthenticatedRequest(http.MethodPost, "/users/me/password", userId, UpdateUserBody{
+			"current_password": "not-the-password",
+			"new_password":     "a-brand-new-password",
+		})
+		server.changePassword()(recorder, req)
+		assert.Equal(t, http.StatusForbidden, response.Parse(recorder.Result().Body).StatusCode)
+	}
+	recorder := httptest.NewRecorder()
+	req := server.NewAuthenticatedRequest(http.MethodPost, "/users/me/password", userId, UpdateUserBody{
+		"current_password": "some-password",
+		"new_password":     "a-brand-new-password",
+	})
+	server.changePassword()(recorder, req)
+	assert.Equal(t, http.StatusTooManyRequests, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/email", userId, UpdateUserBody{
+		"email":    "new-name@example.com",
+		"password": "some-password",
+	})
+	server.requestEmailChange()(recorder, req)
+	assert.Equal(t, http.StatusTooManyRequests, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Once the lockout is over, the right password resets the failures
+	assert.Nil(t, server.AccountLoginThrottle.Reset("account:name@example.com"))
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/email", userId, UpdateUserBody{
+		"email":    "new-name@example.com",
+		"password": "not-the-password",
+	})
+	server.requestEmailChange()(recorder, req)
+	assert.Equal(t, http.StatusForbidden, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodPost, "/users/me/email", userId, UpdateUserBody{
+		"email":    "new-name@example.com",
+		"password": "some-password",
+	})
+	server.requestEmailChange()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	_, err = server.AccountLoginThrottle.Store.FetchByKey("account:name@example.com")
+	assert.NotNil(t, err)
+}
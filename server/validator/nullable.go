@@ -0,0 +1,43 @@
+package validator
+
+import (
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"reflect"
+)
+
+// Nullable is a field of a request body that can be left out, to leave it
+// as it is, or sent as null, to clear it, which a pointer can't tell apart.
+// Its validation tags apply to its Value, which is the zero value unless
+// something other than null was sent.
+type Nullable[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+func (n *Nullable[T]) UnmarshalJSON(b []byte) error {
+	n.Set = true
+	if string(b) == "null" {
+		n.Null = true
+		return nil
+	}
+	return json.Unmarshal(b, &n.Value)
+}
+
+// validatedValue is what the validation tags of a Nullable are run on.
+func (n Nullable[T]) validatedValue() interface{} {
+	return n.Value
+}
+
+// nullableTypes are the Nullable fields that request bodies can have.
+var nullableTypes = []interface{}{
+	Nullable[string]{},
+	Nullable[int]{},
+}
+
+func registerNullableTypes(validate *validator.Validate) {
+	validate.RegisterCustomTypeFunc(func(field reflect.Value) interface{} {
+		return field.Interface().(interface{ validatedValue() interface{} }).validatedValue()
+	}, nullableTypes...)
+}
@@ -1,10 +1,12 @@
 package validator
 
 import (
+	"github.com/beanpay/api/database/models"
 	"github.com/go-playground/locales/en"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
 	en_translations "github.com/go-playground/validator/v10/translations/en"
+	"time"
 )
 
 // Create a go-playground/validator, but wrap it in our generic Validator interface
@@ -15,6 +17,8 @@ func New() Validator {
 	uni := ut.New(en, en)
 	transEn, _ := uni.GetTranslator("en")
 	en_translations.RegisterDefaultTranslations(validate, transEn)
+	registerCustomValidations(validate, transEn)
+	registerNullableTypes(validate)
 
 	return &playgroundValidator{
 		validate:            validate,
@@ -39,3 +43,58 @@ func (g *playgroundValidator) Validate(inputStruct interface{}) ([]string, error
 	}
 	return messages, err
 }
+
+// customValidations are the validation tags we support beyond the ones
+// built into go-playground/validator, with their english translations.
+var customValidations = []struct {
+	tag         string
+	fn          validator.Func
+	translation string
+}{
+	{
+		tag: "money",
+		fn: func(fl validator.FieldLevel) bool {
+			return models.IsValidAmount(fl.Field().String())
+		},
+		translation: "{0} must be an amount with at most two decimal places",
+	},
+	{
+		tag: "currency",
+		fn: func(fl validator.FieldLevel) bool {
+			return iso4217Currencies[fl.Field().String()]
+		},
+		translation: "{0} must be a valid ISO-4217 currency code",
+	},
+	{
+		tag: "timezone",
+		fn: func(fl validator.FieldLevel) bool {
+			// LoadLocation treats "" as UTC & "Local" as the server's zone,
+			// neither of which is a time zone a user can live in.
+			name := fl.Field().String()
+			if name == "" || name == "Local" {
+				return false
+			}
+			_, err := time.LoadLocation(name)
+			return err == nil
+		},
+		translation: "{0} must be a valid IANA time zone, e.g. America/New_York",
+	},
+}
+
+func registerCustomValidations(validate *validator.Validate, trans ut.Translator) {
+	for _, v := range customValidations {
+		tag, translation := v.tag, v.translation
+		validate.RegisterValidation(tag, v.fn)
+		validate.RegisterTranslation(
+			tag,
+			trans,
+			func(ut ut.Translator) error {
+				return ut.Add(tag, translation, true)
+			},
+			func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T(fe.Tag(), fe.Field())
+				return t
+			},
+		)
+	}
+}
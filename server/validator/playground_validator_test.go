@@ -1,6 +1,7 @@
 package validator
 
 import (
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -32,3 +33,71 @@ func TestValidator(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, []string{}, messages)
 }
+
+type MoneyRequestBody struct {
+	Amount   string `json:"amount" validate:"money"`
+	Currency string `json:"currency" validate:"currency"`
+	TimeZone string `json:"time_zone" validate:"timezone"`
+}
+
+func TestValidatorCustomValidations(t *testing.T) {
+	v := New()
+
+	// Test that errors are thrown when failing against the validator
+	messages, err := v.Validate(MoneyRequestBody{
+		Amount:   "19.999",
+		Currency: "usd",
+		TimeZone: "Mars/Olympus_Mons",
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{
+		"Amount must be an amount with at most two decimal places",
+		"Currency must be a valid ISO-4217 currency code",
+		"TimeZone must be a valid IANA time zone, e.g. America/New_York",
+	}, messages)
+	_, err = v.Validate(MoneyRequestBody{Amount: "1", Currency: "EUR", TimeZone: "Local"})
+	assert.NotNil(t, err)
+
+	// Test that errors aren't thrown for a successful validation
+	messages, err = v.Validate(MoneyRequestBody{
+		Amount:   "19.99",
+		Currency: "EUR",
+		TimeZone: "America/Los_Angeles",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, messages)
+}
+
+type NullableRequestBody struct {
+	Notes Nullable[string] `json:"notes" validate:"max=5"`
+}
+
+func TestValidatorNullable(t *testing.T) {
+	v := New()
+
+	// Fields that are left out, sent as null, or sent with a value can be
+	// told apart
+	for _, test := range []struct {
+		body     string
+		expected Nullable[string]
+	}{
+		{`{}`, Nullable[string]{}},
+		{`{"notes":null}`, Nullable[string]{Set: true, Null: true}},
+		{`{"notes":""}`, Nullable[string]{Set: true}},
+		{`{"notes":"abc"}`, Nullable[string]{Set: true, Value: "abc"}},
+	} {
+		var requestBody NullableRequestBody
+		err := json.Unmarshal([]byte(test.body), &requestBody)
+		assert.Nil(t, err, test.body)
+		assert.Equal(t, test.expected, requestBody.Notes, test.body)
+		_, err = v.Validate(requestBody)
+		assert.Nil(t, err, test.body)
+	}
+
+	// The value is validated, & must be of the right type
+	messages, err := v.Validate(NullableRequestBody{Notes: Nullable[string]{Set: true, Value: "too long"}})
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"Notes must be a maximum of 5 characters in length"}, messages)
+	var requestBody NullableRequestBody
+	assert.NotNil(t, json.Unmarshal([]byte(`{"notes":5}`), &requestBody))
+}
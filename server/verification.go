@@ -0,0 +1,205 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/beanpay/api/database/models"
+	"github.com/beanpay/api/server/jwt"
+	"github.com/beanpay/api/server/mail"
+	"github.com/generalledger/response"
+	"net/http"
+	"time"
+)
+
+// The policies for users that haven't verified their email address yet.
+// Optional users can do everything, restricted users can sign in & manage
+// their account but can't use the rest of the API, & required users can't
+// sign in at all.
+const (
+	EmailVerificationOptional = "optional"
+	EmailVerificationRestrict = "restrict"
+	EmailVerificationRequired = "required"
+)
+
+// emailVerificationTokenDuration is how long the link to verify an email
+// address stays valid.
+const emailVerificationTokenDuration = 72 * time.Hour
+
+// sendVerificationEmail issues a token to the User, replacing any that were
+// issued before, & mails it to their address.
+func (s *Server) sendVerificationEmail(user *models.User) error {
+	userTokenRepo := models.UserTokenRepository{DB: s.DB}
+	token, tokenHash, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	err = userTokenRepo.DeleteAllUserTokens(user.Id, models.UserTokenVerifyEmail)
+	if err != nil {
+		return err
+	}
+	err = userTokenRepo.Insert(&models.UserToken{
+		UserId:    user.Id,
+		Purpose:   models.UserTokenVerifyEmail,
+		Email:     user.Email,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(emailVerificationTokenDuration),
+	})
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your BeanPay email address",
+		Body: fmt.Sprintf(
+			"Welcome to BeanPay! Follow this link within 3 days to verify your email address:\n\n%v/verify-email?token=%v",
+			s.AppURL,
+			token,
+		),
+	})
+}
+
+// requireVerified rejects users that haven't verified their email address
+// yet, when the EmailVerification policy restricts them. It expects the
+// request to have been through the requireAuth middleware. Requests whose
+// user can't be checked are turned away too, rather than let through.
+func (s *Server) requireVerified(next http.HandlerFunc) http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.EmailVerification != EmailVerificationRestrict {
+			next(w, r)
+			return
+		}
+		resp := response.New(w)
+		claims, ok := r.Context().Value("jwtClaims").(jwt.Claims)
+		if !ok {
+			resp.SetResult(http.StatusUnauthorized, nil).Output()
+			return
+		}
+		user, err := userRepo.FetchByID(claims.UserID)
+		if err == sql.ErrNoRows {
+			resp.SetResult(http.StatusUnauthorized, nil).Output()
+			return
+		}
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil).Output()
+			return
+		}
+		if !user.IsVerified() {
+			resp.SetResult(http.StatusForbidden, nil).
+				WithErrorDetails("Please verify your email address first.").
+				Output()
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) verifyEmail() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	userTokenRepo := models.UserTokenRepository{DB: s.DB}
+	type RequestBody struct {
+		Token string `json:"token" validate:"required"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Consume the Token before anything else, so that it's only ever
+		// used once, & fetch the User it was issued to. A token mailed to an
+		// address the User has since moved away from is no longer honoured.
+		userToken, err := userTokenRepo.Consume(models.UserTokenVerifyEmail, hashSecretToken(requestBody.Token))
+		if err == sql.ErrNoRows {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("The token is invalid or has expired.")
+			return
+		}
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+		user, err := userRepo.FetchByID(userToken.UserId)
+		if err != nil || user.Email != userToken.Email {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("The token is invalid or has expired.")
+			return
+		}
+
+		// Mark the address as verified
+		err = userRepo.MarkVerified(user, time.Now())
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// Any other tokens are revoked
+		err = userTokenRepo.DeleteAllUserTokens(user.Id, models.UserTokenVerifyEmail)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, nil)
+	}
+}
+
+func (s *Server) resendVerificationEmail() http.HandlerFunc {
+	userRepo := models.UserRepository{DB: s.DB}
+	type RequestBody struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response.New(w)
+		defer resp.Output()
+
+		//  Parse & Validate the Body
+		var requestBody RequestBody
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails("Failed to parse the request body.")
+			return
+		}
+		messages, err := s.Validator.Validate(requestBody)
+		if err != nil {
+			resp.SetResult(http.StatusBadRequest, nil).
+				WithErrorDetails(messages...)
+			return
+		}
+
+		// Fetch the User. We respond the same way whether or not there's an
+		// unverified account for the address, so this can't be used to find
+		// out who has one.
+		user, err := userRepo.FetchByEmail(requestBody.Email)
+		if err != nil || user.IsVerified() {
+			resp.SetResult(http.StatusOK, nil)
+			return
+		}
+
+		// Mail a new token
+		err = s.sendVerificationEmail(user)
+		if err != nil {
+			resp.SetResult(http.StatusInternalServerError, nil)
+			return
+		}
+
+		// OK
+		resp.SetResult(http.StatusOK, nil)
+	}
+}
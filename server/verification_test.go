@@ -0,0 +1,162 @@
+package server
+
+import (
+	"github.com/beanpay/api/database/models"
+	"github.com/generalledger/response"
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// mailedToken pulls the token out of the link in a Message.
+func mailedToken(message string) string {
+	token := message[strings.Index(message, "token=")+len("token="):]
+	if end := strings.Index(token, "\n"); end != -1 {
+		token = token[:end]
+	}
+	return token
+}
+
+func TestEmailVerification(t *testing.T) {
+	// Prepare the Server, requiring verification to sign in
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	server.EmailVerification = EmailVerificationRequired
+
+	// Signing up mails a token to the new user
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/users", &AuthBody{Email: realUserEmail, Password: realUserPassword})
+	server.createUser()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	message, ok := server.Outbox().Last()
+	assert.True(t, ok)
+	assert.Equal(t, realUserEmail, message.To)
+	assert.Contains(t, message.Body, "https://app.example.com/verify-email?token=")
+	firstToken := mailedToken(message.Body)
+
+	// Ensure the user can't login until they've verified their address
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: realUserEmail, Password: realUserPassword})
+	server.login()(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusForbidden,
+			StatusText:   http.StatusText(http.StatusForbidden),
+			ErrorDetails: &[]string{"Please verify your email address before logging in."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Ask for another token, which replaces the first one
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/verify-email/resend", UpdateUserBody{"email": realUserEmail})
+	server.resendVerificationEmail()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	message, _ = server.Outbox().Last()
+	secondToken := mailedToken(message.Body)
+	assert.NotEqual(t, firstToken, secondToken)
+
+	// Ensure invalid & replaced tokens are rejected
+	for _, token := range []string{"invalid-token", firstToken} {
+		recorder = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodPost, "/auth/verify-email", UpdateUserBody{"token": token})
+		server.verifyEmail()(recorder, req)
+		assert.Equal(t,
+			response.Response{
+				StatusCode:   http.StatusBadRequest,
+				StatusText:   http.StatusText(http.StatusBadRequest),
+				ErrorDetails: &[]string{"The token is invalid or has expired."},
+				Result:       nil,
+			},
+			response.Parse(recorder.Result().Body),
+		)
+	}
+
+	// Verify the address
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/verify-email", UpdateUserBody{"token": secondToken})
+	server.verifyEmail()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	userRepo := models.UserRepository{DB: server.DB}
+	user, err := userRepo.FetchByEmail(realUserEmail)
+	assert.Nil(t, err)
+	assert.True(t, user.IsVerified())
+
+	// Ensure the token can't be reused
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/verify-email", UpdateUserBody{"token": secondToken})
+	server.verifyEmail()(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Ensure the user can now login, & that verified users aren't mailed again
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", &AuthBody{Email: realUserEmail, Password: realUserPassword})
+	server.login()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	sentMessages := len(server.Outbox().Messages())
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/auth/verify-email/resend", UpdateUserBody{"email": realUserEmail})
+	server.resendVerificationEmail()(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+	assert.Equal(t, sentMessages, len(server.Outbox().Messages()))
+}
+
+func TestRequireVerified(t *testing.T) {
+	// Prepare the Server & an unverified user
+	server, err := NewTestServer()
+	assert.Nil(t, err)
+	defer server.Shutdown()
+	user := server.SeedUser()
+	handler := server.requireVerified(server.fetchBills())
+
+	// Unverified users can use everything when verification is optional
+	recorder := httptest.NewRecorder()
+	req := server.NewAuthenticatedRequest(http.MethodGet, "/bills", user["id"].(string), nil)
+	handler(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+
+	// But are turned away when they're restricted
+	server.EmailVerification = EmailVerificationRestrict
+	handler = server.requireVerified(server.fetchBills())
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/bills", user["id"].(string), nil)
+	handler(recorder, req)
+	assert.Equal(t,
+		response.Response{
+			StatusCode:   http.StatusForbidden,
+			StatusText:   http.StatusText(http.StatusForbidden),
+			ErrorDetails: &[]string{"Please verify your email address first."},
+			Result:       nil,
+		},
+		response.Parse(recorder.Result().Body),
+	)
+
+	// Requests whose user can't be checked are turned away too
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/bills", nil)
+	handler(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/bills", uuid.NewV4().String(), nil)
+	handler(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Parse(recorder.Result().Body).StatusCode)
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/bills", "some-fake-uuid", nil)
+	handler(recorder, req)
+	assert.Equal(t, http.StatusInternalServerError, response.Parse(recorder.Result().Body).StatusCode)
+
+	// Until they've verified their address
+	userRepo := models.UserRepository{DB: server.DB}
+	verifiedUser, err := userRepo.FetchByID(user["id"].(string))
+	assert.Nil(t, err)
+	assert.Nil(t, userRepo.MarkVerified(verifiedUser, verifiedUser.CreatedAt))
+	recorder = httptest.NewRecorder()
+	req = server.NewAuthenticatedRequest(http.MethodGet, "/bills", user["id"].(string), nil)
+	handler(recorder, req)
+	assert.Equal(t, http.StatusOK, response.Parse(recorder.Result().Body).StatusCode)
+}